@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/xd-deng/rediseen/types"
+)
+
+// Modes of IP access control, telling how the allowed IP addresses combine with API key authentication
+const (
+	ipAuthModeBoth   = "both"   // clients must be from allowed IP addresses, and give the API key (if set)
+	ipAuthModeEither = "either" // clients from allowed IP addresses need no API key, while others must give it
+)
+
+// forwardedForHeader gives the addresses of the client and the proxies a request went through, appended by each proxy
+const forwardedForHeader = "X-Forwarded-For"
+
+// cidrList is a list of IP address ranges
+type cidrList []*net.IPNet
+
+// parseCIDRList parses semicolon-separated CIDRs, like `10.0.0.0/8;192.168.1.7;fd00::/8`. Single IP addresses
+// are ranges of their own
+func parseCIDRList(config string) (cidrList, error) {
+	var list cidrList
+	for _, raw := range strings.Split(config, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if !strings.Contains(raw, "/") {
+			ip := net.ParseIP(raw)
+			if ip == nil {
+				return nil, fmt.Errorf("`%s` is not a valid IP address or CIDR", raw)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("`%s` is not a valid IP address or CIDR", raw)
+		}
+		list = append(list, ipNet)
+	}
+	return list, nil
+}
+
+// contains tells if the IP address given is in any of the ranges
+func (l cidrList) contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range l {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAccessConfig is access control by IP addresses of clients
+type ipAccessConfig struct {
+	allowed cidrList // nil if any IP address is allowed
+	denied  cidrList // it takes precedence over allowed
+	mode    string
+}
+
+// configureIPAccess applies REDISEEN_IP_ALLOWED, REDISEEN_IP_DENIED, REDISEEN_IP_AUTH_MODE and
+// REDISEEN_TRUSTED_PROXIES. It must be called after the API key is loaded
+func (c *service) configureIPAccess() error {
+	c.ipAccess = nil
+	var err error
+	c.trustedProxies, err = parseCIDRList(os.Getenv("REDISEEN_TRUSTED_PROXIES"))
+	if err != nil {
+		return fmt.Errorf("REDISEEN_TRUSTED_PROXIES is not valid (details: %s)", err.Error())
+	}
+
+	access := &ipAccessConfig{mode: os.Getenv("REDISEEN_IP_AUTH_MODE")}
+	access.allowed, err = parseCIDRList(os.Getenv("REDISEEN_IP_ALLOWED"))
+	if err != nil {
+		return fmt.Errorf("REDISEEN_IP_ALLOWED is not valid (details: %s)", err.Error())
+	}
+	access.denied, err = parseCIDRList(os.Getenv("REDISEEN_IP_DENIED"))
+	if err != nil {
+		return fmt.Errorf("REDISEEN_IP_DENIED is not valid (details: %s)", err.Error())
+	}
+
+	switch access.mode {
+	case "":
+		access.mode = ipAuthModeBoth
+	case ipAuthModeBoth:
+	case ipAuthModeEither:
+		if access.allowed == nil || !c.authEnforced {
+			return errors.New("REDISEEN_IP_AUTH_MODE `either` needs both REDISEEN_IP_ALLOWED and REDISEEN_API_KEY")
+		}
+	default:
+		return fmt.Errorf("REDISEEN_IP_AUTH_MODE should be `%s` or `%s`", ipAuthModeBoth, ipAuthModeEither)
+	}
+
+	if access.allowed == nil && access.denied == nil {
+		return nil
+	}
+	c.ipAccess = access
+	logger.Info(fmt.Sprintf("Access is controlled by IP addresses (%d range(s) allowed, %d range(s) denied, mode: %s)",
+		len(access.allowed), len(access.denied), access.mode))
+	return nil
+}
+
+// clientIP gives the IP address of the client. Behind trusted proxies, it is the right-most address in
+// X-Forwarded-For which is not a trusted proxy, since addresses on its left may be given by the client itself
+func (c *service) clientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	if !c.trustedProxies.contains(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(req.Header.Values(forwardedForHeader), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !c.trustedProxies.contains(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// checkClientIP responds 403 if the client IP address is denied, or not allowed, in which case ok is false.
+// vouched is true if the client needs no API key, being from an allowed IP address in mode `either`
+func (c *service) checkClientIP(res *responseRecorder, req *http.Request) (vouched bool, ok bool) {
+	if c.ipAccess == nil {
+		return false, true
+	}
+
+	ip := res.clientIP
+	allowed := c.ipAccess.allowed == nil || c.ipAccess.allowed.contains(ip)
+	if c.ipAccess.denied.contains(ip) || (!allowed && c.ipAccess.mode == ipAuthModeBoth) {
+		res.WriteHeader(http.StatusForbidden)
+		js, _ := json.Marshal(types.ErrorType{Error: "forbidden"})
+		res.Write(js)
+		ipRejectedTotal.Inc()
+		res.log.Warn("Request from IP address not allowed", "client_ip", ip, "remote_addr", req.RemoteAddr)
+		return false, false
+	}
+	if allowed && c.ipAccess.mode == ipAuthModeEither {
+		res.caller = "ip:" + ip
+		return true, true
+	}
+	return false, true
+}
@@ -0,0 +1,117 @@
+// Package audit writes one record per data access (or publish) to a separate sink (a rotating file, or syslog).
+// Records are chained with hashes: each record carries the hash of the previous one,
+// so that deleting or modifying records can be detected by verifying the chain.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Outcomes of data accesses
+const (
+	OutcomeSuccess  = "success"
+	OutcomeDenied   = "denied"
+	OutcomeNotFound = "not_found"
+	OutcomeFailed   = "failed"
+)
+
+// Record is one audit entry. Field order is fixed, so the JSON encoding (hence the hash) is deterministic
+type Record struct {
+	Time       string `json:"time"`
+	RequestID  string `json:"request_id,omitempty"`
+	Caller     string `json:"caller"`
+	ClientIP   string `json:"client_ip,omitempty"`
+	RemoteAddr string `json:"remote_addr"`
+	DB         *int   `json:"db,omitempty"` // nil for publishes
+	Key        string `json:"key,omitempty"`
+	Field      string `json:"field,omitempty"`
+	Channel    string `json:"channel,omitempty"`   // of publishes
+	Receivers  *int64 `json:"receivers,omitempty"` // of publishes, if published
+	Outcome    string `json:"outcome"`
+	Status     int    `json:"status"`
+	PrevHash   string `json:"prev_hash"`
+	Hash       string `json:"hash,omitempty"`
+}
+
+// Sink is where audit records are written into, one line per record
+type Sink interface {
+	Write(line []byte) error
+	Close() error
+}
+
+// Logger chains and writes audit records into a Sink
+type Logger struct {
+	mu       sync.Mutex
+	sink     Sink
+	hmacKey  []byte
+	lastHash string
+}
+
+// New creates a Logger. lastHash is the hash of the latest record already written into the sink (if any),
+// so that the chain continues across restarts. If hmacKey is not empty, HMAC-SHA256 is used instead of
+// plain SHA-256, so that the chain can not be recomputed by anyone who does not have the key
+func New(sink Sink, hmacKey []byte, lastHash string) *Logger {
+	return &Logger{sink: sink, hmacKey: hmacKey, lastHash: lastHash}
+}
+
+// Log chains the record to the previous one and writes it into the sink
+func (l *Logger) Log(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.PrevHash = l.lastHash
+	r.Hash = ComputeHash(r, l.hmacKey)
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	err = l.sink.Write(line)
+	if err != nil {
+		return err
+	}
+
+	l.lastHash = r.Hash
+	return nil
+}
+
+// Close closes the underlying sink
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sink.Close()
+}
+
+// ComputeHash computes the hash of a record (whose field Hash is ignored), which includes its PrevHash
+func ComputeHash(r Record, hmacKey []byte) string {
+	r.Hash = ""
+	content, _ := json.Marshal(r)
+
+	if len(hmacKey) > 0 {
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(content)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// OutcomeFromStatus maps an HTTP status code to the outcome of a data access
+func OutcomeFromStatus(status int) string {
+	switch {
+	case status >= 200 && status < 300, status == http.StatusNotModified:
+		return OutcomeSuccess
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return OutcomeDenied
+	case status == http.StatusNotFound:
+		return OutcomeNotFound
+	default:
+		return OutcomeFailed
+	}
+}
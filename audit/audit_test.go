@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRecords(t *testing.T, path string, hmacKey []byte, maxSize int64, maxBackups int, n int) {
+	sink, lastHash, err := OpenFile(path, maxSize, maxBackups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(sink, hmacKey, lastHash)
+	db := 0
+	for i := 0; i < n; i++ {
+		err = l.Log(Record{Time: "2020-01-01T00:00:00Z", Caller: "anonymous", DB: &db, Key: "key:1", Outcome: OutcomeSuccess, Status: 200})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+}
+
+func Test_chain(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	writeRecords(t, path, nil, 0, 0, 3)
+	// the chain continues after reopening
+	writeRecords(t, path, nil, 0, 0, 2)
+
+	result, err := VerifyFiles([]string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Records != 5 || result.Truncated {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func Test_chain_tampered(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	writeRecords(t, path, []byte("secret"), 0, 0, 3)
+	content, _ := ioutil.ReadFile(path)
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+
+	// case-1: wrong HMAC key
+	_, err := VerifyFiles([]string{path}, []byte("another"))
+	if err == nil {
+		t.Error("expected error with wrong HMAC key")
+	}
+
+	// case-2: a record is modified
+	modified := bytes.Replace(lines[1], []byte(`"key:1"`), []byte(`"key:2"`), 1)
+	ioutil.WriteFile(path, bytes.Join([][]byte{lines[0], modified, lines[2]}, []byte("\n")), 0600)
+	result, err := VerifyFiles([]string{path}, []byte("secret"))
+	if err == nil || !strings.Contains(err.Error(), ":2: hash mismatch") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result.Records != 1 {
+		t.Errorf("expected 1 valid record, got %d", result.Records)
+	}
+
+	// case-3: a record is deleted
+	ioutil.WriteFile(path, bytes.Join([][]byte{lines[0], lines[2]}, []byte("\n")), 0600)
+	_, err = VerifyFiles([]string{path}, []byte("secret"))
+	if err == nil || !strings.Contains(err.Error(), ":2: chain is broken") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// case-4: the first records are deleted
+	ioutil.WriteFile(path, bytes.Join([][]byte{lines[1], lines[2]}, []byte("\n")), 0600)
+	result, err = VerifyFiles([]string{path}, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Truncated {
+		t.Error("expected the chain to be reported as truncated")
+	}
+}
+
+func Test_rotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// each record is a bit less than 300 bytes, so each file holds 2 records
+	writeRecords(t, path, nil, 600, 2, 5)
+	writeRecords(t, path, nil, 600, 2, 1)
+
+	files := ChainFiles(path, 2)
+	if len(files) != 3 || files[0] != path+".2" || files[2] != path {
+		t.Fatalf("unexpected files: %v", files)
+	}
+
+	result, err := VerifyFiles(files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Records != 6 || result.Truncated {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// the oldest file is dropped by the next rotation
+	writeRecords(t, path, nil, 600, 2, 2)
+	result, err = VerifyFiles(ChainFiles(path, 2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Records != 6 || !result.Truncated {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func Test_OutcomeFromStatus(t *testing.T) {
+	cases := map[int]string{200: OutcomeSuccess, 304: OutcomeSuccess, 401: OutcomeDenied, 403: OutcomeDenied, 404: OutcomeNotFound, 400: OutcomeFailed, 500: OutcomeFailed}
+	for status, expected := range cases {
+		if OutcomeFromStatus(status) != expected {
+			t.Errorf("status %d: expected %s, got %s", status, expected, OutcomeFromStatus(status))
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// FileSink writes records into a file, which is rotated once it exceeds maxSize bytes.
+// Rotated files are named <path>.1 (the most recent), <path>.2, ..., and at most maxBackups of them are kept
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenFile opens (or creates) the audit file. It also returns the hash of the latest record already written,
+// so that the chain can be continued
+func OpenFile(path string, maxSize int64, maxBackups int) (*FileSink, string, error) {
+	sink := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+
+	lastHash, err := lastRecordHash(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if lastHash == "" && maxBackups > 0 {
+		// The current file may have just been rotated
+		lastHash, err = lastRecordHash(backupPath(path, 1))
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err = sink.open()
+	if err != nil {
+		return nil, "", err
+	}
+	return sink, lastHash, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// Write implements Sink
+func (s *FileSink) Write(line []byte) error {
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line))+1 > s.maxSize {
+		err := s.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(append(line, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close implements Sink
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if s.maxBackups > 0 {
+		os.Remove(backupPath(s.path, s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			if _, err := os.Stat(backupPath(s.path, i)); err == nil {
+				os.Rename(backupPath(s.path, i), backupPath(s.path, i+1))
+			}
+		}
+		err = os.Rename(s.path, backupPath(s.path, 1))
+	} else {
+		err = os.Remove(s.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// ChainFiles returns the existing audit file and its backups, from the oldest to the latest
+func ChainFiles(path string, maxBackups int) []string {
+	var files []string
+	for i := maxBackups; i >= 1; i-- {
+		if _, err := os.Stat(backupPath(path, i)); err == nil {
+			files = append(files, backupPath(path, i))
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
+
+// lastRecordHash returns the hash of the last record in the file, or "" if the file does not exist or is empty
+func lastRecordHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if last == nil {
+		return "", nil
+	}
+
+	var r Record
+	err = json.Unmarshal(last, &r)
+	if err != nil {
+		return "", fmt.Errorf("last record in %s is corrupted (details: %s)", path, err.Error())
+	}
+	return r.Hash, nil
+}
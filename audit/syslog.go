@@ -0,0 +1,33 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"log/syslog"
+)
+
+// SyslogSink writes records into syslog (facility AUTH, severity INFO)
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// OpenSyslog connects to syslog. If network and address are empty, the local syslog server is used.
+// Otherwise network is "udp" or "tcp", and address is like "syslog.example.com:514"
+func OpenSyslog(network string, address string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, "rediseen-audit")
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: w}, nil
+}
+
+// Write implements Sink
+func (s *SyslogSink) Write(line []byte) error {
+	return s.writer.Info(string(line))
+}
+
+// Close implements Sink
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
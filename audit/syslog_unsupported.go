@@ -0,0 +1,26 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package audit
+
+import (
+	"errors"
+)
+
+// SyslogSink is not supported on this platform
+type SyslogSink struct{}
+
+// OpenSyslog always fails, since syslog is not supported on this platform
+func OpenSyslog(network string, address string) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// Write implements Sink
+func (s *SyslogSink) Write(line []byte) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+// Close implements Sink
+func (s *SyslogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const maxRecordSize = 1024 * 1024
+
+// VerifyResult summarizes the verification of a chain
+type VerifyResult struct {
+	Records int
+	// Truncated is true if the first record verified points to a previous record which is not available,
+	// which is expected when the oldest files were removed by rotation
+	Truncated bool
+}
+
+// VerifyFiles checks the hash chain across the files given (from the oldest to the latest).
+// It returns an error pointing to the first record which is modified, or whose previous record was deleted
+func VerifyFiles(paths []string, hmacKey []byte) (VerifyResult, error) {
+	var result VerifyResult
+	var prevHash string
+	first := true
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return result, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			var r Record
+			err = json.Unmarshal(scanner.Bytes(), &r)
+			if err != nil {
+				f.Close()
+				return result, fmt.Errorf("%s:%d: record can not be parsed (details: %s)", path, line, err.Error())
+			}
+
+			if first {
+				result.Truncated = r.PrevHash != ""
+				first = false
+			} else if r.PrevHash != prevHash {
+				f.Close()
+				return result, fmt.Errorf("%s:%d: chain is broken, previous record is missing or modified", path, line)
+			}
+
+			if ComputeHash(r, hmacKey) != r.Hash {
+				f.Close()
+				return result, fmt.Errorf("%s:%d: hash mismatch, record is modified", path, line)
+			}
+
+			prevHash = r.Hash
+			result.Records++
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return result, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/xd-deng/rediseen/audit"
+)
+
+const defaultAuditFileMaxSizeMB = 100
+const defaultAuditFileMaxBackups = 10
+
+const callerAnonymous = "anonymous"
+
+// auditConfig is loaded from REDISEEN_AUDIT_* environment variables
+type auditConfig struct {
+	sink          string
+	file          string
+	maxSizeMB     int
+	maxBackups    int
+	syslogNetwork string
+	syslogAddress string
+	hmacKey       []byte
+}
+
+func loadAuditConfigFromEnv() (auditConfig, error) {
+	var config auditConfig
+	var err error
+
+	config.sink = os.Getenv("REDISEEN_AUDIT_SINK")
+	config.file = os.Getenv("REDISEEN_AUDIT_FILE")
+	config.hmacKey = []byte(os.Getenv("REDISEEN_AUDIT_HMAC_KEY"))
+
+	config.maxSizeMB, err = intFromEnv("REDISEEN_AUDIT_FILE_MAX_SIZE_MB", defaultAuditFileMaxSizeMB)
+	if err != nil {
+		return config, err
+	}
+	config.maxBackups, err = intFromEnv("REDISEEN_AUDIT_FILE_MAX_BACKUPS", defaultAuditFileMaxBackups)
+	if err != nil {
+		return config, err
+	}
+
+	switch config.sink {
+	case "":
+	case "file":
+		if config.file == "" {
+			return config, errors.New("REDISEEN_AUDIT_FILE is not configured")
+		}
+	case "syslog":
+		syslogAddress := os.Getenv("REDISEEN_AUDIT_SYSLOG_ADDRESS")
+		if syslogAddress != "" {
+			u, err := url.Parse(syslogAddress)
+			if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+				return config, errors.New("REDISEEN_AUDIT_SYSLOG_ADDRESS should be like `udp://<host>:<port>` or `tcp://<host>:<port>`")
+			}
+			config.syslogNetwork, config.syslogAddress = u.Scheme, u.Host
+		}
+	default:
+		return config, fmt.Errorf("unsupported sink `%s` (supported: file, syslog)", config.sink)
+	}
+
+	return config, nil
+}
+
+// intFromEnv reads a non-negative integer from the environment variable given, or returns defaultValue if it is not set
+func intFromEnv(name string, defaultValue int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%s should be a non-negative integer", name)
+	}
+	return v, nil
+}
+
+// openAuditLogger opens the sink configured. It returns nil if audit log is not enabled
+func openAuditLogger(config auditConfig) (*audit.Logger, error) {
+	switch config.sink {
+	case "file":
+		sink, lastHash, err := audit.OpenFile(config.file, int64(config.maxSizeMB)*1024*1024, config.maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		return audit.New(sink, config.hmacKey, lastHash), nil
+	case "syslog":
+		sink, err := audit.OpenSyslog(config.syslogNetwork, config.syslogAddress)
+		if err != nil {
+			return nil, err
+		}
+		return audit.New(sink, config.hmacKey, ""), nil
+	}
+	return nil, nil
+}
+
+// callerIdentity tells who is calling. Since API keys are secrets, only their fingerprints are used
+func callerIdentity(apiKey string) string {
+	if apiKey == "" {
+		return callerAnonymous
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "api-key:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// auditDataAccess writes the audit record of a finished request, if it accessed data (i.e. /<db>, /<db>/<key>
+// or /<db>/<key>/<index or field>) or published (see auditPublish). Attempts rejected by authentication or
+// IP access control are audited as well. Batch reads are audited with one record per key
+func (c *service) auditDataAccess(res *responseRecorder, req *http.Request) {
+	if c.auditor == nil {
+		return
+	}
+
+	rejected := res.status == http.StatusUnauthorized || (res.status == http.StatusForbidden && res.endpoint != endpointPreflight)
+	channel := res.channel
+	if channel == "" && rejected {
+		channel = requestPublishChannel(req)
+	}
+	if channel != "" {
+		c.auditPublish(res, req, channel, res.status, res.receivers)
+		return
+	}
+
+	var db int
+	var key, field string
+	if res.db != "" {
+		db, _ = strconv.Atoi(res.db)
+		key, field = res.key, res.field
+	} else if rejected {
+		target, ok := requestDataTarget(req)
+		if !ok {
+			return
+		}
+		var err error
+		db, err = strconv.Atoi(target.db)
+		if err != nil || db < 0 {
+			return
+		}
+		key, field = target.key, target.field
+	} else {
+		return
+	}
+
+	record := audit.Record{
+		Time:       time.Now().UTC().Format(time.RFC3339Nano),
+		RequestID:  res.Header().Get(requestIDHeader),
+		Caller:     res.caller,
+		ClientIP:   res.clientIP,
+		RemoteAddr: req.RemoteAddr,
+		DB:         &db,
+		Key:        key,
+		Field:      field,
+		Outcome:    audit.OutcomeFromStatus(res.status),
+		Status:     res.status,
+	}
+	if len(res.batch) == 0 {
+		c.writeAuditRecord(res, record)
+		return
+	}
+	for _, item := range res.batch {
+		record.Key, record.Field = item.Key, item.Field
+		record.Outcome, record.Status = audit.OutcomeFromStatus(item.Status), item.Status
+		c.writeAuditRecord(res, record)
+	}
+}
+
+// auditPublish writes the audit record of a message published (or attempted to be) to the channel, by
+// POST /_publish/<channel> or a publish action over a WebSocket opened by the request. receivers is nil unless
+// the message was published
+func (c *service) auditPublish(res *responseRecorder, req *http.Request, channel string, status int, receivers *int64) {
+	if c.auditor == nil {
+		return
+	}
+	c.writeAuditRecord(res, audit.Record{
+		Time:       time.Now().UTC().Format(time.RFC3339Nano),
+		RequestID:  res.Header().Get(requestIDHeader),
+		Caller:     res.caller,
+		ClientIP:   res.clientIP,
+		RemoteAddr: req.RemoteAddr,
+		Channel:    channel,
+		Receivers:  receivers,
+		Outcome:    audit.OutcomeFromStatus(status),
+		Status:     status,
+	})
+}
+
+func (c *service) writeAuditRecord(res *responseRecorder, record audit.Record) {
+	if err := c.auditor.Log(record); err != nil {
+		res.log.Error("Failed to write audit record. Details: " + err.Error())
+	}
+}
+
+// verifyAuditLog verifies the hash chain of the audit log files given, or of the configured audit file
+// (together with its backups) if no file is given
+func verifyAuditLog(files []string) error {
+	config, err := loadAuditConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		if config.file == "" {
+			return errors.New("no file is given, and REDISEEN_AUDIT_FILE is not configured")
+		}
+		files = audit.ChainFiles(config.file, config.maxBackups)
+		if len(files) == 0 {
+			return fmt.Errorf("audit log file %s is not found", config.file)
+		}
+	}
+
+	result, err := audit.VerifyFiles(files, config.hmacKey)
+	if err != nil {
+		return fmt.Errorf("verification failed after %d valid record(s): %s", result.Records, err.Error())
+	}
+
+	fmt.Printf("OK: %d record(s) verified in %d file(s)\n", result.Records, len(files))
+	if result.Truncated {
+		fmt.Println("Note: the first record points to an earlier record which is not available " +
+			"(expected if older files were removed by rotation)")
+	}
+	return nil
+}
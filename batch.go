@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/types"
+)
+
+const (
+	maxBatchKeys      = 500
+	maxBatchBodyBytes = 1 << 20
+)
+
+var errBatchBodyTooLarge = fmt.Errorf("Request body should be no larger than %d bytes", maxBatchBodyBytes)
+
+// serveBatch handles /<db>/_batch, which reads many keys in one request. Keys are given as repeated
+// query parameter `key` (GET), or as JSON body like {"keys": [{"key": "a"}, {"key": "b", "field": "1"}]} (POST).
+// Every key is checked against REDISEEN_KEY_PATTERN_EXPOSED (and REDISEEN_KEY_PATTERN_DENIED) on its own, and the outcome is given per key
+func (c *service) serveBatch(res *responseRecorder, req *http.Request, params []string) {
+	var js []byte
+
+	db, ok := c.exposedDB(res, params[0])
+	if !ok {
+		return
+	}
+
+	items, err := batchItems(res, req)
+	if err != nil {
+		if err == errBatchBodyTooLarge {
+			res.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else {
+			res.WriteHeader(http.StatusBadRequest)
+		}
+		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return
+	}
+
+	var client conn.ExtendedClient
+	client.Init(db)
+	defer client.RedisClient.Close()
+
+	res.log.Debug("Submit batch query", "db", db, "keys", len(items))
+	results := client.RetrieveBatch(req.Context(), c.exposureOf(db), c.sizeLimits, items)
+	for _, r := range results {
+		if r.Status == http.StatusInternalServerError {
+			redisErrorsTotal.Inc(res.endpoint)
+			break
+		}
+	}
+	res.batch = results
+
+	js, _ = json.Marshal(types.BatchResponseType{Count: len(results), Results: results})
+	res.Write(js)
+}
+
+// batchItems parses the keys (and fields) to read from the request
+func batchItems(res http.ResponseWriter, req *http.Request) ([]types.BatchItemType, error) {
+	var items []types.BatchItemType
+
+	if req.Method == http.MethodPost {
+		var body types.BatchRequestType
+		decoder := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxBatchBodyBytes))
+		if err := decoder.Decode(&body); err != nil {
+			if strings.Contains(err.Error(), "request body too large") {
+				return nil, errBatchBodyTooLarge
+			}
+			return nil, errors.New(`Provide keys as JSON like {"keys": [{"key": "<key>"}, {"key": "<key>", "field": "<index or field>"}]}`)
+		}
+		items = body.Keys
+	} else {
+		for _, key := range req.URL.Query()["key"] {
+			items = append(items, types.BatchItemType{Key: key})
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("Provide at least one key")
+	}
+	if len(items) > maxBatchKeys {
+		return nil, fmt.Errorf("Provide no more than %d keys", maxBatchKeys)
+	}
+	for _, item := range items {
+		if item.Key == "" {
+			return nil, errors.New("Key can not be empty")
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/format"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// defaultDatabases is the number of logical DBs of Redis, if CONFIG GET databases is not allowed
+const defaultDatabases = 16
+
+// keyTypes are the types of keys which can be asked for in pages of keys
+var keyTypes = map[string]bool{"string": true, "list": true, "set": true, "hash": true, "zset": true, "stream": true}
+
+// keyPageRequest is a page of keys asked for, via query parameters cursor, count, match, type and meta
+type keyPageRequest struct {
+	pageRequest
+	scanCursor uint64
+	match      string
+	keyType    string
+	meta       bool
+}
+
+// parseKeyPageRequest parses the query parameters of /<db>. page is nil if none of them is given,
+// in which case keys are listed at once (up to 1000)
+func parseKeyPageRequest(req *http.Request) (page *keyPageRequest, err error) {
+	query := req.URL.Query()
+	rawMatch, rawType, rawMeta := query.Get("match"), query.Get("type"), query.Get("meta")
+	cursorPage, err := parsePageRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if cursorPage == nil && rawMatch == "" && rawType == "" && rawMeta == "" {
+		return nil, nil
+	}
+
+	page = &keyPageRequest{pageRequest: pageRequest{cursor: "0", count: defaultPageCount}, match: rawMatch, keyType: rawType}
+	if cursorPage != nil {
+		page.pageRequest = *cursorPage
+	}
+	if page.scanCursor, err = strconv.ParseUint(page.cursor, 10, 64); err != nil {
+		return nil, errInvalidCursor
+	}
+	if rawType != "" && !keyTypes[rawType] {
+		return nil, errors.New("Provide a type among string, list, set, hash, zset and stream")
+	}
+	if rawMeta != "" {
+		page.meta, err = strconv.ParseBool(rawMeta)
+		if err != nil {
+			return nil, errors.New("Provide true or false for meta")
+		}
+	}
+	return page, nil
+}
+
+// serveKeyPage serves a page of the keys of a DB, scanned with SCAN. Like pages of values, the cursor of the next
+// page is given in header X-Next-Cursor, as well as in field `cursor` of structured formats
+func (c *service) serveKeyPage(res *responseRecorder, req *http.Request, client *conn.ExtendedClient,
+	exposure *conn.Exposure, f string, page *keyPageRequest) {
+	keys, err := client.ScanKeys(req.Context(), exposure, page.scanCursor, page.count, page.match, page.keyType, page.meta)
+	if err != nil {
+		redisErrorsTotal.Inc(res.endpoint)
+		res.WriteHeader(http.StatusInternalServerError)
+		js, _ := json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return
+	}
+
+	res.Header().Set(nextCursorHeader, keys.Cursor)
+	if isRowFormat(f) {
+		rows := newRowWriter(res, f, "key", "type")
+		for _, k := range keys.Keys {
+			rows.write(k)
+		}
+		rows.close()
+		return
+	}
+	if f != format.JSON {
+		writeEncoded(res, f, keys)
+		return
+	}
+	js, _ := json.Marshal(keys)
+	res.Write(js)
+}
+
+// serveDBs handles requests to /dbs, listing the exposed DBs. Their numbers of exposed keys are only given with
+// query parameter keys=true, since they are counted by scanning the whole DBs (DBSIZE is not used, since it would
+// tell how many keys are hidden)
+func (c *service) serveDBs(res *responseRecorder, req *http.Request, params []string) {
+	var countKeys bool
+	if raw := req.URL.Query().Get("keys"); raw != "" {
+		var err error
+		if countKeys, err = strconv.ParseBool(raw); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			js, _ := json.Marshal(types.ErrorType{Error: "Provide true or false for keys"})
+			res.Write(js)
+			return
+		}
+	}
+
+	dbs := types.DBListType{DBs: []types.DBInfoType{}}
+	for _, db := range c.exposedDBs(req.Context()) {
+		var client conn.ExtendedClient
+		client.Init(db)
+		info := types.DBInfoType{DB: db}
+		err := client.RedisClient.Ping(req.Context()).Err()
+		if err == nil && countKeys {
+			var size int64
+			size, err = client.CountKeys(req.Context(), c.exposureOf(db))
+			info.Keys = &size
+		}
+		client.RedisClient.Close()
+		if err != nil {
+			if c.dbExposed == "*" && db > 0 {
+				// beyond the DBs of Redis
+				break
+			}
+			redisErrorsTotal.Inc(res.endpoint)
+			res.WriteHeader(http.StatusInternalServerError)
+			js, _ := json.Marshal(types.ErrorType{Error: err.Error()})
+			res.Write(js)
+			return
+		}
+		dbs.DBs = append(dbs.DBs, info)
+	}
+
+	js, _ := json.Marshal(dbs)
+	res.Write(js)
+}
+
+// exposedDBs gives the exposed DBs in order. If all DBs are exposed, they are the DBs of Redis (CONFIG GET databases)
+func (c *service) exposedDBs(ctx context.Context) []int {
+	var dbs []int
+	if c.dbExposed != "*" {
+		for db := range c.dbExposedMap {
+			dbs = append(dbs, db)
+		}
+		sort.Ints(dbs)
+		return dbs
+	}
+
+	databases := defaultDatabases
+	var client conn.ExtendedClient
+	client.Init(0)
+	defer client.RedisClient.Close()
+	if config, err := client.RedisClient.ConfigGet(ctx, "databases").Result(); err == nil && len(config) == 2 {
+		raw, _ := config[1].(string)
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			databases = n
+		}
+	}
+	for db := 0; db < databases; db++ {
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
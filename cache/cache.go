@@ -0,0 +1,196 @@
+// Package cache keeps responses to reads of Redis keys in memory, in an LRU bounded by size.
+// Entries are evicted when the keys are changed in Redis (as told by the caller), so that the cache stays coherent.
+// Values read while their keys are changed are never stored: a read is started with Begin, and what it gives is
+// stored with Fill.Store only if nothing was invalidated in the meantime.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// entryOverhead approximates the memory used by an entry besides its key and body
+const entryOverhead = 128
+
+// Key identifies an entry: a key in a logical DB, and the variant of its response (e.g. field and format)
+type Key struct {
+	DB      int
+	Key     string
+	Variant string
+}
+
+// Entry is a cached response
+type Entry struct {
+	Body        []byte
+	ContentType string
+	// Expires is when the key expires in Redis (zero if it has no TTL). Expired entries are never given
+	Expires time.Time
+}
+
+type element struct {
+	key   Key
+	entry Entry
+	size  int64
+}
+
+// LRU is safe for concurrent use
+type LRU struct {
+	maxBytes int64
+
+	mu       sync.Mutex
+	bytes    int64
+	order    *list.List                // most recently used first
+	entries  map[Key]*list.Element     // of *element
+	names    map[string]map[Key]bool   // entries by key name, since invalidations may not tell the DB
+	inflight map[string]map[*Fill]bool // reads begun but not finished, by key name
+}
+
+// New returns an empty LRU holding up to maxBytes
+func New(maxBytes int64) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[Key]*list.Element{},
+		names:    map[string]map[Key]bool{},
+		inflight: map[string]map[*Fill]bool{},
+	}
+}
+
+// Get gives the entry, if it is cached and has not expired at now
+func (c *LRU) Get(k Key, now time.Time) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[k]
+	if !ok {
+		return Entry{}, false
+	}
+	el := e.Value.(*element)
+	if !el.entry.Expires.IsZero() && !now.Before(el.entry.Expires) {
+		c.remove(e)
+		return Entry{}, false
+	}
+	c.order.MoveToFront(e)
+	return el.entry, true
+}
+
+// Len gives the number of entries, and the memory they use (approximately)
+func (c *LRU) Len() (entries int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len(), c.bytes
+}
+
+// Invalidate evicts entries of the key in the DB given, or in any DB if db is negative.
+// Reads of the key in progress are not stored. It returns the number of entries evicted
+func (c *LRU) Invalidate(db int, key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for f := range c.inflight[key] {
+		if db < 0 || f.db == db {
+			f.stale = true
+		}
+	}
+	evicted := 0
+	for k := range c.names[key] {
+		if db < 0 || k.DB == db {
+			c.remove(c.entries[k])
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// Flush evicts all entries. Reads in progress are not stored. It returns the number of entries evicted
+func (c *LRU) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, fills := range c.inflight {
+		for f := range fills {
+			f.stale = true
+		}
+	}
+	evicted := c.order.Len()
+	c.bytes = 0
+	c.order.Init()
+	c.entries = map[Key]*list.Element{}
+	c.names = map[string]map[Key]bool{}
+	return evicted
+}
+
+// Begin tells that the key is about to be read from Redis. Done must be called on the Fill returned once finished
+func (c *LRU) Begin(db int, key string) *Fill {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := &Fill{lru: c, db: db, key: key}
+	if c.inflight[key] == nil {
+		c.inflight[key] = map[*Fill]bool{}
+	}
+	c.inflight[key][f] = true
+	return f
+}
+
+func (c *LRU) remove(e *list.Element) {
+	el := e.Value.(*element)
+	c.order.Remove(e)
+	delete(c.entries, el.key)
+	delete(c.names[el.key.Key], el.key)
+	if len(c.names[el.key.Key]) == 0 {
+		delete(c.names, el.key.Key)
+	}
+	c.bytes -= el.size
+}
+
+// Fill is a read of a key from Redis, whose responses may be stored
+type Fill struct {
+	lru   *LRU
+	db    int
+	key   string
+	stale bool // the key was invalidated since the read begun (guarded by lru.mu)
+}
+
+// Store caches a response read. It is not stored if the key was invalidated since Begin, or if it is larger than
+// the LRU. Least recently used entries are evicted to make room, and their number is returned
+func (f *Fill) Store(variant string, entry Entry) (stored bool, evicted int) {
+	c := f.lru
+	k := Key{DB: f.db, Key: f.key, Variant: variant}
+	size := int64(len(entry.Body)+len(entry.ContentType)+len(k.Key)+len(k.Variant)) + entryOverhead
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.stale || size > c.maxBytes {
+		return false, 0
+	}
+	if e, ok := c.entries[k]; ok {
+		c.remove(e)
+	}
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.order.Back())
+		evicted++
+	}
+
+	c.entries[k] = c.order.PushFront(&element{key: k, entry: entry, size: size})
+	if c.names[k.Key] == nil {
+		c.names[k.Key] = map[Key]bool{}
+	}
+	c.names[k.Key][k] = true
+	c.bytes += size
+	return true, evicted
+}
+
+// Done tells that the read is finished
+func (f *Fill) Done() {
+	c := f.lru
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inflight[f.key], f)
+	if len(c.inflight[f.key]) == 0 {
+		delete(c.inflight, f.key)
+	}
+}
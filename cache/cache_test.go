@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func entryOf(size int) Entry {
+	return Entry{Body: []byte(strings.Repeat("x", size)), ContentType: "application/json"}
+}
+
+func store(c *LRU, db int, key string, variant string, entry Entry) (bool, int) {
+	f := c.Begin(db, key)
+	defer f.Done()
+	return f.Store(variant, entry)
+}
+
+func Test_LRU_eviction(t *testing.T) {
+	now := time.Now()
+	size := int64(1000 + len("application/json") + len("key:1") + len("v") + entryOverhead)
+	c := New(3 * size)
+
+	for _, key := range []string{"key:1", "key:2", "key:3"} {
+		if stored, evicted := store(c, 0, key, "v", entryOf(1000)); !stored || evicted != 0 {
+			t.Error("Expecting", key, "stored without eviction, got", stored, evicted)
+		}
+	}
+	if entries, bytes := c.Len(); entries != 3 || bytes != 3*size {
+		t.Error("Expecting 3 entries of", 3*size, "bytes, got", entries, bytes)
+	}
+
+	// key:1 is used, so key:2 is the least recently used
+	if _, ok := c.Get(Key{DB: 0, Key: "key:1", Variant: "v"}, now); !ok {
+		t.Error("Expecting key:1 cached")
+	}
+	if stored, evicted := store(c, 0, "key:4", "v", entryOf(1000)); !stored || evicted != 1 {
+		t.Error("Expecting key:4 stored with 1 eviction, got", stored, evicted)
+	}
+	if _, ok := c.Get(Key{DB: 0, Key: "key:2", Variant: "v"}, now); ok {
+		t.Error("Expecting key:2 evicted")
+	}
+	for _, key := range []string{"key:1", "key:3", "key:4"} {
+		if _, ok := c.Get(Key{DB: 0, Key: key, Variant: "v"}, now); !ok {
+			t.Error("Expecting", key, "cached")
+		}
+	}
+
+	// replacing an entry does not evict others
+	if stored, evicted := store(c, 0, "key:1", "v", entryOf(1000)); !stored || evicted != 0 {
+		t.Error("Expecting key:1 replaced without eviction, got", stored, evicted)
+	}
+
+	if stored, _ := store(c, 0, "key:5", "v", entryOf(4000)); stored {
+		t.Error("Expecting entry larger than the LRU not stored")
+	}
+}
+
+func Test_LRU_expiry(t *testing.T) {
+	now := time.Now()
+	c := New(1 << 20)
+	entry := entryOf(10)
+	entry.Expires = now.Add(time.Second)
+	store(c, 0, "key:1", "v", entry)
+
+	if got, ok := c.Get(Key{DB: 0, Key: "key:1", Variant: "v"}, now); !ok || !got.Expires.Equal(entry.Expires) {
+		t.Error("Expecting entry cached until it expires")
+	}
+	if _, ok := c.Get(Key{DB: 0, Key: "key:1", Variant: "v"}, now.Add(time.Second)); ok {
+		t.Error("Expecting expired entry not given")
+	}
+	if entries, _ := c.Len(); entries != 0 {
+		t.Error("Expecting expired entry removed, got", entries, "entries")
+	}
+}
+
+func Test_LRU_invalidation(t *testing.T) {
+	now := time.Now()
+	c := New(1 << 20)
+	store(c, 0, "key:1", "json", entryOf(10))
+	store(c, 0, "key:1", "csv", entryOf(10))
+	store(c, 1, "key:1", "json", entryOf(10))
+	store(c, 0, "key:2", "json", entryOf(10))
+
+	if evicted := c.Invalidate(0, "key:1"); evicted != 2 {
+		t.Error("Expecting 2 entries evicted, got", evicted)
+	}
+	if _, ok := c.Get(Key{DB: 1, Key: "key:1", Variant: "json"}, now); !ok {
+		t.Error("Expecting key:1 of DB 1 cached")
+	}
+	if evicted := c.Invalidate(-1, "key:1"); evicted != 1 {
+		t.Error("Expecting 1 entry evicted, got", evicted)
+	}
+	if evicted := c.Invalidate(-1, "key:3"); evicted != 0 {
+		t.Error("Expecting no entry evicted, got", evicted)
+	}
+	if entries, _ := c.Len(); entries != 1 {
+		t.Error("Expecting 1 entry left, got", entries)
+	}
+	if evicted := c.Flush(); evicted != 1 {
+		t.Error("Expecting 1 entry flushed, got", evicted)
+	}
+	if entries, bytes := c.Len(); entries != 0 || bytes != 0 {
+		t.Error("Expecting empty LRU, got", entries, bytes)
+	}
+}
+
+func Test_LRU_stale_fill(t *testing.T) {
+	c := New(1 << 20)
+
+	// invalidated while being read
+	f := c.Begin(0, "key:1")
+	c.Invalidate(-1, "key:1")
+	if stored, _ := f.Store("v", entryOf(10)); stored {
+		t.Error("Expecting value read during invalidation not stored")
+	}
+	f.Done()
+
+	// invalidation of another DB does not matter
+	f = c.Begin(0, "key:1")
+	c.Invalidate(1, "key:1")
+	if stored, _ := f.Store("v", entryOf(10)); !stored {
+		t.Error("Expecting value stored")
+	}
+	f.Done()
+
+	f = c.Begin(0, "key:2")
+	c.Flush()
+	if stored, _ := f.Store("v", entryOf(10)); stored {
+		t.Error("Expecting value read during flush not stored")
+	}
+	f.Done()
+
+	if len(c.inflight) != 0 {
+		t.Error("Expecting no read in progress, got", len(c.inflight))
+	}
+}
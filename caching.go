@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xd-deng/rediseen/format"
+)
+
+// Responses up to maxETagBufferBytes are held to compute their ETag. Larger responses are streamed without ETag,
+// since the value may be modified while it is read, after the headers are sent
+const maxETagBufferBytes = 1 << 20
+
+// errNotModified stops writing the response, once it is known that the client has it already
+var errNotModified = errors.New("not modified")
+
+// parseCacheMaxAge parses REDISEEN_CACHE_MAX_AGE, the max-age (in seconds) of keys without TTL
+func parseCacheMaxAge(config string) (int, error) {
+	if config == "" {
+		return 0, nil
+	}
+	maxAge, err := strconv.Atoi(config)
+	if err != nil || maxAge < 0 {
+		return 0, errors.New("it should be a non-negative integer (seconds)")
+	}
+	return maxAge, nil
+}
+
+// cacheControl derives Cache-Control from the remaining TTL of the key (negative if the key has no TTL),
+// so that cached copies never outlive the key
+func (c *service) cacheControl(ttl time.Duration) string {
+	// responses to authenticated (or IP-restricted) requests must not be stored by shared caches (like CDNs),
+	// which would serve them to anyone
+	directive := "public"
+	if c.authEnforced || c.ipAccess != nil {
+		directive = "private"
+	}
+	if ttl >= 0 {
+		return fmt.Sprintf("%s, max-age=%d", directive, int64(ttl/time.Second))
+	}
+	if c.cacheMaxAge == 0 {
+		return directive + ", no-cache"
+	}
+	return fmt.Sprintf("%s, max-age=%d", directive, c.cacheMaxAge)
+}
+
+// etagWriter holds a successful response to compute its ETag (sent together with Cache-Control), and responds
+// 304 instead if the client has it already (as told by If-None-Match). Other responses are written as is
+type etagWriter struct {
+	http.ResponseWriter
+	ifNoneMatch  string
+	cacheControl string
+
+	buffer      bytes.Buffer
+	hash        hash.Hash
+	passThrough bool // the status is sent (and the response is written as is from now on)
+	notModified bool
+	held        []byte // the whole response, once finished, if it was held
+}
+
+func newETagWriter(res http.ResponseWriter, req *http.Request, cacheControl string) *etagWriter {
+	return &etagWriter{ResponseWriter: res, ifNoneMatch: req.Header.Get("If-None-Match"), cacheControl: cacheControl,
+		hash: sha256.New()}
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if code == http.StatusOK || w.passThrough || w.notModified {
+		return
+	}
+	w.passThrough = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.notModified {
+		return 0, errNotModified
+	}
+	if w.passThrough {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buffer.Write(b)
+	w.hash.Write(b)
+	if w.buffer.Len() <= maxETagBufferBytes {
+		return len(b), nil
+	}
+
+	// too large to be held
+	w.commit()
+	return len(b), nil
+}
+
+// Flush is ignored while the response is held
+func (w *etagWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.passThrough {
+		f.Flush()
+	}
+}
+
+// finish writes the response held (if any), with the ETag computed from it
+func (w *etagWriter) finish() {
+	if w.passThrough || w.notModified {
+		return
+	}
+	w.held = w.buffer.Bytes()
+	if w.validate(`"`+hex.EncodeToString(w.hash.Sum(nil)[:16])+`"`, w.buffer.Len()) {
+		return
+	}
+	w.commit()
+}
+
+// validate sets the ETag and Cache-Control, and responds 304 if the client has the response already
+func (w *etagWriter) validate(etag string, size int) bool {
+	// a compressed response is another representation, so its ETag has the content coding appended,
+	// as long as it is large enough to be compressed (see compressWriter)
+	if cw, ok := w.ResponseWriter.(*compressWriter); ok && size >= minCompressBytes {
+		w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoding+`"`)
+	} else {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Cache-Control", w.cacheControl)
+	if !etagMatches(w.ifNoneMatch, etag) {
+		return false
+	}
+	w.notModified = true
+	w.Header().Del("Content-Type")
+	w.ResponseWriter.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// commit sends the status, and the bytes held so far
+func (w *etagWriter) commit() {
+	w.Header().Set("Cache-Control", w.cacheControl)
+	w.passThrough = true
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+	w.ResponseWriter.Write(w.buffer.Bytes())
+	w.buffer = bytes.Buffer{}
+}
+
+// writeWithETag writes the response (with write) through an etagWriter. It returns the response if it was
+// successful, held whole, and write tells that it is complete
+func writeWithETag(res *responseRecorder, req *http.Request, cacheControl string, write func() bool) []byte {
+	writer := newETagWriter(res.ResponseWriter, req, cacheControl)
+	res.ResponseWriter = writer
+	complete := write()
+	writer.finish()
+	res.ResponseWriter = writer.ResponseWriter
+	if writer.notModified {
+		res.status, res.bytes = http.StatusNotModified, 0
+	}
+	if !complete {
+		return nil
+	}
+	return writer.held
+}
+
+// etagMatches tells if If-None-Match matches the ETag (with weak comparison, as required for If-None-Match).
+// The content coding appended to ETags of compressed responses is ignored
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" {
+			return true
+		}
+		for _, coding := range []string{format.Gzip, format.Zstd} {
+			if strings.HasSuffix(candidate, "-"+coding+`"`) {
+				candidate = strings.TrimSuffix(candidate, "-"+coding+`"`) + `"`
+			}
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
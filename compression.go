@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xd-deng/rediseen/format"
+)
+
+// responses smaller than minCompressBytes are not compressed, since it would hardly save anything
+const minCompressBytes = 1024
+
+var defaultCompression = []string{format.Zstd, format.Gzip}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}}
+	zstdWriters = sync.Pool{New: func() interface{} {
+		// a single goroutine per encoder, so that memory stays bounded for streamed responses
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// parseCompression parses REDISEEN_COMPRESSION, the semicolon-separated content codings to offer
+// (in order of preference). Compression is disabled by `none`
+func parseCompression(config string) ([]string, error) {
+	if config == "" {
+		return defaultCompression, nil
+	}
+	if config == "none" {
+		return nil, nil
+	}
+	var codings []string
+	for _, coding := range strings.Split(config, ";") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != format.Gzip && coding != format.Zstd {
+			return nil, fmt.Errorf("content coding `%s` is not supported (supported: gzip, zstd, or none to disable)", coding)
+		}
+		codings = append(codings, coding)
+	}
+	return codings, nil
+}
+
+// compressWriter compresses the response with the content coding given. Since it is only known whether the response
+// is large enough to be compressed once minCompressBytes are written (or it is flushed), the status and the first
+// bytes are held until then
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buffer   []byte
+	decided  bool
+	encoder  interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+// newCompressWriter returns res as is if the client does not accept any content coding offered
+func newCompressWriter(res http.ResponseWriter, req *http.Request, offered []string) http.ResponseWriter {
+	if len(offered) == 0 {
+		return res
+	}
+	res.Header().Add("Vary", "Accept-Encoding")
+	encoding := format.NegotiateEncoding(req.Header.Get("Accept-Encoding"), offered)
+	if encoding == "" {
+		return res
+	}
+	return &compressWriter{ResponseWriter: res, encoding: encoding, status: http.StatusOK}
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buffer = append(w.buffer, b...)
+		if len(w.buffer) >= minCompressBytes {
+			return len(b), w.decide(true)
+		}
+		return len(b), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide sends the status, and the bytes held so far (compressed or not)
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	if compress && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		w.Header().Set("Content-Encoding", w.encoding)
+		w.Header().Del("Content-Length")
+		if w.encoding == format.Gzip {
+			encoder := gzipWriters.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		} else {
+			encoder := zstdWriters.Get().(*zstd.Encoder)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buffer := w.buffer
+	w.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+	_, err := w.Write(buffer)
+	return err
+}
+
+// Flush sends what is written so far to the client. Responses which are flushed are streamed, so they are
+// compressed even if they are small so far
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSockets take over the connection, which is never compressed
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	w.decided = true
+	return hijacker.Hijack()
+}
+
+// Close finishes the response. The encoder is returned to its pool
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.encoder == nil {
+		return nil
+	}
+	err := w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *zstd.Encoder:
+		encoder.Reset(nil)
+		zstdWriters.Put(encoder)
+	}
+	w.encoder = nil
+	return err
+}
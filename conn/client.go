@@ -7,16 +7,20 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/xd-deng/rediseen/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const strNotImplemented = "not implemented"
 const strWrongTypeForIndexField = "wrong type for index/field"
 const listKeyLimit = 1000
+const scanChunkSize = 1000
+const maxScanCalls = 10
 
 // ExtendedClient is a struct type which helps extend Redis Client
 type ExtendedClient struct {
@@ -35,6 +39,20 @@ func (client *ExtendedClient) Init(db int) {
 		Password: parsedUri.Password,
 		DB:       db,
 	})
+	client.RedisClient.AddHook(tracingHook{db: db})
+}
+
+// InitFromURI initiates and prepares a Redis client from the Redis URI given, instead of REDISEEN_REDIS_URI.
+// All information in the URI (credentials, DB and TLS) is respected
+func (client *ExtendedClient) InitFromURI(uri string) error {
+	options, err := redis.ParseURL(uri)
+	if err != nil {
+		return err
+	}
+
+	client.RedisClient = redis.NewClient(options)
+	client.RedisClient.AddHook(tracingHook{db: options.DB})
+	return nil
 }
 
 // ClientPing checks the user-specified `REDISEEN_REDIS_URI` (using default db 0)
@@ -52,13 +70,22 @@ func ClientPing() error {
 	return nil
 }
 
-// ListKeys lists keys whose names match with REDISEEN_KEY_PATTERN_EXPOSED,
-// together with their types,
+// ListKeys lists keys whose names match with REDISEEN_KEY_PATTERN_EXPOSED (and not REDISEEN_KEY_PATTERN_DENIED,
+// nor dropped by redaction rules), together with their types,
 // given a Redis client (in which logical DB is already specified).
 // Only up to 1000 keys will be returned.
 // In the response, we also give `count` and `total`.
 // `count`<=1000, while `total` is the actual total number of keys whose names match with REDISEEN_KEY_PATTERN_EXPOSED
-func (client *ExtendedClient) ListKeys(regexpKeyPatternExposed *regexp.Regexp) []byte {
+func (client *ExtendedClient) ListKeys(ctx context.Context, exposure *Exposure) []byte {
+	js, _ := json.Marshal(client.ListKeyInfo(ctx, exposure))
+	return js
+}
+
+// ListKeyInfo is like ListKeys, but returns the keys instead of their JSON
+func (client *ExtendedClient) ListKeyInfo(ctx context.Context, exposure *Exposure) types.KeyListType {
+	ctx, span := client.startSpan(ctx, "ListKeys")
+	defer span.End()
+
 	keys, _ := client.RedisClient.Keys(ctx, "*").Result()
 
 	pipe := client.RedisClient.Pipeline()
@@ -68,7 +95,7 @@ func (client *ExtendedClient) ListKeys(regexpKeyPatternExposed *regexp.Regexp) [
 		if i == listKeyLimit {
 			break
 		}
-		if regexpKeyPatternExposed.MatchString(k) {
+		if exposure.Exposes(k) && !exposure.Drops(k) {
 			pipe.Type(ctx, k)
 			results = append(results, types.KeyInfoType{Key: k})
 		}
@@ -89,92 +116,501 @@ func (client *ExtendedClient) ListKeys(regexpKeyPatternExposed *regexp.Regexp) [
 		count = len(results)
 	}
 
-	js, _ := json.Marshal(types.KeyListType{Keys: results, Total: len(keys), Count: count})
-	return js
+	span.SetAttributes(attribute.String("db.operation.name", "KEYS"), attribute.Int("rediseen.result.entries", count))
+	return types.KeyListType{Keys: results, Total: len(keys), Count: count}
 }
 
-// Retrieve handles requests to different Redis Data Types, and return values correspondingly
-func (client *ExtendedClient) Retrieve(key string, indexOrField string) ([]byte, int) {
+// ScanKeys lists a page of keys like ListKeyInfo, but with SCAN from the cursor given (0 for the first page) instead
+// of KEYS. Only keys matching the glob pattern given (any if empty) and of the type given (any if empty) are listed.
+// SCAN is called until count keys are listed, the scan is done, or maxScanCalls is reached, so pages may hold a few
+// more keys than count, or fewer (even none) before the last page. If withMeta is true, the TTLs and the memory
+// usage of keys are given as well
+func (client *ExtendedClient) ScanKeys(ctx context.Context, exposure *Exposure, cursor uint64, count int64, match string,
+	keyType string, withMeta bool) (page types.KeyPageType, err error) {
+	ctx, span := client.startSpan(ctx, "ScanKeys")
+	defer func() {
+		endSpan(span, "SCAN", len(page.Keys), err)
+	}()
+
+	page.Keys = []types.KeyInfoType{}
+	for calls := 0; calls < maxScanCalls; calls++ {
+		var keys []string
+		keys, cursor, err = client.RedisClient.Scan(ctx, cursor, match, count).Result()
+		if err != nil {
+			return page, err
+		}
 
-	var js []byte
-	var index int64
-	var field string
-	var value interface{}
+		pipe := client.RedisClient.Pipeline()
+		var candidates []string
+		var typeCmds []*redis.StatusCmd
+		var ttlCmds []*redis.DurationCmd
+		var memoryCmds []*redis.IntCmd
+		for _, k := range keys {
+			if !exposure.Exposes(k) || exposure.Drops(k) {
+				continue
+			}
+			candidates = append(candidates, k)
+			typeCmds = append(typeCmds, pipe.Type(ctx, k))
+			if withMeta {
+				ttlCmds = append(ttlCmds, pipe.TTL(ctx, k))
+				memoryCmds = append(memoryCmds, pipe.MemoryUsage(ctx, k))
+			}
+		}
+		if len(candidates) > 0 {
+			// errors are checked per command, since MEMORY USAGE may not be allowed
+			pipe.Exec(ctx)
+		}
 
-	keyType, err := client.RedisClient.Type(ctx, key).Result()
+		for i, k := range candidates {
+			t, err := typeCmds[i].Result()
+			if err != nil {
+				return page, err
+			}
+			if t == "none" || (keyType != "" && t != keyType) {
+				continue
+			}
+			info := types.KeyInfoType{Key: k, Type: t}
+			if withMeta {
+				ttl := int64(-1)
+				if d := ttlCmds[i].Val(); d > 0 {
+					ttl = int64(d / time.Second)
+				}
+				info.TTL = &ttl
+				if memoryCmds[i].Err() == nil {
+					bytes := memoryCmds[i].Val()
+					info.Bytes = &bytes
+				}
+			}
+			page.Keys = append(page.Keys, info)
+		}
 
-	if indexOrField == "" {
-		switch keyType {
-		case "string":
-			value, err = client.RedisClient.Get(ctx, key).Result()
-		case "list":
-			value, err = client.RedisClient.LRange(ctx, key, 0, -1).Result()
-		case "set":
-			value, err = client.RedisClient.SMembers(ctx, key).Result()
-		case "hash":
-			value, err = client.RedisClient.HGetAll(ctx, key).Result()
-		case "zset":
-			//TODO: a simple implementation given methods on sorted set can be very complicated
-			value, err = client.RedisClient.ZRange(ctx, key, 0, -1).Result()
-		default:
-			err = errors.New(strNotImplemented)
+		if cursor == 0 || int64(len(page.Keys)) >= count {
+			break
 		}
-	} else {
-		if keyType == "string" || keyType == "list" {
-			index, _ = strconv.ParseInt(indexOrField, 10, 64)
-		} else {
-			field = indexOrField
+	}
+
+	page.Count = len(page.Keys)
+	page.Cursor = strconv.FormatUint(cursor, 10)
+	return page, nil
+}
+
+// CountKeys counts the keys exposed (and not dropped by redaction rules), scanning the whole DB. Unlike DBSIZE,
+// it does not tell how many keys are hidden
+func (client *ExtendedClient) CountKeys(ctx context.Context, exposure *Exposure) (count int64, err error) {
+	ctx, span := client.startSpan(ctx, "CountKeys")
+	defer func() {
+		endSpan(span, "SCAN", int(count), err)
+	}()
+
+	iter := client.RedisClient.Scan(ctx, 0, "", scanChunkSize).Iterator()
+	for iter.Next(ctx) {
+		if k := iter.Val(); exposure.Exposes(k) && !exposure.Drops(k) {
+			count++
 		}
+	}
+	return count, iter.Err()
+}
 
-		switch keyType {
-		case "string":
-			if index == 0 && indexOrField != "0" {
-				err = errors.New(strWrongTypeForIndexField)
+// Retrieve handles requests to different Redis Data Types, and return values correspondingly (redacted by redactor,
+// which may be nil)
+func (client *ExtendedClient) Retrieve(ctx context.Context, key string, indexOrField string, redactor *Redactor) ([]byte, int) {
+	ctx, span := client.startSpan(ctx, "Retrieve")
+	defer span.End()
+
+	value, errorCode, err := client.retrieve(ctx, span, key, indexOrField, redactor)
+	if err != nil {
+		js, _ := json.Marshal(types.ErrorType{Error: err.Error()})
+		return js, errorCode
+	}
+	js, _ := json.Marshal(value)
+	span.SetAttributes(attribute.Int("rediseen.result.bytes", len(js)))
+	return js, 0
+}
+
+// RetrieveValue is like Retrieve, but returns the value instead of its JSON. If err is not nil,
+// errorCode is the HTTP status for it
+func (client *ExtendedClient) RetrieveValue(ctx context.Context, key string, indexOrField string, redactor *Redactor) (value types.ResponseType, errorCode int, err error) {
+	ctx, span := client.startSpan(ctx, "Retrieve")
+	defer span.End()
+
+	return client.retrieve(ctx, span, key, indexOrField, redactor)
+}
+
+func (client *ExtendedClient) retrieve(ctx context.Context, span trace.Span, key string, indexOrField string, redactor *Redactor) (value types.ResponseType, errorCode int, err error) {
+	keyType, _ := client.RedisClient.Type(ctx, key).Result()
+	result, command := readValue(ctx, client.RedisClient, keyType, key, indexOrField)
+	value.Value, err = result()
+	value.Value, err = redactor.value(keyType, indexOrField, value.Value, err)
+	value.ValueType = keyType
+
+	span.SetAttributes(attribute.String("rediseen.key_type", keyType), attribute.String("db.operation.name", command))
+	return value, errorStatus(err), err
+}
+
+// ScanValue reads the elements of a list, set, hash, sorted set or stream chunk by chunk (with LRANGE, SSCAN, HSCAN,
+// ZRANGE and XRANGE), and calls emit with each of them: string for list and set, types.HashEntryType for hash,
+// types.SortedSetEntryType for sorted set and types.StreamEntryType for stream. So large values are never held in memory as a whole.
+// Values no larger than a chunk are read with a single command, so that they are consistent. Members of larger sets and
+// fields of larger hashes are remembered while they are streamed, so that each of them is given only once
+func (client *ExtendedClient) ScanValue(ctx context.Context, key string, keyType string, emit func(element interface{}) error) (err error) {
+	ctx, span := client.startSpan(ctx, "ScanValue")
+	var command string
+	var entries int
+	defer func() {
+		endSpan(span, command, entries, err)
+	}()
+
+	switch keyType {
+	case "list":
+		command = "LRANGE"
+		// the first chunk is read with a single command, which gives values no larger than a chunk as a whole
+		for start := int64(0); ; start += scanChunkSize {
+			chunk, err := client.RedisClient.LRange(ctx, key, start, start+scanChunkSize-1).Result()
+			if err != nil {
+				return err
+			}
+			for _, element := range chunk {
+				if err = emit(element); err != nil {
+					return err
+				}
+				entries++
+			}
+			if len(chunk) < scanChunkSize {
+				return nil
+			}
+		}
+	case "set", "hash":
+		size, err := sizeCommand(ctx, client.RedisClient, keyType, key).Result()
+		if err != nil {
+			return err
+		}
+		if size <= scanChunkSize {
+			var elements []string
+			if keyType == "set" {
+				command = "SMEMBERS"
+				elements, err = client.RedisClient.SMembers(ctx, key).Result()
 			} else {
-				value, err = client.RedisClient.GetRange(ctx, key, index, index).Result()
+				command = "HGETALL"
+				// as a slice rather than a map, so that fields are given in the order of Redis
+				cmd := redis.NewStringSliceCmd(ctx, "hgetall", key)
+				client.RedisClient.Process(ctx, cmd)
+				elements, err = cmd.Result()
 			}
-		case "list":
-			if index == 0 && indexOrField != "0" {
-				err = errors.New(strWrongTypeForIndexField)
+			if err != nil {
+				return err
+			}
+			return emitSetOrHash(keyType, elements, nil, emit, &entries)
+		}
+
+		// like SCAN, SSCAN and HSCAN may give elements more than once if the key is modified meanwhile
+		seen := make(map[string]struct{}, size)
+		var cursor uint64
+		for {
+			var chunk []string
+			if keyType == "set" {
+				command = "SSCAN"
+				chunk, cursor, err = client.RedisClient.SScan(ctx, key, cursor, "", scanChunkSize).Result()
 			} else {
-				value, err = client.RedisClient.LIndex(ctx, key, index).Result()
+				command = "HSCAN"
+				chunk, cursor, err = client.RedisClient.HScan(ctx, key, cursor, "", scanChunkSize).Result()
+			}
+			if err != nil {
+				return err
+			}
+			if err = emitSetOrHash(keyType, chunk, seen, emit, &entries); err != nil {
+				return err
+			}
+			if cursor == 0 {
+				return nil
+			}
+		}
+	case "zset":
+		command = "ZRANGE"
+		for start := int64(0); ; start += scanChunkSize {
+			chunk, err := client.RedisClient.ZRangeWithScores(ctx, key, start, start+scanChunkSize-1).Result()
+			if err != nil {
+				return err
+			}
+			for _, z := range chunk {
+				member, _ := z.Member.(string)
+				if err = emit(types.SortedSetEntryType{Member: member, Score: z.Score}); err != nil {
+					return err
+				}
+				entries++
+			}
+			if len(chunk) < scanChunkSize {
+				return nil
+			}
+		}
+	case "stream":
+		command = "XRANGE"
+		return client.scanStream(ctx, key, func(entry types.StreamEntryType) error {
+			entries++
+			return emit(entry)
+		})
+	default:
+		return errors.New(strNotImplemented)
+	}
+}
+
+// emitSetOrHash calls emit with the members of a set, or with the fields of a hash (given as field, value, field, value...),
+// skipping those in seen (if not nil) and adding the others to it
+func emitSetOrHash(keyType string, elements []string, seen map[string]struct{}, emit func(element interface{}) error, entries *int) error {
+	step := 1
+	if keyType == "hash" {
+		step = 2
+	}
+	for i := 0; i+step <= len(elements); i += step {
+		if seen != nil {
+			if _, ok := seen[elements[i]]; ok {
+				continue
+			}
+			seen[elements[i]] = struct{}{}
+		}
+		var element interface{} = elements[i]
+		if keyType == "hash" {
+			element = types.HashEntryType{Field: elements[i], Value: elements[i+1]}
+		}
+		if err := emit(element); err != nil {
+			return err
+		}
+		*entries++
+	}
+	return nil
+}
+
+// RetrieveBatch reads the keys (and their index/field if given) in two round trips: TYPE of all keys,
+// then the reads of all keys, both pipelined. Keys which are not exposed are not read, and values are redacted.
+// If limits are enabled, the sizes of keys read whole are checked in between (one more round trip), and keys
+// exceeding them are not read. MaxBytes applies to the sum of all values as well: once it is reached, the keys left
+// are given status 413, so that they can be read on their own
+func (client *ExtendedClient) RetrieveBatch(ctx context.Context, exposure *Exposure, limits SizeLimits, items []types.BatchItemType) []types.BatchResultType {
+	ctx, span := client.startSpan(ctx, "RetrieveBatch")
+	defer span.End()
+
+	results := make([]types.BatchResultType, len(items))
+	typeCmds := make([]*redis.StatusCmd, len(items))
+
+	pipe := client.RedisClient.Pipeline()
+	for i, item := range items {
+		results[i].Key, results[i].Field = item.Key, item.Field
+		if !exposure.Exposes(item.Key) {
+			results[i].Status = http.StatusForbidden
+			results[i].Error = "Key pattern is forbidden from access"
+			continue
+		}
+		if exposure.Drops(item.Key) {
+			results[i].Status = http.StatusNotFound
+			results[i].Error = "Key provided does not exist."
+			continue
+		}
+		typeCmds[i] = pipe.Type(ctx, item.Key)
+	}
+	pipe.Exec(ctx)
+
+	var total int64
+	if limits.Enabled() {
+		total = client.checkBatchSizes(ctx, limits, items, typeCmds, results)
+	}
+
+	reads := make([]func() (interface{}, error), len(items))
+	pipe = client.RedisClient.Pipeline()
+	for i, cmd := range typeCmds {
+		if cmd == nil {
+			continue
+		}
+		keyType, err := cmd.Result()
+		switch {
+		case err != nil:
+			results[i].Status = http.StatusInternalServerError
+			results[i].Error = err.Error()
+		case keyType == "none":
+			results[i].Status = http.StatusNotFound
+			results[i].Error = "Key provided does not exist."
+		default:
+			results[i].ResponseType = &types.ResponseType{ValueType: keyType}
+			reads[i], _ = readValue(ctx, pipe, keyType, items[i].Key, items[i].Field)
+		}
+	}
+	pipe.Exec(ctx)
+
+	var found int
+	for i, read := range reads {
+		if read == nil {
+			continue
+		}
+		value, err := read()
+		value, err = exposure.Redactor(items[i].Key).value(results[i].ValueType, items[i].Field, value, err)
+		if err != nil {
+			results[i].ResponseType = nil
+			results[i].Status = errorStatus(err)
+			results[i].Error = err.Error()
+			continue
+		}
+		// the sizes of elements read by index/field are only known once they are read
+		if s, ok := value.(string); ok && items[i].Field != "" && limits.MaxBytes > 0 {
+			if total+int64(len(s)) > limits.MaxBytes {
+				results[i].ResponseType = nil
+				results[i].Status = http.StatusRequestEntityTooLarge
+				results[i].Error = batchTooLarge(limits)
+				continue
+			}
+			total += int64(len(s))
+		}
+		results[i].Value = value
+		results[i].Status = http.StatusOK
+		found++
+	}
+
+	span.SetAttributes(attribute.String("db.operation.name", "PIPELINE"), attribute.Int("rediseen.batch.size", len(items)),
+		attribute.Int("rediseen.result.entries", found))
+	return results
+}
+
+// checkBatchSizes sets status 413 to the results of keys (read whole) exceeding limits, or making the sum of
+// the bytes of values exceed MaxBytes, and drops their TYPE commands so that they are not read. It returns the sum
+func (client *ExtendedClient) checkBatchSizes(ctx context.Context, limits SizeLimits, items []types.BatchItemType,
+	typeCmds []*redis.StatusCmd, results []types.BatchResultType) (total int64) {
+	sizeCmds := make([]*redis.IntCmd, len(items))
+	memoryCmds := make([]*redis.IntCmd, len(items))
+	pipe := client.RedisClient.Pipeline()
+	for i, cmd := range typeCmds {
+		if cmd == nil || cmd.Err() != nil || items[i].Field != "" {
+			continue
+		}
+		sizeCmds[i] = sizeCommand(ctx, pipe, cmd.Val(), items[i].Key)
+		if limits.MaxBytes > 0 && sizeCmds[i] != nil {
+			memoryCmds[i] = pipe.MemoryUsage(ctx, items[i].Key)
+		}
+	}
+	pipe.Exec(ctx)
+
+	for i, cmd := range sizeCmds {
+		if cmd == nil {
+			continue
+		}
+		size, err := sizeOf(typeCmds[i].Val(), cmd, memoryCmds[i])
+		if err != nil {
+			continue
+		}
+		if exceeded := limits.Check(size); exceeded != nil {
+			typeCmds[i] = nil
+			results[i].Status = http.StatusRequestEntityTooLarge
+			results[i].Error = exceeded.Error
+			continue
+		}
+		if limits.MaxBytes > 0 && size.Bytes > 0 {
+			if total+size.Bytes > limits.MaxBytes {
+				typeCmds[i] = nil
+				results[i].Status = http.StatusRequestEntityTooLarge
+				results[i].Error = batchTooLarge(limits)
+				continue
 			}
+			total += size.Bytes
+		}
+	}
+	return total
+}
+
+// batchTooLarge is the error of keys not read since the sum of values would exceed MaxBytes
+func batchTooLarge(limits SizeLimits) string {
+	return fmt.Sprintf("Values of the batch would be too large (more than %d bytes in total). Read this key on its own",
+		limits.MaxBytes)
+}
+
+// readValue sends the command reading the key (or its index/field) according to its type, and returns
+// how to get the result. If c is a pipeline, the command is only queued, and result can be called once it is executed
+func readValue(ctx context.Context, c redis.Cmdable, keyType string, key string, indexOrField string) (result func() (interface{}, error), command string) {
+	if indexOrField == "" {
+		switch keyType {
+		case "string":
+			cmd := c.Get(ctx, key)
+			return func() (interface{}, error) { return cmd.Result() }, "GET"
+		case "list":
+			cmd := c.LRange(ctx, key, 0, -1)
+			return func() (interface{}, error) { return cmd.Result() }, "LRANGE"
 		case "set":
-			value, err = client.RedisClient.SIsMember(ctx, key, field).Result()
+			cmd := c.SMembers(ctx, key)
+			return func() (interface{}, error) { return cmd.Result() }, "SMEMBERS"
 		case "hash":
-			value, err = client.RedisClient.HGet(ctx, key, field).Result()
+			cmd := c.HGetAll(ctx, key)
+			return func() (interface{}, error) { return cmd.Result() }, "HGETALL"
 		case "zset":
-			value, err = client.RedisClient.ZRank(ctx, key, field).Result()
+			//TODO: a simple implementation given methods on sorted set can be very complicated
+			cmd := c.ZRange(ctx, key, 0, -1)
+			return func() (interface{}, error) { return cmd.Result() }, "ZRANGE"
+		case "stream":
+			cmd := c.XRange(ctx, key, "-", "+")
+			return func() (interface{}, error) {
+				messages, err := cmd.Result()
+				return streamEntries(messages), err
+			}, "XRANGE"
 		default:
-			err = errors.New(strNotImplemented)
+			return failedRead(errors.New(strNotImplemented)), ""
 		}
 	}
 
-	var errorCode int
-	if err != nil {
-		if strings.Contains(err.Error(), strNotImplemented) {
-			errorCode = http.StatusNotImplemented
-		} else if strings.Contains(err.Error(), strWrongTypeForIndexField) {
-			errorCode = http.StatusBadRequest
-		} else {
-			errorCode = http.StatusNotFound
-		}
-		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
-	} else {
-		js, _ = json.Marshal(types.ResponseType{ValueType: keyType, Value: value})
+	var index int64
+	if keyType == "string" || keyType == "list" {
+		index, _ = strconv.ParseInt(indexOrField, 10, 64)
+		if index == 0 && indexOrField != "0" {
+			return failedRead(errors.New(strWrongTypeForIndexField)), ""
+		}
+	}
+	field := indexOrField
+
+	switch keyType {
+	case "string":
+		cmd := c.GetRange(ctx, key, index, index)
+		return func() (interface{}, error) { return cmd.Result() }, "GETRANGE"
+	case "list":
+		cmd := c.LIndex(ctx, key, index)
+		return func() (interface{}, error) { return cmd.Result() }, "LINDEX"
+	case "set":
+		cmd := c.SIsMember(ctx, key, field)
+		return func() (interface{}, error) { return cmd.Result() }, "SISMEMBER"
+	case "hash":
+		cmd := c.HGet(ctx, key, field)
+		return func() (interface{}, error) { return cmd.Result() }, "HGET"
+	case "zset":
+		cmd := c.ZRank(ctx, key, field)
+		return func() (interface{}, error) { return cmd.Result() }, "ZRANK"
+	default:
+		return failedRead(errors.New(strNotImplemented)), ""
 	}
+}
+
+func failedRead(err error) func() (interface{}, error) {
+	return func() (interface{}, error) { return nil, err }
+}
 
-	return js, errorCode
+// errorStatus returns the HTTP status for the error of reading a key, or 0 if err is nil
+func errorStatus(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case strings.Contains(err.Error(), strNotImplemented):
+		return http.StatusNotImplemented
+	case strings.Contains(err.Error(), strWrongTypeForIndexField):
+		return http.StatusBadRequest
+	default:
+		return http.StatusNotFound
+	}
 }
 
 // RedisInfo takes the results of Redis INFO command, then return the result as JSON ([]byte format from json.Marshal)
-func (client *ExtendedClient) RedisInfo(section string, format string) ([]byte, error) {
+func (client *ExtendedClient) RedisInfo(ctx context.Context, section string, format string) ([]byte, error) {
+	ctx, span := client.startSpan(ctx, "RedisInfo")
+	defer span.End()
+
 	var infoResult string
 	var err error
 	if section == "" {
 		section = "all"
 	}
 	infoResult, err = client.RedisClient.Info(ctx, section).Result()
+	span.SetAttributes(attribute.String("db.operation.name", "INFO"), attribute.Int("rediseen.result.bytes", len(infoResult)))
+	setSpanError(span, err)
 
 	switch format {
 	case "json":
@@ -205,57 +641,13 @@ func (client *ExtendedClient) RedisInfo(section string, format string) ([]byte,
 		jsonResult, _ := json.Marshal(mapResult)
 		return jsonResult, nil
 	case "prometheus":
-		lines := strings.Split(infoResult, "\n")
-		var filtered []string
-		for _, l := range lines {
-			processedLines := parseInfoLine(l)
-			for _, pl := range processedLines {
-				filtered = append(filtered, pl)
-			}
+		if err != nil {
+			return []byte{}, err
 		}
-		return []byte(strings.ReplaceAll(strings.Join(filtered, "\n"), ":", " ")), nil
+		return infoToPrometheus(infoResult), nil
 	case "raw":
 		return []byte(infoResult), nil
 	default:
 		return []byte(infoResult), nil
 	}
 }
-
-func validateFloatValue(v string) bool {
-	_, err := strconv.ParseFloat(v, 64)
-	if err == nil {
-		return true
-	}
-	return false
-}
-
-func parseInfoLine(v string) []string {
-	result := []string{}
-
-	v = strings.ReplaceAll(v, "\r", "")
-
-	if strings.HasPrefix(v, "#") {
-		// Include the comment lines
-		result = append(result, v)
-	} else {
-		if strings.Contains(v, ",") {
-			// For "commandstats" and "keyspace"
-			// They need special parsing
-			split := strings.Split(v, ":")
-
-			for _, mv := range strings.Split(split[1], ",") {
-				mv = strings.ReplaceAll(mv, "=", " ")
-				result = append(result, split[0]+"_"+mv)
-			}
-		} else {
-			// for other metric lines
-			// The check here will help exclude all lines with non-float value,
-			// including lines like "used_memory_human:846.21K"
-			if strings.Contains(v, ":") && validateFloatValue(strings.Split(v, ":")[1]) {
-				result = append(result, strings.ReplaceAll(v, ":", " "))
-			}
-		}
-	}
-
-	return result
-}
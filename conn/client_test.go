@@ -1,11 +1,17 @@
 package conn
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/alicebob/miniredis"
+	"github.com/xd-deng/rediseen/types"
 	"os"
 	"reflect"
+	"regexp"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func Test_ClientPing(t *testing.T) {
@@ -23,29 +29,301 @@ func Test_ClientPing(t *testing.T) {
 	}
 }
 
-func Test_parseInfoLine(t *testing.T) {
-	data := []string{
-		"used_memory:866520",
-		"redis_version:6.0.8",
-		"# CPU",
-		"used_memory_human:846.21K",
-		"db0:keys=1053522,expires=7,avg_ttl=257671496273",
-		"cmdstat_info:calls=2,usec=254,usec_per_call=127.00",
-		"executable:/data/redis-server",
-	}
-	expected := [][]string{
-		{"used_memory 866520"},
-		{},
-		{"# CPU"},
-		{},
-		{"db0_keys 1053522", "db0_expires 7", "db0_avg_ttl 257671496273"},
-		{"cmdstat_info_calls 2", "cmdstat_info_usec 254", "cmdstat_info_usec_per_call 127.00"},
-		{},
-	}
-
-	for i, x := range data {
-		if !reflect.DeepEqual(parseInfoLine(x), expected[i]) {
-			t.Error("Something is wrong")
+func Test_infoToPrometheus(t *testing.T) {
+	data := "# Server\r\n" +
+		"redis_version:6.0.8\r\n" +
+		"redis_git_dirty:0\r\n" +
+		"executable:/data/redis-server\r\n" +
+		"\r\n" +
+		"# Memory\r\n" +
+		"used_memory:866520\r\n" +
+		"used_memory_human:846.21K\r\n" +
+		"\r\n" +
+		"# Stats\r\n" +
+		"total_connections_received:7\r\n" +
+		"keyspace_hits:3\r\n" +
+		"\r\n" +
+		"# CPU\r\n" +
+		"used_cpu_sys:1.5\r\n" +
+		"\r\n" +
+		"# Commandstats\r\n" +
+		"cmdstat_get:calls=5,usec=254,usec_per_call=50.80\r\n" +
+		"cmdstat_info:calls=2,usec=100,usec_per_call=50.00\r\n" +
+		"\r\n" +
+		"# Keyspace\r\n" +
+		"db0:keys=888,expires=7,avg_ttl=2000\r\n" +
+		"db3:keys=1,expires=0,avg_ttl=0\r\n"
+
+	expected := "# HELP redis_git_dirty Redis INFO field redis_git_dirty (section server).\n" +
+		"# TYPE redis_git_dirty gauge\n" +
+		"redis_git_dirty 0\n" +
+		"# HELP redis_used_memory Redis INFO field used_memory (section memory).\n" +
+		"# TYPE redis_used_memory gauge\n" +
+		"redis_used_memory 866520\n" +
+		"# HELP redis_connections_received_total Redis INFO field total_connections_received (section stats).\n" +
+		"# TYPE redis_connections_received_total counter\n" +
+		"redis_connections_received_total 7\n" +
+		"# HELP redis_keyspace_hits_total Redis INFO field keyspace_hits (section stats).\n" +
+		"# TYPE redis_keyspace_hits_total counter\n" +
+		"redis_keyspace_hits_total 3\n" +
+		"# HELP redis_cpu_sys_seconds_total Redis INFO field used_cpu_sys (section cpu).\n" +
+		"# TYPE redis_cpu_sys_seconds_total counter\n" +
+		"redis_cpu_sys_seconds_total 1.5\n" +
+		"# HELP redis_commands_total Total number of calls per command.\n" +
+		"# TYPE redis_commands_total counter\n" +
+		"redis_commands_total{cmd=\"get\"} 5\n" +
+		"redis_commands_total{cmd=\"info\"} 2\n" +
+		"# HELP redis_commands_duration_seconds_total Total time spent per command.\n" +
+		"# TYPE redis_commands_duration_seconds_total counter\n" +
+		"redis_commands_duration_seconds_total{cmd=\"get\"} 0.000254\n" +
+		"redis_commands_duration_seconds_total{cmd=\"info\"} 0.0001\n" +
+		"# HELP redis_db_keys Number of keys in the logical DB.\n" +
+		"# TYPE redis_db_keys gauge\n" +
+		"redis_db_keys{db=\"0\"} 888\n" +
+		"redis_db_keys{db=\"3\"} 1\n" +
+		"# HELP redis_db_keys_expiring Number of keys with an expiration in the logical DB.\n" +
+		"# TYPE redis_db_keys_expiring gauge\n" +
+		"redis_db_keys_expiring{db=\"0\"} 7\n" +
+		"redis_db_keys_expiring{db=\"3\"} 0\n" +
+		"# HELP redis_db_avg_ttl_seconds Average TTL of keys with an expiration in the logical DB.\n" +
+		"# TYPE redis_db_avg_ttl_seconds gauge\n" +
+		"redis_db_avg_ttl_seconds{db=\"0\"} 2\n" +
+		"redis_db_avg_ttl_seconds{db=\"3\"} 0\n" +
+		"# HELP redis_server_info Non-numeric fields of Redis INFO section server.\n" +
+		"# TYPE redis_server_info gauge\n" +
+		"redis_server_info{executable=\"/data/redis-server\",redis_version=\"6.0.8\"} 1\n"
+
+	result := string(infoToPrometheus(data))
+	if result != expected {
+		t.Error("Expecting\n", expected, "\ngot\n", result)
+	}
+}
+
+func Test_ScanValue(t *testing.T) {
+
+	mr, _ := miniredis.Run()
+	defer mr.Close()
+
+	// more elements than a chunk, so that they are read in several chunks
+	n := scanChunkSize*2 + 10
+	for i := 0; i < n; i++ {
+		mr.Lpush("list", fmt.Sprint(i))
+		mr.SetAdd("set", fmt.Sprint(i))
+		mr.HSet("hash", fmt.Sprint(i), "v")
+		mr.ZAdd("zset", float64(i), fmt.Sprint(i))
+	}
+
+	var client ExtendedClient
+	client.InitFromURI(fmt.Sprintf("redis://:@%s", mr.Addr()))
+	defer client.RedisClient.Close()
+
+	for _, keyType := range []string{"list", "set", "hash", "zset"} {
+		seen := make(map[interface{}]bool)
+		var last interface{}
+		var count int
+		err := client.ScanValue(context.Background(), keyType, keyType, func(element interface{}) error {
+			seen[element] = true
+			last = element
+			count++
+			return nil
+		})
+		if err != nil || len(seen) != n || count != n {
+			t.Error("Expecting", n, "elements of", keyType, "got", len(seen), count, err)
+		}
+		if keyType == "list" && last != "0" {
+			t.Error("Expecting elements of list in order, got", last, "as the last one")
+		}
+		if keyType == "hash" && !seen[types.HashEntryType{Field: "7", Value: "v"}] {
+			t.Error("Expecting fields of hash as types.HashEntryType")
+		}
+		if keyType == "zset" && last != (types.SortedSetEntryType{Member: fmt.Sprint(n - 1), Score: float64(n - 1)}) {
+			t.Error("Expecting members of sorted set ordered by score, got", last, "as the last one")
+		}
+	}
+
+	// values no larger than a chunk are read with a single command
+	mr.SetAdd("small:set", "a", "b")
+	mr.HSet("small:hash", "f1", "v1")
+	mr.HSet("small:hash", "f2", "v2")
+	for _, keyType := range []string{"set", "hash"} {
+		var elements []interface{}
+		err := client.ScanValue(context.Background(), "small:"+keyType, keyType, func(element interface{}) error {
+			elements = append(elements, element)
+			return nil
+		})
+		if err != nil || len(elements) != 2 {
+			t.Error("Expecting 2 elements of small", keyType, "got", elements, err)
+		}
+	}
+
+	stop := errors.New("stop")
+	var count int
+	err := client.ScanValue(context.Background(), "list", "list", func(element interface{}) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Error("Expecting ScanValue to stop at the error of emit, got", err, count)
+	}
+
+	mr.Set("string", "x")
+	if err := client.ScanValue(context.Background(), "string", "string", nil); err == nil {
+		t.Error("Expecting error for string")
+	}
+}
+
+func Test_ReadPage(t *testing.T) {
+
+	mr, _ := miniredis.Run()
+	defer mr.Close()
+
+	// pages larger than a chunk, so that they are read in several chunks
+	n := scanChunkSize*3 + 10
+	for i := 0; i < n; i++ {
+		mr.Lpush("list", fmt.Sprint(i))
+		mr.SetAdd("set", fmt.Sprint(i))
+		mr.HSet("hash", fmt.Sprint(i), "v")
+		mr.ZAdd("zset", float64(i), fmt.Sprint(i))
+	}
+
+	var client ExtendedClient
+	client.InitFromURI(fmt.Sprintf("redis://:@%s", mr.Addr()))
+	defer client.RedisClient.Close()
+
+	for _, keyType := range []string{"list", "set", "hash", "zset"} {
+		seen := make(map[interface{}]bool)
+		cursor := "0"
+		pages := 0
+		for {
+			next, err := client.ReadPage(context.Background(), keyType, keyType, cursor, scanChunkSize+500, func(element interface{}) error {
+				seen[element] = true
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages++
+			if cursor = next; cursor == "0" || pages > n {
+				break
+			}
+		}
+		if len(seen) != n {
+			t.Error("Expecting", n, "elements of", keyType, "got", len(seen))
+		}
+	}
+
+	// cursors of streams are IDs of entries, while those of other types are integers
+	for _, c := range []struct{ keyType, cursor string }{{"stream", "1"}, {"stream", "1-x"}, {"list", "1-0"}} {
+		if _, err := client.ReadPage(context.Background(), c.keyType, c.keyType, c.cursor, 10, nil); err != ErrInvalidCursor {
+			t.Error("Expecting ErrInvalidCursor for cursor", c.cursor, "of", c.keyType, "got", err)
 		}
 	}
+
+	size, err := client.Size(context.Background(), "zset", "zset", true)
+	if err != nil || size.Elements != int64(n) || size.Bytes != -1 {
+		t.Error("Expecting", n, "elements with unknown bytes, got", size, err)
+	}
+}
+
+func Test_ScanKeys(t *testing.T) {
+
+	mr, _ := miniredis.Run()
+	defer mr.Close()
+
+	for i := 0; i < 25; i++ {
+		mr.Set(fmt.Sprintf("user:%d", i), "v")
+		mr.HSet(fmt.Sprintf("user:%d:profile", i), "name", "v")
+		mr.Set(fmt.Sprintf("internal:%d", i), "v")
+	}
+	mr.SetTTL("user:0", 90*time.Second)
+
+	var client ExtendedClient
+	client.InitFromURI(fmt.Sprintf("redis://:@%s", mr.Addr()))
+	defer client.RedisClient.Close()
+	exposure := &Exposure{Allowed: regexp.MustCompile("^user:"), Denied: regexp.MustCompile(":profile$")}
+
+	// pages until cursor 0, with only exposed keys
+	seen := make(map[string]bool)
+	var cursor uint64
+	for pages := 0; pages < 100; pages++ {
+		page, err := client.ScanKeys(context.Background(), exposure, cursor, 10, "", "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range page.Keys {
+			if k.Type != "string" || k.TTL != nil || k.Bytes != nil {
+				t.Error("Expecting exposed string without metadata, got", k)
+			}
+			seen[k.Key] = true
+		}
+		if cursor, _ = strconv.ParseUint(page.Cursor, 10, 64); cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 25 {
+		t.Error("Expecting 25 keys, got", len(seen))
+	}
+
+	// pattern, type and metadata (MEMORY USAGE is not supported by miniredis)
+	exposure.Denied = nil
+	page, err := client.ScanKeys(context.Background(), exposure, 0, 1000, "user:0*", "string", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Cursor != "0" || len(page.Keys) != 1 || page.Keys[0].Key != "user:0" || *page.Keys[0].TTL != 90 || page.Keys[0].Bytes != nil {
+		t.Error("Expecting user:0 with TTL 90, got", page)
+	}
+}
+
+func Test_CountKeys(t *testing.T) {
+
+	mr, _ := miniredis.Run()
+	defer mr.Close()
+
+	for i := 0; i < 2500; i++ {
+		mr.Set(fmt.Sprintf("user:%d", i), "v")
+	}
+	mr.Set("user:1:token", "v")
+	mr.Set("user:secret", "v")
+	mr.Set("internal:1", "v")
+
+	var client ExtendedClient
+	client.InitFromURI(fmt.Sprintf("redis://:@%s", mr.Addr()))
+	defer client.RedisClient.Close()
+	exposure := &Exposure{Allowed: regexp.MustCompile("^user:"), Denied: regexp.MustCompile(":token$"),
+		Redactions: []Redaction{{Key: regexp.MustCompile("^user:secret$"), Drop: true}}}
+
+	// keys not exposed, denied or dropped are not counted
+	count, err := client.CountKeys(context.Background(), exposure)
+	if err != nil || count != 2500 {
+		t.Error("Expecting 2500 keys, got", count, err)
+	}
+}
+
+func Test_nextStreamID(t *testing.T) {
+	for id, expected := range map[string]string{
+		"1526919030474-55":                   "1526919030474-56",
+		"1526919030474-18446744073709551615": "1526919030475-0",
+		"0-0":                                "0-1",
+	} {
+		next, err := nextStreamID(id)
+		if err != nil || next != expected {
+			t.Error("Expecting", expected, "got", next, err)
+		}
+	}
+	if _, err := nextStreamID("1526919030474"); err == nil {
+		t.Error("Expecting error for invalid ID")
+	}
+
+	// fields of entries are redacted like fields of hashes
+	redactor := (&Exposure{Redactions: []Redaction{
+		{Key: regexp.MustCompile("^events$"), Element: regexp.MustCompile("^token$"), Drop: true},
+		{Key: regexp.MustCompile("^events$"), Element: regexp.MustCompile("^email$")},
+	}}).Redactor("events")
+	entries := []types.StreamEntryType{{ID: "1-0", Fields: map[string]string{"token": "t", "email": "e", "action": "login"}}}
+	redacted, _ := redactor.value("stream", "", entries, nil)
+	expected := []types.StreamEntryType{{ID: "1-0", Fields: map[string]string{"email": Mask, "action": "login"}}}
+	if !reflect.DeepEqual(redacted, expected) {
+		t.Error("Expecting", expected, "got", redacted)
+	}
 }
@@ -0,0 +1,239 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xd-deng/rediseen/types"
+)
+
+const strUnexpectedReply = "unexpected reply from Redis"
+
+// SlowLog runs SLOWLOG LEN and SLOWLOG GET <count>
+func (client *ExtendedClient) SlowLog(ctx context.Context, count int) (result types.SlowLogType, err error) {
+	ctx, span := client.startSpan(ctx, "SlowLog")
+	defer func() { endSpan(span, "SLOWLOG", len(result.Entries), err) }()
+
+	length, err := client.RedisClient.Do(ctx, "slowlog", "len").Int64()
+	if err != nil {
+		return result, err
+	}
+	result.Length = length
+
+	reply, err := client.RedisClient.Do(ctx, "slowlog", "get", count).Result()
+	if err != nil {
+		return result, err
+	}
+	result.Entries, err = parseSlowLog(reply)
+	return result, err
+}
+
+// parseSlowLog parses reply of SLOWLOG GET, which is an array of entries like
+// [id, timestamp, duration, [arg, ...], client_addr, client_name] (the last two are only given since Redis 4.0)
+func parseSlowLog(reply interface{}) ([]types.SlowLogEntryType, error) {
+	entries := []types.SlowLogEntryType{}
+
+	rawEntries, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.New(strUnexpectedReply)
+	}
+
+	for _, rawEntry := range rawEntries {
+		fields, ok := rawEntry.([]interface{})
+		if !ok || len(fields) < 4 {
+			return nil, errors.New(strUnexpectedReply)
+		}
+
+		var entry types.SlowLogEntryType
+		entry.ID, _ = fields[0].(int64)
+		entry.Timestamp, _ = fields[1].(int64)
+		entry.DurationMicroseconds, _ = fields[2].(int64)
+		args, _ := fields[3].([]interface{})
+		entry.Args = make([]string, 0, len(args))
+		for _, a := range args {
+			entry.Args = append(entry.Args, fmt.Sprint(a))
+		}
+		if len(fields) >= 6 {
+			entry.ClientAddr, _ = fields[4].(string)
+			entry.ClientName, _ = fields[5].(string)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// LatencyLatest runs LATENCY LATEST
+func (client *ExtendedClient) LatencyLatest(ctx context.Context) (events []types.LatencyEventType, err error) {
+	ctx, span := client.startSpan(ctx, "LatencyLatest")
+	defer func() { endSpan(span, "LATENCY", len(events), err) }()
+
+	reply, err := client.RedisClient.Do(ctx, "latency", "latest").Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseLatencyLatest(reply)
+}
+
+// parseLatencyLatest parses reply of LATENCY LATEST, which is an array of [event, timestamp, latest, max]
+func parseLatencyLatest(reply interface{}) ([]types.LatencyEventType, error) {
+	events := []types.LatencyEventType{}
+
+	rawEvents, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.New(strUnexpectedReply)
+	}
+
+	for _, rawEvent := range rawEvents {
+		fields, ok := rawEvent.([]interface{})
+		if !ok || len(fields) < 4 {
+			return nil, errors.New(strUnexpectedReply)
+		}
+
+		var event types.LatencyEventType
+		event.Event, _ = fields[0].(string)
+		event.Timestamp, _ = fields[1].(int64)
+		event.LatestMilliseconds, _ = fields[2].(int64)
+		event.MaxMilliseconds, _ = fields[3].(int64)
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// LatencyHistory runs LATENCY HISTORY <event>
+func (client *ExtendedClient) LatencyHistory(ctx context.Context, event string) (samples []types.LatencySampleType, err error) {
+	ctx, span := client.startSpan(ctx, "LatencyHistory")
+	defer func() { endSpan(span, "LATENCY", len(samples), err) }()
+
+	reply, err := client.RedisClient.Do(ctx, "latency", "history", event).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseLatencyHistory(reply)
+}
+
+// parseLatencyHistory parses reply of LATENCY HISTORY, which is an array of [timestamp, latency]
+func parseLatencyHistory(reply interface{}) ([]types.LatencySampleType, error) {
+	samples := []types.LatencySampleType{}
+
+	rawSamples, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.New(strUnexpectedReply)
+	}
+
+	for _, rawSample := range rawSamples {
+		fields, ok := rawSample.([]interface{})
+		if !ok || len(fields) < 2 {
+			return nil, errors.New(strUnexpectedReply)
+		}
+
+		var sample types.LatencySampleType
+		sample.Timestamp, _ = fields[0].(int64)
+		sample.LatencyMilliseconds, _ = fields[1].(int64)
+		samples = append(samples, sample)
+	}
+
+	return samples, nil
+}
+
+// ClientList runs CLIENT LIST, and parses each client into an object
+func (client *ExtendedClient) ClientList(ctx context.Context) (clients []map[string]string, err error) {
+	ctx, span := client.startSpan(ctx, "ClientList")
+	defer func() { endSpan(span, "CLIENT", len(clients), err) }()
+
+	reply, err := client.RedisClient.ClientList(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseClientList(reply), nil
+}
+
+// parseClientList parses lines like "id=3 addr=127.0.0.1:6379 name= age=1 ..." into a list of maps
+func parseClientList(reply string) []map[string]string {
+	clients := []map[string]string{}
+
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		c := make(map[string]string)
+		for _, kv := range strings.Split(line, " ") {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			c[parts[0]] = parts[1]
+		}
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+// MemoryStats runs MEMORY STATS
+func (client *ExtendedClient) MemoryStats(ctx context.Context) (stats map[string]interface{}, err error) {
+	ctx, span := client.startSpan(ctx, "MemoryStats")
+	defer func() { endSpan(span, "MEMORY", len(stats), err) }()
+
+	reply, err := client.RedisClient.Do(ctx, "memory", "stats").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	stats, ok := parseFlatMap(reply).(map[string]interface{})
+	if !ok {
+		return nil, errors.New(strUnexpectedReply)
+	}
+	return stats, nil
+}
+
+// parseFlatMap turns replies like [name1, value1, name2, value2, ...] into a map, recursively.
+// Numbers given as strings (like "0.85") are converted into float
+func parseFlatMap(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case []interface{}:
+		if len(v)%2 != 0 {
+			return v
+		}
+		result := make(map[string]interface{})
+		for i := 0; i < len(v); i += 2 {
+			result[fmt.Sprint(v[i])] = parseFlatMap(v[i+1])
+		}
+		return result
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// ConfigGet runs CONFIG GET for each parameter given. Parameters are taken literally (glob patterns are not expanded)
+func (client *ExtendedClient) ConfigGet(ctx context.Context, parameters []string) (result map[string]string, err error) {
+	ctx, span := client.startSpan(ctx, "ConfigGet")
+	defer func() { endSpan(span, "CONFIG", len(result), err) }()
+
+	result = make(map[string]string)
+
+	for _, p := range parameters {
+		reply, err := client.RedisClient.ConfigGet(ctx, p).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(reply); i += 2 {
+			if fmt.Sprint(reply[i]) == p {
+				result[p] = fmt.Sprint(reply[i+1])
+			}
+		}
+	}
+
+	return result, nil
+}
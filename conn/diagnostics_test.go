@@ -0,0 +1,89 @@
+package conn
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xd-deng/rediseen/types"
+)
+
+func Test_parseSlowLog(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{int64(14), int64(1309448221), int64(15), []interface{}{"ping"}, "127.0.0.1:58217", "worker-1"},
+		[]interface{}{int64(13), int64(1309448128), int64(30), []interface{}{"slowlog", "get", "100"}},
+	}
+	expected := []types.SlowLogEntryType{
+		{ID: 14, Timestamp: 1309448221, DurationMicroseconds: 15, Args: []string{"ping"}, ClientAddr: "127.0.0.1:58217", ClientName: "worker-1"},
+		{ID: 13, Timestamp: 1309448128, DurationMicroseconds: 30, Args: []string{"slowlog", "get", "100"}},
+	}
+
+	result, err := parseSlowLog(reply)
+	if err != nil {
+		t.Error("Not expecting error but got error")
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Error("Expecting\n", expected, "\ngot\n", result)
+	}
+
+	_, err = parseSlowLog("OK")
+	if err == nil {
+		t.Error("Expecting error but got nil")
+	}
+}
+
+func Test_parseLatency(t *testing.T) {
+	latest, err := parseLatencyLatest([]interface{}{
+		[]interface{}{"command", int64(1405067976), int64(251), int64(1001)},
+	})
+	if err != nil {
+		t.Error("Not expecting error but got error")
+	}
+	expectedLatest := []types.LatencyEventType{{Event: "command", Timestamp: 1405067976, LatestMilliseconds: 251, MaxMilliseconds: 1001}}
+	if !reflect.DeepEqual(latest, expectedLatest) {
+		t.Error("Expecting\n", expectedLatest, "\ngot\n", latest)
+	}
+
+	history, err := parseLatencyHistory([]interface{}{
+		[]interface{}{int64(1405067822), int64(251)},
+		[]interface{}{int64(1405067941), int64(1001)},
+	})
+	if err != nil {
+		t.Error("Not expecting error but got error")
+	}
+	expectedHistory := []types.LatencySampleType{{Timestamp: 1405067822, LatencyMilliseconds: 251}, {Timestamp: 1405067941, LatencyMilliseconds: 1001}}
+	if !reflect.DeepEqual(history, expectedHistory) {
+		t.Error("Expecting\n", expectedHistory, "\ngot\n", history)
+	}
+}
+
+func Test_parseClientList(t *testing.T) {
+	reply := "id=3 addr=127.0.0.1:50188 fd=8 name= age=1 cmd=client\n" +
+		"id=4 addr=127.0.0.1:50190 fd=9 name=worker age=5 cmd=get\n"
+	expected := []map[string]string{
+		{"id": "3", "addr": "127.0.0.1:50188", "fd": "8", "name": "", "age": "1", "cmd": "client"},
+		{"id": "4", "addr": "127.0.0.1:50190", "fd": "9", "name": "worker", "age": "5", "cmd": "get"},
+	}
+
+	result := parseClientList(reply)
+	if !reflect.DeepEqual(result, expected) {
+		t.Error("Expecting\n", expected, "\ngot\n", result)
+	}
+}
+
+func Test_parseFlatMap(t *testing.T) {
+	reply := []interface{}{
+		"peak.allocated", int64(866520),
+		"dataset.percentage", "85.5",
+		"db.0", []interface{}{"overhead.hashtable.main", int64(72)},
+	}
+	expected := map[string]interface{}{
+		"peak.allocated":     int64(866520),
+		"dataset.percentage": 85.5,
+		"db.0":               map[string]interface{}{"overhead.hashtable.main": int64(72)},
+	}
+
+	result := parseFlatMap(reply)
+	if !reflect.DeepEqual(result, expected) {
+		t.Error("Expecting\n", expected, "\ngot\n", result)
+	}
+}
@@ -0,0 +1,61 @@
+package conn
+
+// KeyspaceEvents is told about keyspace notifications by a KeyspaceListener
+type KeyspaceEvents interface {
+	// Connected is called once keyspace notifications are listened to
+	Connected()
+	// Disconnected is called when keyspace notifications can not be listened to any more (until Connected is
+	// called again), hence events may be missed
+	Disconnected(err error)
+	// Event is called with the key of the DB given, and the event (like `set`, `del` or `expired`)
+	Event(db int, key string, event string)
+}
+
+// KeyspaceListener listens to keyspace notifications of all DBs in Redis (given by REDISEEN_REDIS_URI)
+// on a dedicated connection, reconnecting whenever it is lost
+type KeyspaceListener struct {
+	subscriber
+	target KeyspaceEvents
+}
+
+// ListenKeyspaceEvents starts listening to keyspace notifications, which must be enabled with notify-keyspace-events
+func ListenKeyspaceEvents(target KeyspaceEvents) *KeyspaceListener {
+	l := &KeyspaceListener{subscriber: newSubscriber(), target: target}
+	go l.run(l.listen, target.Disconnected)
+	return l
+}
+
+// listen connects, subscribes, then handles notifications until the connection is lost
+func (l *KeyspaceListener) listen() (connected bool, err error) {
+	r, err := l.dial()
+	if r == nil {
+		return false, err
+	}
+	defer r.conn.Close()
+
+	if err = checkKeyspaceEvents(r); err != nil {
+		return false, err
+	}
+	if err = r.send("PSUBSCRIBE", keyspacePattern); err != nil {
+		return false, err
+	}
+	if _, err = r.read(); err != nil {
+		return false, err
+	}
+
+	l.target.Connected()
+	return true, l.receive(r, l.handle)
+}
+
+// handle dispatches a notification received, whose payload is the event
+func (l *KeyspaceListener) handle(reply interface{}) {
+	message, ok := reply.([]interface{})
+	if !ok || len(message) < 4 || message[0] != "pmessage" {
+		return
+	}
+	channel, _ := message[2].(string)
+	event, _ := message[3].(string)
+	if db, key, ok := parseKeyspaceChannel(channel); ok && event != "" {
+		l.target.Event(db, key, event)
+	}
+}
@@ -0,0 +1,48 @@
+package conn
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recordedKeyspaceEvents records what it is told, one event per line
+type recordedKeyspaceEvents chan string
+
+func (r recordedKeyspaceEvents) Connected() {
+	r <- "connected"
+}
+
+func (r recordedKeyspaceEvents) Disconnected(err error) {
+	r <- "disconnected " + err.Error()
+}
+
+func (r recordedKeyspaceEvents) Event(db int, key string, event string) {
+	r <- fmt.Sprintf("%s %d %s", event, db, key)
+}
+
+func Test_KeyspaceListener(t *testing.T) {
+	t.Setenv("REDISEEN_REDIS_URI", fakeRedis(t, map[string]string{
+		"CONFIG GET NOTIFY-KEYSPACE-EVENTS": "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nAKE\r\n",
+		"PSUBSCRIBE __KEYSPACE@*__:*":       "*3\r\n$10\r\npsubscribe\r\n$16\r\n__keyspace@*__:*\r\n:1\r\n",
+	}, "*4\r\n$8\r\npmessage\r\n$16\r\n__keyspace@*__:*\r\n$20\r\n__keyspace@3__:key:1\r\n$3\r\nset\r\n"+
+		"*2\r\n$4\r\npong\r\n$0\r\n\r\n"+
+		"*4\r\n$8\r\npmessage\r\n$16\r\n__keyspace@*__:*\r\n$20\r\n__keyspace@0__:key:2\r\n$7\r\nexpired\r\n"))
+
+	events := make(recordedKeyspaceEvents, 10)
+	l := ListenKeyspaceEvents(events)
+	defer l.Close()
+
+	expectEvents(t, recordedInvalidations(events), "connected", "set 3 key:1", "expired 0 key:2", "disconnected EOF")
+}
+
+func Test_KeyspaceListener_disabled(t *testing.T) {
+	t.Setenv("REDISEEN_REDIS_URI", fakeRedis(t, map[string]string{
+		"CONFIG GET NOTIFY-KEYSPACE-EVENTS": "*2\r\n$22\r\nnotify-keyspace-events\r\n$0\r\n\r\n",
+	}, ""))
+
+	events := make(recordedKeyspaceEvents, 10)
+	l := ListenKeyspaceEvents(events)
+	defer l.Close()
+
+	expectEvents(t, recordedInvalidations(events), "disconnected keyspace notifications are not enabled")
+}
@@ -0,0 +1,261 @@
+package conn
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xd-deng/rediseen/metrics"
+)
+
+const metricNamespace = "redis_"
+
+// counterFields are INFO fields which are cumulative, hence exposed as counters
+var counterFields = map[string]bool{
+	"total_connections_received":                true,
+	"total_commands_processed":                  true,
+	"total_net_input_bytes":                     true,
+	"total_net_output_bytes":                    true,
+	"total_net_repl_input_bytes":                true,
+	"total_net_repl_output_bytes":               true,
+	"total_reads_processed":                     true,
+	"total_writes_processed":                    true,
+	"total_error_replies":                       true,
+	"total_forks":                               true,
+	"total_eviction_exceeded_time":              true,
+	"rejected_connections":                      true,
+	"sync_full":                                 true,
+	"sync_partial_ok":                           true,
+	"sync_partial_err":                          true,
+	"expired_keys":                              true,
+	"evicted_keys":                              true,
+	"keyspace_hits":                             true,
+	"keyspace_misses":                           true,
+	"io_threaded_reads_processed":               true,
+	"io_threaded_writes_processed":              true,
+	"unexpected_error_replies":                  true,
+	"dump_payload_sanitizations":                true,
+	"total_active_defrag_time":                  true,
+	"active_defrag_hits":                        true,
+	"active_defrag_misses":                      true,
+	"active_defrag_key_hits":                    true,
+	"active_defrag_key_misses":                  true,
+	"evicted_clients":                           true,
+	"client_query_buffer_limit_disconnections":  true,
+	"client_output_buffer_limit_disconnections": true,
+}
+
+// cpuFields are INFO fields in section CPU, which are cumulative seconds
+var cpuFields = map[string]string{
+	"used_cpu_sys":              "cpu_sys_seconds_total",
+	"used_cpu_user":             "cpu_user_seconds_total",
+	"used_cpu_sys_children":     "cpu_sys_children_seconds_total",
+	"used_cpu_user_children":    "cpu_user_children_seconds_total",
+	"used_cpu_sys_main_thread":  "cpu_sys_main_thread_seconds_total",
+	"used_cpu_user_main_thread": "cpu_user_main_thread_seconds_total",
+}
+
+// metricFamily is a group of samples sharing the same metric name
+type metricFamily struct {
+	name       string
+	help       string
+	metricType string
+	samples    []metricSample
+}
+
+type metricSample struct {
+	labelNames  []string
+	labelValues []string
+	value       float64
+}
+
+// metricFamilies keeps metric families in the order they are first seen,
+// so that all samples of one family are rendered together as the exposition format requires
+type metricFamilies struct {
+	order    []string
+	families map[string]*metricFamily
+}
+
+func newMetricFamilies() *metricFamilies {
+	return &metricFamilies{families: make(map[string]*metricFamily)}
+}
+
+func (m *metricFamilies) add(name string, help string, metricType string, labelNames []string, labelValues []string, value float64) {
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, metricType: metricType}
+		m.families[name] = f
+		m.order = append(m.order, name)
+	}
+	f.samples = append(f.samples, metricSample{labelNames: labelNames, labelValues: labelValues, value: value})
+}
+
+func (m *metricFamilies) bytes() []byte {
+	var buf bytes.Buffer
+	for _, name := range m.order {
+		f := m.families[name]
+		metrics.WriteHeader(&buf, f.name, f.help, f.metricType)
+		for _, s := range f.samples {
+			metrics.WriteSample(&buf, f.name, s.labelNames, s.labelValues, s.value)
+		}
+	}
+	return buf.Bytes()
+}
+
+// infoToPrometheus converts the raw output of Redis INFO command into Prometheus text exposition format.
+// All metrics are prefixed with `redis_`. Lines like `db0:keys=1,expires=0,avg_ttl=0` or
+// `cmdstat_get:calls=5,...` are turned into labelled series, and non-numeric fields of each section
+// are exposed as labels of an info-style metric, e.g. `redis_server_info{redis_version="6.0.8",...} 1`
+func infoToPrometheus(infoResult string) []byte {
+	families := newMetricFamilies()
+
+	var sectionName string
+	infoLabels := map[string]map[string]string{}
+	var sectionOrder []string
+
+	for _, row := range strings.Split(infoResult, "\n") {
+		row = strings.TrimRight(row, "\r")
+		if row == "" {
+			continue
+		}
+		if strings.HasPrefix(row, "#") {
+			sectionName = strings.ToLower(strings.Trim(row, "# "))
+			continue
+		}
+
+		separator := strings.Index(row, ":")
+		if separator < 0 {
+			continue
+		}
+		field, value := row[:separator], row[separator+1:]
+
+		if strings.Contains(value, "=") {
+			addStructuredInfoLine(families, sectionName, field, value)
+			continue
+		}
+
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			addScalarInfoLine(families, sectionName, field, v)
+			continue
+		}
+
+		// Values like "846.21K" or "1.50%" are only human-readable duplicates of numeric fields
+		if strings.HasSuffix(field, "_human") || strings.HasSuffix(field, "_perc") {
+			continue
+		}
+		if _, ok := infoLabels[sectionName]; !ok {
+			infoLabels[sectionName] = make(map[string]string)
+			sectionOrder = append(sectionOrder, sectionName)
+		}
+		infoLabels[sectionName][metrics.SanitizeName(field)] = value
+	}
+
+	for _, section := range sectionOrder {
+		var labelNames, labelValues []string
+		for n := range infoLabels[section] {
+			labelNames = append(labelNames, n)
+		}
+		sort.Strings(labelNames)
+		for _, n := range labelNames {
+			labelValues = append(labelValues, infoLabels[section][n])
+		}
+		name := metricNamespace + metrics.SanitizeName(section) + "_info"
+		families.add(name, fmt.Sprintf("Non-numeric fields of Redis INFO section %s.", section),
+			"gauge", labelNames, labelValues, 1)
+	}
+
+	return families.bytes()
+}
+
+func addScalarInfoLine(families *metricFamilies, section string, field string, value float64) {
+	help := fmt.Sprintf("Redis INFO field %s (section %s).", field, section)
+
+	if name, ok := cpuFields[field]; ok {
+		families.add(metricNamespace+name, help, "counter", nil, nil, value)
+		return
+	}
+
+	if counterFields[field] {
+		name := metricNamespace + metrics.SanitizeName(strings.TrimPrefix(field, "total_")) + "_total"
+		families.add(name, help, "counter", nil, nil, value)
+		return
+	}
+
+	// Avoid names like `redis_redis_git_dirty`
+	name := metricNamespace + metrics.SanitizeName(strings.TrimPrefix(field, metricNamespace))
+	families.add(name, help, "gauge", nil, nil, value)
+}
+
+// addStructuredInfoLine handles lines whose value is a list of `k=v` pairs
+func addStructuredInfoLine(families *metricFamilies, section string, field string, value string) {
+	pairs := make(map[string]string)
+	var keys []string
+	for _, kv := range strings.Split(value, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		pairs[parts[0]] = parts[1]
+		keys = append(keys, parts[0])
+	}
+
+	switch {
+	case strings.HasPrefix(field, "db") && isInteger(field[2:]):
+		// Section "keyspace", e.g. db0:keys=1,expires=0,avg_ttl=0
+		labelNames, labelValues := []string{"db"}, []string{field[2:]}
+		addFloat(families, metricNamespace+"db_keys", "Number of keys in the logical DB.",
+			"gauge", labelNames, labelValues, pairs["keys"], 1)
+		addFloat(families, metricNamespace+"db_keys_expiring", "Number of keys with an expiration in the logical DB.",
+			"gauge", labelNames, labelValues, pairs["expires"], 1)
+		addFloat(families, metricNamespace+"db_avg_ttl_seconds", "Average TTL of keys with an expiration in the logical DB.",
+			"gauge", labelNames, labelValues, pairs["avg_ttl"], 1000)
+	case strings.HasPrefix(field, "cmdstat_"):
+		// Section "commandstats", e.g. cmdstat_get:calls=5,usec=10,usec_per_call=2.00
+		labelNames, labelValues := []string{"cmd"}, []string{strings.TrimPrefix(field, "cmdstat_")}
+		addFloat(families, metricNamespace+"commands_total", "Total number of calls per command.",
+			"counter", labelNames, labelValues, pairs["calls"], 1)
+		addFloat(families, metricNamespace+"commands_duration_seconds_total", "Total time spent per command.",
+			"counter", labelNames, labelValues, pairs["usec"], 1000000)
+		addFloat(families, metricNamespace+"commands_rejected_calls_total", "Total number of rejected calls per command.",
+			"counter", labelNames, labelValues, pairs["rejected_calls"], 1)
+		addFloat(families, metricNamespace+"commands_failed_calls_total", "Total number of failed calls per command.",
+			"counter", labelNames, labelValues, pairs["failed_calls"], 1)
+	case strings.HasPrefix(field, "errorstat_"):
+		// Section "errorstats", e.g. errorstat_ERR:count=1
+		addFloat(families, metricNamespace+"errors_total", "Total number of error replies per error prefix.",
+			"counter", []string{"err"}, []string{strings.TrimPrefix(field, "errorstat_")}, pairs["count"], 1)
+	case strings.HasPrefix(field, "slave") && isInteger(field[5:]):
+		// Section "replication", e.g. slave0:ip=127.0.0.1,port=6380,state=online,offset=1,lag=0
+		labelNames := []string{"slave", "ip", "port", "state"}
+		labelValues := []string{field[5:], pairs["ip"], pairs["port"], pairs["state"]}
+		addFloat(families, metricNamespace+"connected_slave_offset_bytes", "Replication offset of the connected replica.",
+			"gauge", labelNames, labelValues, pairs["offset"], 1)
+		addFloat(families, metricNamespace+"connected_slave_lag_seconds", "Replication lag of the connected replica.",
+			"gauge", labelNames, labelValues, pairs["lag"], 1)
+	default:
+		// Any other structured line, e.g. latency_percentiles_usec_get:p50=1.003,p99=1.003
+		for _, k := range keys {
+			name := metricNamespace + metrics.SanitizeName(field+"_"+k)
+			addFloat(families, name, fmt.Sprintf("Redis INFO field %s, item %s (section %s).", field, k, section),
+				"gauge", nil, nil, pairs[k], 1)
+		}
+	}
+}
+
+// addFloat adds a sample if rawValue can be parsed as a float. The parsed value is divided by divisor,
+// which helps convert values into base units (e.g. from microseconds into seconds)
+func addFloat(families *metricFamilies, name string, help string, metricType string,
+	labelNames []string, labelValues []string, rawValue string, divisor float64) {
+	v, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return
+	}
+	families.add(name, help, metricType, labelNames, labelValues, v/divisor)
+}
+
+func isInteger(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
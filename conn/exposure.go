@@ -0,0 +1,211 @@
+package conn
+
+import (
+	"regexp"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// Mask replaces the values (or members) masked by redaction rules
+const Mask = "[REDACTED]"
+
+// Exposure decides which keys are exposed, and what of their values is redacted
+type Exposure struct {
+	Allowed    *regexp.Regexp
+	Denied     *regexp.Regexp // nil if no key is denied. It takes precedence over Allowed
+	Redactions []Redaction
+}
+
+// Redaction drops or masks the elements of keys matching Key: hash fields and fields of stream entries (by their
+// names), and members of sets and sorted sets, and elements of lists, matching Element. If Element is nil, the whole value is redacted:
+// dropped keys are treated as absent, while every element (or the string) of masked keys is masked
+type Redaction struct {
+	Key     *regexp.Regexp
+	Element *regexp.Regexp
+	Drop    bool // otherwise masked
+}
+
+// Exposes tells if the key matches the allowed pattern, and not the denied one
+func (e *Exposure) Exposes(key string) bool {
+	return e.Allowed.MatchString(key) && (e.Denied == nil || !e.Denied.MatchString(key))
+}
+
+// Drops tells if the key is dropped whole by redaction rules, i.e. treated as absent
+func (e *Exposure) Drops(key string) bool {
+	for _, r := range e.Redactions {
+		if r.Element == nil && r.Drop && r.Key.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor returns the redaction rules for the key, or nil if there is none
+func (e *Exposure) Redactor(key string) *Redactor {
+	var redactor *Redactor
+	for _, r := range e.Redactions {
+		if r.Key.MatchString(key) {
+			if redactor == nil {
+				redactor = &Redactor{}
+			}
+			redactor.rules = append(redactor.rules, r)
+		}
+	}
+	return redactor
+}
+
+// Redactor redacts the value of a key. A nil Redactor redacts nothing
+type Redactor struct {
+	rules []Redaction
+}
+
+// action tells if the element (hash field, member or list element) given is dropped, or masked
+func (r *Redactor) action(element string) (drop bool, mask bool) {
+	if r == nil {
+		return false, false
+	}
+	for _, rule := range r.rules {
+		if rule.Element == nil || rule.Element.MatchString(element) {
+			if rule.Drop {
+				return true, false
+			}
+			mask = true
+		}
+	}
+	return false, mask
+}
+
+// masksWhole tells if any rule masks the whole value
+func (r *Redactor) masksWhole() bool {
+	if r == nil {
+		return false
+	}
+	for _, rule := range r.rules {
+		if rule.Element == nil && !rule.Drop {
+			return true
+		}
+	}
+	return false
+}
+
+// Element redacts an element of the key type given, as given to emit by ScanValue and ReadPage (which gives
+// strings whole, or by page). keep is false if it is dropped
+func (r *Redactor) Element(keyType string, element interface{}) (redacted interface{}, keep bool) {
+	switch e := element.(type) {
+	case string:
+		if keyType == "string" {
+			// strings have no elements, so only rules of whole values apply
+			if r.masksWhole() {
+				return Mask, true
+			}
+			return e, true
+		}
+		drop, mask := r.action(e)
+		if mask {
+			return Mask, true
+		}
+		return e, !drop
+	case types.HashEntryType:
+		drop, mask := r.action(e.Field)
+		if mask {
+			e.Value = Mask
+		}
+		return e, !drop
+	case types.SortedSetEntryType:
+		drop, mask := r.action(e.Member)
+		if mask {
+			e.Member = Mask
+		}
+		return e, !drop
+	case types.StreamEntryType:
+		if r == nil {
+			return e, true
+		}
+		fields := make(map[string]string, len(e.Fields))
+		for field, value := range e.Fields {
+			drop, mask := r.action(field)
+			if mask {
+				value = Mask
+			}
+			if !drop {
+				fields[field] = value
+			}
+		}
+		e.Fields = fields
+		return e, true
+	default:
+		return element, true
+	}
+}
+
+// Emit wraps emit (of ScanValue or ReadPage), so that elements of the key type given are redacted
+func (r *Redactor) Emit(keyType string, emit func(element interface{}) error) func(element interface{}) error {
+	if r == nil {
+		return emit
+	}
+	return func(element interface{}) error {
+		if redacted, keep := r.Element(keyType, element); keep {
+			return emit(redacted)
+		}
+		return nil
+	}
+}
+
+// value redacts a value as given by readValue. For an index or field, err is redis.Nil if it is dropped
+func (r *Redactor) value(keyType string, indexOrField string, value interface{}, err error) (interface{}, error) {
+	if r == nil || err != nil {
+		return value, err
+	}
+
+	if keyType == "string" {
+		redacted, _ := r.Element(keyType, value)
+		return redacted, nil
+	}
+
+	if indexOrField != "" {
+		element := indexOrField
+		if keyType == "list" {
+			element, _ = value.(string)
+		}
+		drop, mask := r.action(element)
+		switch {
+		case drop && keyType == "set":
+			// dropped members are absent
+			return false, nil
+		case drop:
+			return nil, redis.Nil
+		case mask && (keyType == "hash" || keyType == "list"):
+			return Mask, nil
+		}
+		return value, nil
+	}
+
+	switch v := value.(type) {
+	case []string:
+		redacted := make([]string, 0, len(v))
+		for _, element := range v {
+			if e, keep := r.Element(keyType, element); keep {
+				redacted = append(redacted, e.(string))
+			}
+		}
+		return redacted, nil
+	case map[string]string:
+		redacted := make(map[string]string, len(v))
+		for field, fieldValue := range v {
+			if e, keep := r.Element(keyType, types.HashEntryType{Field: field, Value: fieldValue}); keep {
+				redacted[field] = e.(types.HashEntryType).Value
+			}
+		}
+		return redacted, nil
+	case []types.StreamEntryType:
+		redacted := make([]types.StreamEntryType, 0, len(v))
+		for _, entry := range v {
+			e, _ := r.Element(keyType, entry)
+			redacted = append(redacted, e.(types.StreamEntryType))
+		}
+		return redacted, nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,270 @@
+package conn
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Ways to learn about changes in Redis
+const (
+	// InvalidationTracking uses client-side caching (CLIENT TRACKING, Redis 6+): Redis tells which keys read
+	// by tracked connections are changed
+	InvalidationTracking = "tracking"
+	// InvalidationKeyspace uses keyspace notifications, which must be enabled with notify-keyspace-events
+	InvalidationKeyspace = "keyspace"
+)
+
+const invalidationChannel = "__redis__:invalidate"
+const keyspacePattern = "__keyspace@*__:*"
+
+// Invalidations is told about changes in Redis by an InvalidationListener
+type Invalidations interface {
+	// Connected is called once changes are listened to, in the mode given. In tracking mode, reading connections
+	// must redirect tracking to the client ID given (see InitTracked), so that their reads are tracked
+	Connected(mode string, redirectID int64)
+	// Disconnected is called when changes can not be listened to any more (until Connected is called again),
+	// hence anything may have changed
+	Disconnected(err error)
+	// Invalidate is called with the key changed in the DB given, or in any DB if db is negative
+	Invalidate(db int, key string)
+	// InvalidateAll is called when all keys may have changed
+	InvalidateAll()
+}
+
+// InvalidationListener listens to changes in Redis (given by REDISEEN_REDIS_URI) on a dedicated connection,
+// reconnecting whenever it is lost
+type InvalidationListener struct {
+	subscriber
+	target Invalidations
+	mode   string
+}
+
+// ListenInvalidations starts listening to changes in the mode given. In tracking mode, keyspace notifications
+// are used instead if Redis does not support client-side caching
+func ListenInvalidations(mode string, target Invalidations) *InvalidationListener {
+	l := &InvalidationListener{subscriber: newSubscriber(), target: target, mode: mode}
+	go l.run(l.listen, target.Disconnected)
+	return l
+}
+
+// listen connects, subscribes, then handles messages until the connection is lost
+func (l *InvalidationListener) listen() (connected bool, err error) {
+	r, err := l.dial()
+	if r == nil {
+		return false, err
+	}
+	defer r.conn.Close()
+
+	var redirectID int64
+	if l.mode == InvalidationTracking {
+		redirectID, err = trackingRedirectID(r)
+		var redisErr respError
+		if errors.As(err, &redisErr) {
+			// client-side caching is not supported
+			l.mode = InvalidationKeyspace
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	if l.mode == InvalidationTracking {
+		err = r.send("SUBSCRIBE", invalidationChannel)
+	} else {
+		if err = checkKeyspaceEvents(r); err != nil {
+			return false, err
+		}
+		err = r.send("PSUBSCRIBE", keyspacePattern)
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err = r.read(); err != nil {
+		return false, err
+	}
+
+	l.target.Connected(l.mode, redirectID)
+	return true, l.receive(r, l.handle)
+}
+
+// trackingRedirectID gives the ID of the connection, once it is known that CLIENT TRACKING is supported
+func trackingRedirectID(r *respConn) (int64, error) {
+	reply, err := r.do("CLIENT", "ID")
+	if err != nil {
+		return 0, err
+	}
+	id, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply to CLIENT ID: %v", reply)
+	}
+	// harmless on this connection, but fails if CLIENT TRACKING is not supported
+	if _, err = r.do("CLIENT", "TRACKING", "off"); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// checkKeyspaceEvents makes sure that keyspace notifications are enabled for all commands (flags `K` and `A`).
+// It is assumed that they are, if CONFIG is not allowed
+func checkKeyspaceEvents(r *respConn) error {
+	reply, err := r.do("CONFIG", "GET", "notify-keyspace-events")
+	var redisErr respError
+	if errors.As(err, &redisErr) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != 2 {
+		return fmt.Errorf("unexpected reply to CONFIG GET: %v", reply)
+	}
+	flags, _ := values[1].(string)
+	if !strings.Contains(flags, "K") || !strings.Contains(flags, "A") {
+		return fmt.Errorf("keyspace notifications are not enabled for all commands "+
+			"(notify-keyspace-events is `%s`, while `KA` is needed)", flags)
+	}
+	return nil
+}
+
+// handle dispatches a message received
+func (l *InvalidationListener) handle(reply interface{}) {
+	message, ok := reply.([]interface{})
+	if !ok || len(message) < 3 {
+		return
+	}
+	switch message[0] {
+	case "message":
+		// payload is the keys changed, or nil if all keys may have changed (e.g. FLUSHALL)
+		if message[1] != invalidationChannel {
+			return
+		}
+		if message[2] == nil {
+			l.target.InvalidateAll()
+			return
+		}
+		keys, _ := message[2].([]interface{})
+		for _, key := range keys {
+			if k, ok := key.(string); ok {
+				l.target.Invalidate(-1, k)
+			}
+		}
+	case "pmessage":
+		if len(message) < 4 {
+			return
+		}
+		channel, _ := message[2].(string)
+		if db, key, ok := parseKeyspaceChannel(channel); ok {
+			l.target.Invalidate(db, key)
+		}
+	}
+}
+
+// InitTracked is like Init, but its connections are tracked (see InvalidationTracking), with invalidations
+// redirected to the client ID given. Connections are not tracked if redirectID is 0
+func (client *ExtendedClient) InitTracked(db int, redirectID int64) {
+	parsedUri, _ := redis.ParseURL(os.Getenv("REDISEEN_REDIS_URI"))
+
+	options := &redis.Options{
+		Addr:     parsedUri.Addr,
+		Password: parsedUri.Password,
+		DB:       db,
+	}
+	if redirectID != 0 {
+		options.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+			cmd := redis.NewCmd(ctx, "CLIENT", "TRACKING", "on", "REDIRECT", redirectID)
+			cn.Process(ctx, cmd)
+			return cmd.Err()
+		}
+	}
+	client.RedisClient = redis.NewClient(options)
+	client.RedisClient.AddHook(tracingHook{db: db})
+}
+
+// respError is an error replied by Redis
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respConn talks RESP2 on a connection. Unlike connections of redis.Client, its client ID can be known before it
+// subscribes, which is needed to redirect tracking to it
+type respConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex // guards writes
+}
+
+func (r *respConn) send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.conn.Write([]byte(b.String()))
+	return err
+}
+
+func (r *respConn) do(args ...string) (interface{}, error) {
+	if err := r.send(args...); err != nil {
+		return nil, err
+	}
+	return r.read()
+}
+
+// read reads a reply. Strings are given as string, integers as int64, arrays as []interface{},
+// and errors replied by Redis as respError
+func (r *respConn) read() (interface{}, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, content := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return content, nil
+	case '-':
+		return nil, respError(content)
+	case ':':
+		return strconv.ParseInt(content, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(content)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(content)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		elements := make([]interface{}, n)
+		for i := range elements {
+			if elements[i], err = r.read(); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	}
+	return nil, fmt.Errorf("malformed reply %q", line)
+}
@@ -0,0 +1,125 @@
+package conn
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordedInvalidations records what it is told, one event per line
+type recordedInvalidations chan string
+
+func (r recordedInvalidations) Connected(mode string, redirectID int64) {
+	r <- fmt.Sprintf("connected %s %d", mode, redirectID)
+}
+
+func (r recordedInvalidations) Disconnected(err error) {
+	r <- "disconnected " + err.Error()
+}
+
+func (r recordedInvalidations) Invalidate(db int, key string) {
+	r <- fmt.Sprintf("invalidate %d %s", db, key)
+}
+
+func (r recordedInvalidations) InvalidateAll() {
+	r <- "invalidate all"
+}
+
+// fakeRedis serves one connection at a time: replies are given by command, then messages are pushed
+// once subscribed, and the connection is closed
+func fakeRedis(t *testing.T, replies map[string]string, messages string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			r := &respConn{conn: c, reader: bufio.NewReader(c)}
+			for {
+				command, err := r.read()
+				if err != nil {
+					break
+				}
+				args := make([]string, 0)
+				for _, arg := range command.([]interface{}) {
+					args = append(args, arg.(string))
+				}
+				name := strings.ToUpper(strings.Join(args, " "))
+				c.Write([]byte(replies[name]))
+				if strings.Contains(name, "SUBSCRIBE") {
+					c.Write([]byte(messages))
+					break
+				}
+			}
+			c.Close()
+		}
+	}()
+	return "redis://" + listener.Addr().String()
+}
+
+func expectEvents(t *testing.T, events recordedInvalidations, expected ...string) {
+	for _, e := range expected {
+		select {
+		case got := <-events:
+			if !strings.HasPrefix(got, e) {
+				t.Error("Expecting", e, "got", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expecting", e, "got nothing")
+		}
+	}
+}
+
+func Test_InvalidationListener_tracking(t *testing.T) {
+	t.Setenv("REDISEEN_REDIS_URI", fakeRedis(t, map[string]string{
+		"CLIENT ID":                      ":7\r\n",
+		"CLIENT TRACKING OFF":            "+OK\r\n",
+		"SUBSCRIBE __REDIS__:INVALIDATE": "*3\r\n$9\r\nsubscribe\r\n$20\r\n__redis__:invalidate\r\n:1\r\n",
+	}, "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*2\r\n$5\r\nkey:1\r\n$5\r\nkey:2\r\n"+
+		"*2\r\n$4\r\npong\r\n$0\r\n\r\n"+
+		"*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*-1\r\n"))
+
+	events := make(recordedInvalidations, 10)
+	l := ListenInvalidations(InvalidationTracking, events)
+	defer l.Close()
+
+	expectEvents(t, events, "connected tracking 7", "invalidate -1 key:1", "invalidate -1 key:2", "invalidate all",
+		"disconnected EOF")
+}
+
+func Test_InvalidationListener_keyspace(t *testing.T) {
+	// CLIENT TRACKING is not supported, so keyspace notifications are used instead
+	t.Setenv("REDISEEN_REDIS_URI", fakeRedis(t, map[string]string{
+		"CLIENT ID":                         ":7\r\n",
+		"CLIENT TRACKING OFF":               "-ERR Unknown subcommand or wrong number of arguments for 'TRACKING'\r\n",
+		"CONFIG GET NOTIFY-KEYSPACE-EVENTS": "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nAKE\r\n",
+		"PSUBSCRIBE __KEYSPACE@*__:*":       "*3\r\n$10\r\npsubscribe\r\n$16\r\n__keyspace@*__:*\r\n:1\r\n",
+	}, "*4\r\n$8\r\npmessage\r\n$16\r\n__keyspace@*__:*\r\n$20\r\n__keyspace@3__:key:1\r\n$3\r\nset\r\n"))
+
+	events := make(recordedInvalidations, 10)
+	l := ListenInvalidations(InvalidationTracking, events)
+	defer l.Close()
+
+	expectEvents(t, events, "connected keyspace 0", "invalidate 3 key:1", "disconnected EOF")
+}
+
+func Test_InvalidationListener_keyspace_disabled(t *testing.T) {
+	t.Setenv("REDISEEN_REDIS_URI", fakeRedis(t, map[string]string{
+		"CONFIG GET NOTIFY-KEYSPACE-EVENTS": "*2\r\n$22\r\nnotify-keyspace-events\r\n$0\r\n\r\n",
+	}, ""))
+
+	events := make(recordedInvalidations, 10)
+	l := ListenInvalidations(InvalidationKeyspace, events)
+	defer l.Close()
+
+	expectEvents(t, events, "disconnected keyspace notifications are not enabled")
+}
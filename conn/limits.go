@@ -0,0 +1,273 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/xd-deng/rediseen/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Limits reported in types.LimitErrorType
+const (
+	LimitBytes        = "bytes"
+	LimitElements     = "elements"
+	LimitStringLength = "string_length"
+)
+
+// SizeLimits are the maximums of values read whole. 0 means no maximum
+type SizeLimits struct {
+	MaxBytes        int64 // as given by MEMORY USAGE (or by the length of strings, if it is not available)
+	MaxElements     int64 // of lists, sets, hashes and sorted sets
+	MaxStringLength int64
+}
+
+// Enabled tells if any maximum is set
+func (l SizeLimits) Enabled() bool {
+	return l.MaxBytes > 0 || l.MaxElements > 0 || l.MaxStringLength > 0
+}
+
+// ValueSize is how large a value is
+type ValueSize struct {
+	Type     string
+	Elements int64 // of lists, sets, hashes and sorted sets
+	Length   int64 // of strings
+	Bytes    int64 // -1 if not known
+}
+
+// sizeCommand queues (or sends, unless c is a pipeline) the command giving the number of elements of a
+// collection (LLEN, SCARD, HLEN or ZCARD), or the length of a string (STRLEN). It returns nil for other types
+func sizeCommand(ctx context.Context, c redis.Cmdable, keyType string, key string) *redis.IntCmd {
+	switch keyType {
+	case "string":
+		return c.StrLen(ctx, key)
+	case "list":
+		return c.LLen(ctx, key)
+	case "set":
+		return c.SCard(ctx, key)
+	case "hash":
+		return c.HLen(ctx, key)
+	case "zset":
+		return c.ZCard(ctx, key)
+	case "stream":
+		return c.XLen(ctx, key)
+	default:
+		return nil
+	}
+}
+
+// sizeOf gives the size from the commands queued by sizeCommand and MEMORY USAGE (memory may be nil)
+func sizeOf(keyType string, size *redis.IntCmd, memory *redis.IntCmd) (ValueSize, error) {
+	s := ValueSize{Type: keyType, Bytes: -1}
+	if size == nil {
+		return s, nil
+	}
+	n, err := size.Result()
+	if err != nil {
+		return s, err
+	}
+	if keyType == "string" {
+		s.Length, s.Bytes = n, n
+	} else {
+		s.Elements = n
+	}
+	// MEMORY USAGE may be renamed or not allowed (or not supported, like in some Redis-compatible stores),
+	// in which case only the length of strings is known
+	if memory != nil && memory.Err() == nil {
+		s.Bytes = memory.Val()
+	}
+	return s, nil
+}
+
+// Size gives the size of the key of the type given, in one round trip. MEMORY USAGE is only sent if withMemory is true
+func (client *ExtendedClient) Size(ctx context.Context, key string, keyType string, withMemory bool) (ValueSize, error) {
+	ctx, span := client.startSpan(ctx, "Size")
+	defer span.End()
+
+	pipe := client.RedisClient.Pipeline()
+	size := sizeCommand(ctx, pipe, keyType, key)
+	var memory *redis.IntCmd
+	if withMemory && size != nil {
+		memory = pipe.MemoryUsage(ctx, key)
+	}
+	pipe.Exec(ctx)
+
+	s, err := sizeOf(keyType, size, memory)
+	span.SetAttributes(attribute.String("db.operation.name", "PIPELINE"), attribute.String("rediseen.key_type", keyType))
+	setSpanError(span, err)
+	return s, err
+}
+
+// Check returns the error to respond if the value exceeds any maximum, or nil. The paging suggested is the first page,
+// with a count small enough for pages to fit in the maximums
+func (l SizeLimits) Check(s ValueSize) *types.LimitErrorType {
+	var limit, unit string
+	var maximum, actual int64
+	switch {
+	case s.Type == "string" && l.MaxStringLength > 0 && s.Length > l.MaxStringLength:
+		limit, unit, maximum, actual = LimitStringLength, "bytes long", l.MaxStringLength, s.Length
+	case s.Type != "string" && l.MaxElements > 0 && s.Elements > l.MaxElements:
+		limit, unit, maximum, actual = LimitElements, "elements", l.MaxElements, s.Elements
+	case l.MaxBytes > 0 && s.Bytes > l.MaxBytes:
+		limit, unit, maximum, actual = LimitBytes, "bytes", l.MaxBytes, s.Bytes
+	default:
+		return nil
+	}
+
+	count := l.MaxElements
+	if s.Type == "string" {
+		count = l.MaxStringLength
+	}
+	if limit == LimitBytes {
+		// as many elements (or bytes of a string) as fit in MaxBytes, if they are alike
+		fit := s.Length
+		if s.Type != "string" {
+			fit = s.Elements
+		}
+		fit = fit * l.MaxBytes / s.Bytes
+		if count <= 0 || fit < count {
+			count = fit
+		}
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	return &types.LimitErrorType{
+		Error: fmt.Sprintf("Value is too large (%d %s, while the maximum is %d). "+
+			"Read it page by page with query parameters cursor and count", actual, unit, maximum),
+		Limit:   limit,
+		Maximum: maximum,
+		Size:    actual,
+		Paging:  types.PagingType{Cursor: "0", Count: count},
+	}
+}
+
+// ErrInvalidCursor is returned by ReadPage if the cursor is not one given by a previous page (or 0)
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ReadPage reads up to count elements of a list, set, hash, sorted set or stream, or up to count bytes of a string,
+// from the cursor given ("0" for the first page), and calls emit with each of them (like ScanValue), or with the
+// substring once. next is the cursor of the next page, or "0" if there is none. Cursors of lists, sorted sets and
+// strings are offsets, those of streams are the IDs of the last entries given, while those of sets and hashes are
+// the cursors of SSCAN and HSCAN, with which pages may hold a few more elements than count
+func (client *ExtendedClient) ReadPage(ctx context.Context, key string, keyType string, cursor string, count int64,
+	emit func(element interface{}) error) (next string, err error) {
+	ctx, span := client.startSpan(ctx, "ReadPage")
+	var command string
+	var entries int
+	defer func() {
+		endSpan(span, command, entries, err)
+	}()
+
+	if keyType == "stream" {
+		command = "XRANGE"
+		return client.readStreamPage(ctx, key, cursor, count, func(entry types.StreamEntryType) error {
+			entries++
+			return emit(entry)
+		})
+	}
+
+	offset, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return "0", ErrInvalidCursor
+	}
+	start := int64(offset)
+	switch keyType {
+	case "string":
+		command = "GETRANGE"
+		pipe := client.RedisClient.Pipeline()
+		value := pipe.GetRange(ctx, key, start, start+count-1)
+		length := pipe.StrLen(ctx, key)
+		if _, err = pipe.Exec(ctx); err != nil {
+			return "0", err
+		}
+		if err = emit(value.Val()); err != nil {
+			return "0", err
+		}
+		entries = 1
+		if start+count < length.Val() {
+			return strconv.FormatInt(start+count, 10), nil
+		}
+		return "0", nil
+	case "list", "zset":
+		var read int64
+		for read < count {
+			chunk := count - read
+			if chunk > scanChunkSize {
+				chunk = scanChunkSize
+			}
+			var got int64
+			if keyType == "list" {
+				command = "LRANGE"
+				var values []string
+				values, err = client.RedisClient.LRange(ctx, key, start+read, start+read+chunk-1).Result()
+				for i := 0; err == nil && i < len(values); i++ {
+					err = emit(values[i])
+				}
+				got = int64(len(values))
+			} else {
+				command = "ZRANGE"
+				var values []redis.Z
+				values, err = client.RedisClient.ZRangeWithScores(ctx, key, start+read, start+read+chunk-1).Result()
+				for i := 0; err == nil && i < len(values); i++ {
+					member, _ := values[i].Member.(string)
+					err = emit(types.SortedSetEntryType{Member: member, Score: values[i].Score})
+				}
+				got = int64(len(values))
+			}
+			if err != nil {
+				return "0", err
+			}
+			read += got
+			entries += int(got)
+			if got < chunk {
+				return "0", nil
+			}
+		}
+		// so that the last page is known as such, even if it is full
+		if size, err := sizeCommand(ctx, client.RedisClient, keyType, key).Result(); err == nil && start+read >= size {
+			return "0", nil
+		}
+		return strconv.FormatInt(start+read, 10), nil
+	case "set", "hash":
+		var read int64
+		for {
+			chunk := count - read
+			if chunk > scanChunkSize {
+				chunk = scanChunkSize
+			}
+			var values []string
+			if keyType == "set" {
+				command = "SSCAN"
+				values, offset, err = client.RedisClient.SScan(ctx, key, offset, "", chunk).Result()
+			} else {
+				command = "HSCAN"
+				values, offset, err = client.RedisClient.HScan(ctx, key, offset, "", chunk).Result()
+			}
+			if err != nil {
+				return "0", err
+			}
+			for i := 0; i < len(values); i++ {
+				var element interface{} = values[i]
+				if keyType == "hash" && i+1 < len(values) {
+					element = types.HashEntryType{Field: values[i], Value: values[i+1]}
+					i++
+				}
+				if err = emit(element); err != nil {
+					return "0", err
+				}
+				read++
+				entries++
+			}
+			if offset == 0 || read >= count {
+				return strconv.FormatUint(offset, 10), nil
+			}
+		}
+	default:
+		return "0", errors.New(strNotImplemented)
+	}
+}
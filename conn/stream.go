@@ -0,0 +1,112 @@
+package conn
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// streamEntries converts entries given by XRANGE. Values of fields are always strings in Redis
+func streamEntries(messages []redis.XMessage) []types.StreamEntryType {
+	entries := make([]types.StreamEntryType, 0, len(messages))
+	for _, m := range messages {
+		fields := make(map[string]string, len(m.Values))
+		for field, value := range m.Values {
+			fields[field] = fmt.Sprint(value)
+		}
+		entries = append(entries, types.StreamEntryType{ID: m.ID, Fields: fields})
+	}
+	return entries
+}
+
+// nextStreamID gives the smallest ID after the ID given (like `1526919030474-55`), so that XRANGE can continue
+// from it without giving the entry again (exclusive ranges need Redis 6.2)
+func nextStreamID(id string) (string, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid stream ID %s", id)
+	}
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream ID %s", id)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream ID %s", id)
+	}
+	if seq == math.MaxUint64 {
+		return strconv.FormatUint(ms+1, 10) + "-0", nil
+	}
+	return parts[0] + "-" + strconv.FormatUint(seq+1, 10), nil
+}
+
+// scanStream calls emit with the entries of the stream, from the oldest on, reading them chunk by chunk (with XRANGE)
+func (client *ExtendedClient) scanStream(ctx context.Context, key string, emit func(entry types.StreamEntryType) error) error {
+	start := "-"
+	for {
+		messages, err := client.RedisClient.XRangeN(ctx, key, start, "+", scanChunkSize).Result()
+		if err != nil {
+			return err
+		}
+		for _, entry := range streamEntries(messages) {
+			if err = emit(entry); err != nil {
+				return err
+			}
+		}
+		if len(messages) < scanChunkSize {
+			return nil
+		}
+		if start, err = nextStreamID(messages[len(messages)-1].ID); err != nil {
+			return err
+		}
+	}
+}
+
+// readStreamPage calls emit with up to count entries of the stream, after the entry of the ID given as cursor ("0" for
+// the first page), and gives the ID of the last one as the cursor of the next page, or "0" if there is none. So pages
+// are read from where the previous page ended, instead of skipping the entries before them
+func (client *ExtendedClient) readStreamPage(ctx context.Context, key string, cursor string, count int64,
+	emit func(entry types.StreamEntryType) error) (next string, err error) {
+	start := "-"
+	if cursor != "0" {
+		if start, err = nextStreamID(cursor); err != nil {
+			return "0", ErrInvalidCursor
+		}
+	}
+
+	var read int64
+	var last string
+	for read < count {
+		chunk := count - read
+		if chunk > scanChunkSize {
+			chunk = scanChunkSize
+		}
+		messages, err := client.RedisClient.XRangeN(ctx, key, start, "+", chunk).Result()
+		if err != nil {
+			return "0", err
+		}
+		for _, entry := range streamEntries(messages) {
+			if err = emit(entry); err != nil {
+				return "0", err
+			}
+			last = entry.ID
+		}
+		read += int64(len(messages))
+		if int64(len(messages)) < chunk {
+			return "0", nil
+		}
+		if start, err = nextStreamID(last); err != nil {
+			return "0", err
+		}
+	}
+	// so that the last page is known as such, even if it is full
+	if more, err := client.RedisClient.XRangeN(ctx, key, start, "+", 1).Result(); err == nil && len(more) == 0 {
+		return "0", nil
+	}
+	return last, nil
+}
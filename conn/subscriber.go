@@ -0,0 +1,148 @@
+package conn
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const subscriberDialTimeout = 5 * time.Second
+const subscriberPingInterval = 15 * time.Second
+const subscriberMaxBackoff = 30 * time.Second
+
+// subscriber is a dedicated connection to Redis (given by REDISEEN_REDIS_URI) which subscribes to channels,
+// reconnecting whenever it is lost
+type subscriber struct {
+	mu   sync.Mutex
+	conn net.Conn
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newSubscriber() subscriber {
+	return subscriber{stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Close stops listening
+func (s *subscriber) Close() {
+	s.mu.Lock()
+	close(s.stop)
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+func (s *subscriber) isClosed() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// run calls listen until closed, backing off between attempts. disconnected is called whenever listen returns
+func (s *subscriber) run(listen func() (connected bool, err error), disconnected func(err error)) {
+	defer close(s.done)
+
+	backoff := time.Second
+	for {
+		connected, err := listen()
+		if s.isClosed() {
+			return
+		}
+		disconnected(err)
+		if connected {
+			backoff = time.Second
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+		if backoff *= 2; backoff > subscriberMaxBackoff {
+			backoff = subscriberMaxBackoff
+		}
+	}
+}
+
+// dial connects the same way as Init does, and authenticates. r is nil if it fails, or if the subscriber is closed.
+// Otherwise its connection must be closed by the caller
+func (s *subscriber) dial() (r *respConn, err error) {
+	parsedUri, _ := redis.ParseURL(os.Getenv("REDISEEN_REDIS_URI"))
+	c, err := net.DialTimeout("tcp", parsedUri.Addr, subscriberDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		c.Close()
+		return nil, nil
+	}
+	s.conn = c
+	s.mu.Unlock()
+
+	r = &respConn{conn: c, reader: bufio.NewReader(c)}
+	c.SetDeadline(time.Now().Add(subscriberDialTimeout))
+
+	if parsedUri.Password != "" {
+		if _, err = r.do("AUTH", parsedUri.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// receive calls handle with every message, pinging Redis meanwhile, until the connection is lost
+func (s *subscriber) receive(r *respConn, handle func(reply interface{})) error {
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go func() {
+		ticker := time.NewTicker(subscriberPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.send("PING")
+			case <-stopPing:
+				return
+			}
+		}
+	}()
+
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(3 * subscriberPingInterval))
+		reply, err := r.read()
+		if err != nil {
+			return err
+		}
+		handle(reply)
+	}
+}
+
+// parseKeyspaceChannel parses channels of keyspace notifications, like __keyspace@<db>__:<key>
+func parseKeyspaceChannel(channel string) (db int, key string, ok bool) {
+	rest := strings.TrimPrefix(channel, "__keyspace@")
+	i := strings.Index(rest, "__:")
+	if rest == channel || i < 0 {
+		return 0, "", false
+	}
+	db, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return 0, "", false
+	}
+	return db, rest[i+3:], true
+}
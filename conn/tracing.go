@@ -0,0 +1,87 @@
+package conn
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/xd-deng/rediseen/conn"
+
+// tracingHook creates a client span for every command (or pipeline) sent to Redis.
+// Arguments of commands are not recorded, since they may contain keys and values
+type tracingHook struct {
+	db int
+}
+
+func (h tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	command := strings.ToUpper(cmd.Name())
+	ctx, _ = otel.Tracer(tracerName).Start(ctx, "redis "+command, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.namespace", strconv.Itoa(h.db)),
+			attribute.String("db.operation.name", command),
+		))
+	return ctx, nil
+}
+
+func (h tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	span := trace.SpanFromContext(ctx)
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		setSpanError(span, err)
+	}
+	span.End()
+	return nil
+}
+
+func (h tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = otel.Tracer(tracerName).Start(ctx, "redis PIPELINE", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.namespace", strconv.Itoa(h.db)),
+			attribute.String("db.operation.name", "PIPELINE"),
+			attribute.Int("db.operation.batch.size", len(cmds)),
+		))
+	return ctx, nil
+}
+
+func (h tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	span := trace.SpanFromContext(ctx)
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			setSpanError(span, err)
+			break
+		}
+	}
+	span.End()
+	return nil
+}
+
+// startSpan starts the span of an ExtendedClient call, which is the parent of the spans of Redis commands it sends
+func (client *ExtendedClient) startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "conn."+operation, trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.namespace", strconv.Itoa(client.RedisClient.Options().DB)),
+		))
+}
+
+// endSpan records the outcome of an ExtendedClient call, and ends its span
+func endSpan(span trace.Span, command string, entries int, err error) {
+	span.SetAttributes(attribute.String("db.operation.name", command), attribute.Int("rediseen.result.entries", entries))
+	setSpanError(span, err)
+	span.End()
+}
+
+// setSpanError marks the span as failed, if err is not nil
+func setSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
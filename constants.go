@@ -12,9 +12,54 @@ const strHelpDoc = "\nConfiguration Items (via environment variables):\n" +
 	"representing the name pattern of keys that you intend to expose\n" +
 	"- REDISEEN_KEY_PATTERN_EXPOSE_ALL: If you intend to expose *all* your keys, " +
 	"set `REDISEEN_KEY_PATTERN_EXPOSE_ALL` to `true`\n" +
+	"- REDISEEN_KEY_PATTERN_DENIED: (Optional) Regular expression pattern of keys to hide, taking precedence over REDISEEN_KEY_PATTERN_EXPOSED\n" +
+	"- REDISEEN_DB_KEY_PATTERN_EXPOSED: (Optional) Key patterns exposed in specific DBs, e.g. `0=^session:;3-5=^flag:`\n" +
+	"- REDISEEN_DB_KEY_PATTERN_DENIED: (Optional) Key patterns denied in specific DBs, e.g. `0=:internal$`\n" +
+	"- REDISEEN_REDACTION_RULES: (Optional) Rules masking or dropping fields of values, e.g. `mask ^user: ^password$;drop ^session:`\n" +
 	"- REDISEEN_API_KEY: (Optional) API Key Authentication is only enabled when REDISEEN_API_KEY is set" +
 	" and is not ''. Once it is set, client must add the API key into HTTP header as X-API-KEY" +
-	" in order to access the API"
+	" in order to access the API\n" +
+	"- REDISEEN_IP_ALLOWED: (Optional) IP addresses or CIDRs allowed to access, e.g. `10.0.0.0/8;192.168.1.7`\n" +
+	"- REDISEEN_IP_DENIED: (Optional) IP addresses or CIDRs denied, taking precedence over REDISEEN_IP_ALLOWED\n" +
+	"- REDISEEN_IP_AUTH_MODE: (Optional) `both` (default) or `either`, if allowed IP addresses need no API key\n" +
+	"- REDISEEN_TRUSTED_PROXIES: (Optional) IP addresses or CIDRs of proxies whose X-Forwarded-For is trusted\n" +
+	"- REDISEEN_METRICS_TARGETS: (Optional) Semicolon-separated Redis URIs (with credentials) which are allowed" +
+	" to be scraped via /metrics?target=<host:port>\n" +
+	"- REDISEEN_KEY_METRICS_CONFIG: (Optional) Path to a JSON file, mapping values of keys to Prometheus metrics in /metrics\n" +
+	"- REDISEEN_DIAGNOSTICS_ENABLED: (Optional) Diagnostic endpoint(s) to enable, e.g. `slowlog`, or" +
+	" `slowlog;latency;clients;memory;config`. All of them are disabled by default\n" +
+	"- REDISEEN_CONFIG_PARAMS_EXPOSED: (Optional) Configuration parameter(s) exposed via /config, e.g. `maxmemory;maxmemory-policy`\n" +
+	"- REDISEEN_LOG_FORMAT: (Optional) Format of logs, `text` (default), `json` or `logfmt`\n" +
+	"- REDISEEN_LOG_LEVEL: (Optional) Minimum level of logs, `debug`, `info` (default), `warn` or `error`\n" +
+	"- REDISEEN_AUDIT_SINK: (Optional) Where to write the audit log of data accesses, `file` or `syslog`\n" +
+	"- REDISEEN_AUDIT_FILE: (Optional) Path of the audit log file, compulsory if REDISEEN_AUDIT_SINK is `file`\n" +
+	"- REDISEEN_AUDIT_FILE_MAX_SIZE_MB: (Optional) Size to rotate the audit log file at. Default is 100\n" +
+	"- REDISEEN_AUDIT_FILE_MAX_BACKUPS: (Optional) Number of rotated audit log files to keep. Default is 10\n" +
+	"- REDISEEN_AUDIT_SYSLOG_ADDRESS: (Optional) Syslog server, like `udp://<host>:<port>`. Local syslog is used if not set\n" +
+	"- REDISEEN_AUDIT_HMAC_KEY: (Optional) Secret key to chain audit records with HMAC-SHA256 instead of SHA-256\n" +
+	"- REDISEEN_TRACING_OTLP_ENDPOINT: (Optional) Base URL of the OpenTelemetry collector to export traces to, e.g. `http://localhost:4318`\n" +
+	"- REDISEEN_TRACING_OTLP_HEADERS: (Optional) Headers of export requests, e.g. `Authorization=Bearer xxx;X-Tenant=a`\n" +
+	"- REDISEEN_TRACING_SAMPLE_RATIO: (Optional) Fraction of traces sampled, between 0 and 1. Default is 1\n" +
+	"- REDISEEN_COMPRESSION: (Optional) Content codings offered via Accept-Encoding, e.g. `zstd;gzip` (default). `none` disables compression\n" +
+	"- REDISEEN_CACHE_MAX_AGE: (Optional) max-age (in seconds) of responses for keys without TTL. Default is 0 (no-cache)\n" +
+	"- REDISEEN_VALUE_CACHE_SIZE_MB: (Optional) Size (in MB) of the in-memory cache of values. Default is 0 (disabled)\n" +
+	"- REDISEEN_VALUE_CACHE_INVALIDATION: (Optional) How cached values are evicted when changed: `tracking` (default) or `keyspace`\n" +
+	"- REDISEEN_RATE_LIMITS: (Optional) Rate limits per client IP or API key, e.g. `ip=20/s;ip:list=1/s,5`\n" +
+	"- REDISEEN_MAX_CONCURRENT_REQUESTS: (Optional) Maximum number of requests talking to Redis at the same time. Default is 0 (no limit)\n" +
+	"- REDISEEN_RATE_LIMIT_REDIS_URI: (Optional) Redis URI where rate limits are kept, so that replicas share them\n" +
+	"- REDISEEN_MAX_RESPONSE_BYTES: (Optional) Maximum memory (MEMORY USAGE) of values read whole. Default is 0 (no limit)\n" +
+	"- REDISEEN_MAX_ELEMENTS: (Optional) Maximum number of elements of collections read whole. Default is 0 (no limit)\n" +
+	"- REDISEEN_MAX_STRING_LENGTH: (Optional) Maximum length of strings read whole. Default is 0 (no limit)\n" +
+	"- REDISEEN_UI_ENABLED: (Optional) Set to `true` to serve the web UI for browsing exposed keys at /ui\n" +
+	"- REDISEEN_EVENTS_ENABLED: (Optional) Set to `true` to stream keyspace events of exposed keys at /<db>/_events\n" +
+	"- REDISEEN_EVENTS_MAX_SUBSCRIBERS: (Optional) Maximum number of clients subscribed to keyspace events. Default is 100\n" +
+	"- REDISEEN_CHANNEL_PATTERN_EXPOSED: (Optional) Regular expression of Pub/Sub channels which can be subscribed to via WebSocket at /_pubsub\n" +
+	"- REDISEEN_CHANNEL_PATTERN_PUBLISHABLE: (Optional) Regular expression of Pub/Sub channels which can be published to (also via POST /_publish/<channel>). Default is none\n" +
+	"- REDISEEN_PUBSUB_MAX_CONNECTIONS: (Optional) Maximum number of WebSockets connected to /_pubsub. Default is 100\n" +
+	"- REDISEEN_PUBSUB_MAX_PENDING: (Optional) Maximum number of messages queued per WebSocket. Default is 256\n" +
+	"- REDISEEN_CORS_ALLOWED_ORIGINS: (Optional) Origins allowed to call the API from browsers, e.g. `https://dashboard.example.com` or `*`\n" +
+	"- REDISEEN_CORS_ALLOWED_HEADERS: (Optional) Request headers allowed for the origins. `X-API-KEY` is always allowed\n" +
+	"- REDISEEN_CORS_ALLOW_CREDENTIALS: (Optional) Set to `true` to let browsers send credentials to the origins"
 
 const strLogo = " _____            _  _   _____\n" +
 	"|  __ \\          | |(_) / ____|\n" +
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xd-deng/rediseen/types"
+)
+
+// corsMaxAge is how long (in seconds) browsers may cache the outcome of preflight requests
+const corsMaxAge = 600
+
+// corsDefaultHeaders are the request headers allowed by default. X-API-KEY is always allowed
+var corsDefaultHeaders = []string{"X-API-KEY", "Accept", "Content-Type", "If-None-Match", requestIDHeader}
+
+// corsExposedHeaders are the response headers (other than CORS-safelisted ones) which browsers let scripts read
+var corsExposedHeaders = []string{"ETag", "Retry-After", requestIDHeader, nextCursorHeader}
+
+// securityHeaders are set on every response. Responses are data (not documents), so nothing may be framed,
+// sniffed or loaded by them
+var securityHeaders = map[string]string{
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+	"Referrer-Policy":         "no-referrer",
+}
+
+// corsConfig is Cross-Origin Resource Sharing, allowing web pages of other origins to call the API from browsers
+type corsConfig struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	headers     []string
+	credentials bool
+}
+
+// configureCORS applies REDISEEN_CORS_ALLOWED_ORIGINS, REDISEEN_CORS_ALLOWED_HEADERS and
+// REDISEEN_CORS_ALLOW_CREDENTIALS. CORS is disabled unless origins are given
+func (c *service) configureCORS() error {
+	c.cors = nil
+	configOrigins := os.Getenv("REDISEEN_CORS_ALLOWED_ORIGINS")
+	configHeaders := os.Getenv("REDISEEN_CORS_ALLOWED_HEADERS")
+	configCredentials := os.Getenv("REDISEEN_CORS_ALLOW_CREDENTIALS")
+	if configOrigins == "" {
+		if configHeaders != "" || configCredentials != "" {
+			return errors.New("REDISEEN_CORS_ALLOWED_ORIGINS is not configured")
+		}
+		return nil
+	}
+
+	cors := &corsConfig{origins: map[string]bool{}, headers: corsDefaultHeaders}
+	for _, origin := range strings.Split(configOrigins, ";") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		switch {
+		case origin == "*":
+			cors.anyOrigin = true
+		case strings.HasPrefix(origin, "http://") || strings.HasPrefix(origin, "https://"):
+			cors.origins[strings.ToLower(origin)] = true
+		default:
+			return errors.New("REDISEEN_CORS_ALLOWED_ORIGINS should be `*`, or origins like `https://dashboard.example.com`, semicolon-separated")
+		}
+	}
+
+	if configHeaders != "" {
+		cors.headers = []string{"X-API-KEY"}
+		for _, header := range strings.Split(configHeaders, ";") {
+			header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+			if header != "" && !strings.EqualFold(header, "X-API-KEY") {
+				cors.headers = append(cors.headers, header)
+			}
+		}
+	}
+
+	switch configCredentials {
+	case "", "false":
+	case "true":
+		if cors.anyOrigin {
+			return errors.New("REDISEEN_CORS_ALLOW_CREDENTIALS can not be `true` if any origin (`*`) is allowed")
+		}
+		cors.credentials = true
+	default:
+		return errors.New("REDISEEN_CORS_ALLOW_CREDENTIALS should be `true` or `false`")
+	}
+
+	c.cors = cors
+	logger.Info("Browsers are allowed to call the API from origin(s) `" + configOrigins + "` (CORS)")
+	return nil
+}
+
+// allows tells if the origin given is allowed
+func (cors *corsConfig) allows(origin string) bool {
+	return cors.anyOrigin || cors.origins[strings.ToLower(origin)]
+}
+
+// isPreflight tells if the request is a CORS preflight request
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// writeSecurityHeaders sets securityHeaders, and CORS headers if the request comes from an allowed origin.
+// If the request is a preflight one, it is answered (before authentication, since browsers never send
+// credentials with it), and done is true
+func (c *service) writeSecurityHeaders(res *responseRecorder, req *http.Request) (done bool) {
+	for name, value := range securityHeaders {
+		res.Header().Set(name, value)
+	}
+	if c.cors == nil {
+		return false
+	}
+
+	origin := req.Header.Get("Origin")
+	res.Header().Add("Vary", "Origin")
+	allowed := origin != "" && c.cors.allows(origin)
+	if allowed {
+		if c.cors.anyOrigin {
+			res.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			res.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.cors.credentials {
+			res.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if !isPreflight(req) {
+		if allowed {
+			res.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	res.endpoint = endpointPreflight
+	if !allowed {
+		res.WriteHeader(http.StatusForbidden)
+		js, _ := json.Marshal(types.ErrorType{Error: "Origin is not allowed"})
+		res.Write(js)
+		res.log.Info("Preflight request from origin not allowed", "origin", origin)
+		return true
+	}
+	res.Header().Add("Vary", "Access-Control-Request-Method")
+	res.Header().Add("Vary", "Access-Control-Request-Headers")
+	res.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	res.Header().Set("Access-Control-Allow-Headers", strings.Join(c.cors.headers, ", "))
+	res.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+	res.WriteHeader(http.StatusNoContent)
+	return true
+}
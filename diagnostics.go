@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/metrics"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// Diagnostic endpoints. Each of them needs to be enabled explicitly via REDISEEN_DIAGNOSTICS_ENABLED
+const (
+	diagnosticSlowlog = "slowlog"
+	diagnosticLatency = "latency"
+	diagnosticClients = "clients"
+	diagnosticMemory  = "memory"
+	diagnosticConfig  = "config"
+)
+
+var diagnosticEndpoints = map[string]bool{
+	diagnosticSlowlog: true,
+	diagnosticLatency: true,
+	diagnosticClients: true,
+	diagnosticMemory:  true,
+	diagnosticConfig:  true,
+}
+
+const defaultSlowlogCount = 10
+
+// parseDiagnosticsEnabled parses strings like "slowlog;latency;config" into a map for later querying
+func parseDiagnosticsEnabled(configDiagnosticsEnabled string) (map[string]bool, error) {
+	result := make(map[string]bool)
+	if configDiagnosticsEnabled == "" {
+		return result, nil
+	}
+
+	for _, d := range strings.Split(configDiagnosticsEnabled, ";") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if !diagnosticEndpoints[d] {
+			return nil, fmt.Errorf("unknown diagnostic endpoint `%s`", d)
+		}
+		result[d] = true
+	}
+	return result, nil
+}
+
+// parseConfigParamsExposed parses strings like "maxmemory;maxmemory-policy" into a list of parameters.
+// Glob-style patterns are not allowed, to avoid exposing parameters by accident
+func parseConfigParamsExposed(configParamsExposed string) ([]string, error) {
+	var result []string
+	for _, p := range strings.Split(configParamsExposed, ";") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if strings.ContainsAny(p, "*?[") {
+			return nil, fmt.Errorf("glob-style pattern `%s` is not allowed", p)
+		}
+		result = append(result, p)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no parameter is given")
+	}
+	return result, nil
+}
+
+// serveDiagnostics handles requests to /slowlog, /latency, /latency/<event>, /clients, /memory and /config
+func (c *service) serveDiagnostics(res *responseRecorder, req *http.Request, endpoint string, argument string) {
+	var js []byte
+
+	if !c.diagnosticsEnabled[endpoint] {
+		res.WriteHeader(http.StatusForbidden)
+		js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("Endpoint /%s is not enabled", endpoint)})
+		res.Write(js)
+		return
+	}
+
+	if argument != "" && endpoint != diagnosticLatency {
+		res.WriteHeader(http.StatusBadRequest)
+		js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("Usage: /%s", endpoint)})
+		res.Write(js)
+		return
+	}
+
+	var client conn.ExtendedClient
+	client.Init(0)
+	defer client.RedisClient.Close()
+
+	ctx := req.Context()
+	var result interface{}
+	var err error
+
+	switch endpoint {
+	case diagnosticSlowlog:
+		count := defaultSlowlogCount
+		if rawCount := req.URL.Query().Get("count"); rawCount != "" {
+			count, err = strconv.Atoi(rawCount)
+			if err != nil || count < 0 {
+				res.WriteHeader(http.StatusBadRequest)
+				js, _ = json.Marshal(types.ErrorType{Error: "Provide a non-negative integer for count"})
+				res.Write(js)
+				return
+			}
+		}
+		result, err = client.SlowLog(ctx, count)
+	case diagnosticLatency:
+		if argument == "" {
+			result, err = client.LatencyLatest(ctx)
+		} else {
+			result, err = client.LatencyHistory(ctx, argument)
+		}
+	case diagnosticClients:
+		result, err = client.ClientList(ctx)
+	case diagnosticMemory:
+		result, err = client.MemoryStats(ctx)
+	case diagnosticConfig:
+		result, err = client.ConfigGet(ctx, c.configParamsExposed)
+	}
+
+	if err != nil {
+		redisErrorsTotal.Inc(res.endpoint)
+		res.WriteHeader(http.StatusInternalServerError)
+		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return
+	}
+
+	js, _ = json.Marshal(result)
+	res.Write(js)
+}
+
+// collectDiagnosticMetrics writes metrics derived from the enabled diagnostic endpoints.
+// Failures are ignored, since they are already reflected by redis_up or by the JSON endpoints
+func (c *service) collectDiagnosticMetrics(ctx context.Context, w io.Writer, client *conn.ExtendedClient) {
+	if c.diagnosticsEnabled[diagnosticSlowlog] {
+		slowlog, err := client.SlowLog(ctx, 1)
+		if err == nil {
+			metrics.WriteHeader(w, "redis_slowlog_length", "Number of entries in the slow log.", "gauge")
+			metrics.WriteSample(w, "redis_slowlog_length", nil, nil, float64(slowlog.Length))
+			if len(slowlog.Entries) > 0 {
+				metrics.WriteHeader(w, "redis_slowlog_last_id", "ID of the latest entry in the slow log.", "gauge")
+				metrics.WriteSample(w, "redis_slowlog_last_id", nil, nil, float64(slowlog.Entries[0].ID))
+			}
+		}
+	}
+
+	if c.diagnosticsEnabled[diagnosticLatency] {
+		events, err := client.LatencyLatest(ctx)
+		if err == nil && len(events) > 0 {
+			metrics.WriteHeader(w, "redis_latency_latest_seconds", "Latest latency spike per event.", "gauge")
+			for _, e := range events {
+				metrics.WriteSample(w, "redis_latency_latest_seconds", []string{"event"}, []string{e.Event}, float64(e.LatestMilliseconds)/1000)
+			}
+			metrics.WriteHeader(w, "redis_latency_max_seconds", "Maximum latency spike per event.", "gauge")
+			for _, e := range events {
+				metrics.WriteSample(w, "redis_latency_max_seconds", []string{"event"}, []string{e.Event}, float64(e.MaxMilliseconds)/1000)
+			}
+		}
+	}
+
+	if c.diagnosticsEnabled[diagnosticMemory] {
+		stats, err := client.MemoryStats(ctx)
+		if err == nil {
+			writeNumericGauges(w, "redis_memory_stats_", "Redis MEMORY STATS field %s.", stats)
+		}
+	}
+
+	if c.diagnosticsEnabled[diagnosticConfig] {
+		params, err := client.ConfigGet(ctx, c.configParamsExposed)
+		if err == nil {
+			values := make(map[string]interface{})
+			for k, v := range params {
+				values[k] = v
+			}
+			writeNumericGauges(w, "redis_config_", "Redis configuration parameter %s.", values)
+		}
+	}
+}
+
+// writeNumericGauges writes one gauge per numeric value in the map (in the order of names)
+func writeNumericGauges(w io.Writer, prefix string, helpTemplate string, values map[string]interface{}) {
+	var names []string
+	for n := range values {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		var v float64
+		switch value := values[n].(type) {
+		case int64:
+			v = float64(value)
+		case float64:
+			v = value
+		case string:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			v = f
+		default:
+			continue
+		}
+		name := prefix + metrics.SanitizeName(n)
+		metrics.WriteHeader(w, name, fmt.Sprintf(helpTemplate, n), "gauge")
+		metrics.WriteSample(w, name, nil, nil, v)
+	}
+}
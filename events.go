@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/types"
+)
+
+const (
+	defaultMaxEventSubscribers = 100
+	// eventHistorySize is the number of latest events kept, so that clients reconnecting with Last-Event-ID
+	// are given the events they missed
+	eventHistorySize = 1000
+	// eventBufferSize is the number of events queued per subscriber. Subscribers falling further behind are
+	// disconnected, and can catch up by reconnecting with Last-Event-ID
+	eventBufferSize   = 256
+	eventHeartbeat    = 15 * time.Second
+	eventRetryMillis  = 3000
+	lastEventIDHeader = "Last-Event-ID"
+	// eventReset tells subscribers that events may have been missed, so that they should read keys again
+	eventReset = "reset"
+)
+
+// Events which remove keys, hence whose values are not given
+var removalEvents = map[string]bool{"del": true, "expired": true, "evicted": true}
+
+// configureEvents applies REDISEEN_EVENTS_* environment variables. Keyspace events are not served unless
+// REDISEEN_EVENTS_ENABLED is true
+func (c *service) configureEvents() error {
+	if c.events != nil {
+		c.events.close()
+		c.events = nil
+	}
+
+	maxSubscribers, err := intFromEnv("REDISEEN_EVENTS_MAX_SUBSCRIBERS", defaultMaxEventSubscribers)
+	if err != nil {
+		return err
+	}
+	switch os.Getenv("REDISEEN_EVENTS_ENABLED") {
+	case "", "false":
+		return nil
+	case "true":
+	default:
+		return errors.New("REDISEEN_EVENTS_ENABLED should be `true` or `false`")
+	}
+	if maxSubscribers == 0 {
+		return errors.New("REDISEEN_EVENTS_MAX_SUBSCRIBERS should be positive")
+	}
+
+	c.events = newEventHub(maxSubscribers)
+	c.events.listener = conn.ListenKeyspaceEvents(c.events)
+	logger.Info(fmt.Sprintf("Keyspace events are served at /<db>/_events (up to %d subscribers)", maxSubscribers))
+	return nil
+}
+
+// keyEvent is a keyspace notification, numbered in the order received
+type keyEvent struct {
+	seq   uint64
+	db    int
+	key   string
+	event string
+}
+
+// eventSubscriber is a client of /<db>/_events. events is closed if the client falls behind
+type eventSubscriber struct {
+	events chan keyEvent
+}
+
+// eventHub fans keyspace notifications out to subscribers, and keeps the latest ones for subscribers reconnecting
+type eventHub struct {
+	listener       *conn.KeyspaceListener
+	maxSubscribers int
+	epoch          string // distinguishes IDs of events given before Rediseen restarted, or the hub was reconfigured
+
+	mu          sync.Mutex
+	seq         uint64
+	history     []keyEvent // ring of the latest events, event n at history[(n-1) % eventHistorySize]
+	subscribers map[*eventSubscriber]bool
+}
+
+func newEventHub(maxSubscribers int) *eventHub {
+	return &eventHub{
+		maxSubscribers: maxSubscribers,
+		epoch:          strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers:    map[*eventSubscriber]bool{},
+	}
+}
+
+func (h *eventHub) close() {
+	if h.listener != nil {
+		h.listener.Close()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers {
+		h.drop(s)
+	}
+}
+
+// eventID gives the ID of the event given, for field `id` of server-sent events
+func (h *eventHub) eventID(seq uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// subscribe registers a subscriber, unless there are maxSubscribers already. If lastEventID is given, the events
+// after it are given in missed. reset is true if they are not known any more (or lastEventID is not known)
+func (h *eventHub) subscribe(lastEventID string) (s *eventSubscriber, missed []keyEvent, reset bool, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subscribers) >= h.maxSubscribers {
+		return nil, nil, false, false
+	}
+	if lastEventID != "" {
+		missed, reset = h.since(lastEventID)
+	}
+	s = &eventSubscriber{events: make(chan keyEvent, eventBufferSize)}
+	h.subscribers[s] = true
+	eventSubscribers.Set(float64(len(h.subscribers)))
+	return s, missed, reset, true
+}
+
+// since gives the events after the ID given. Callers must hold h.mu
+func (h *eventHub) since(id string) (events []keyEvent, reset bool) {
+	prefix := h.epoch + "-"
+	seq, err := strconv.ParseUint(strings.TrimPrefix(id, prefix), 10, 64)
+	if !strings.HasPrefix(id, prefix) || err != nil || seq > h.seq {
+		return nil, true
+	}
+	if h.seq-seq > uint64(len(h.history)) {
+		// older than the events kept
+		return nil, true
+	}
+	n := uint64(len(h.history))
+	for i := seq; i < h.seq; i++ {
+		events = append(events, h.history[i%n])
+	}
+	return events, false
+}
+
+func (h *eventHub) unsubscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[s] {
+		h.drop(s)
+	}
+}
+
+// drop removes the subscriber, closing its channel. Callers must hold h.mu
+func (h *eventHub) drop(s *eventSubscriber) {
+	delete(h.subscribers, s)
+	close(s.events)
+	eventSubscribers.Set(float64(len(h.subscribers)))
+}
+
+// publish numbers the event and gives it to all subscribers
+func (h *eventHub) publish(e keyEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e.seq = h.seq
+	if len(h.history) < eventHistorySize {
+		h.history = append(h.history, e)
+	} else {
+		h.history[(e.seq-1)%eventHistorySize] = e
+	}
+	for s := range h.subscribers {
+		select {
+		case s.events <- e:
+		default:
+			h.drop(s)
+			eventSubscribersDroppedTotal.Inc()
+		}
+	}
+}
+
+// Connected implements conn.KeyspaceEvents
+func (h *eventHub) Connected() {
+	logger.Info("Keyspace events are listened to")
+}
+
+// Disconnected implements conn.KeyspaceEvents. Subscribers are told to reset, since events may be missed
+func (h *eventHub) Disconnected(err error) {
+	h.publish(keyEvent{event: eventReset})
+	logger.Warn("Keyspace events can not be listened to, hence may be missed. Details: " + err.Error())
+}
+
+// Event implements conn.KeyspaceEvents
+func (h *eventHub) Event(db int, key string, event string) {
+	h.publish(keyEvent{db: db, key: key, event: event})
+}
+
+// globRegexp compiles a glob-style pattern (like the patterns of SCAN MATCH: `*`, `?`, `[...]` and `\`)
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, errors.New("Provide a pattern with `[` closed by `]` for match")
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + regexp.QuoteMeta(class[1:])
+			} else {
+				class = regexp.QuoteMeta(class)
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile("(?s)" + b.String())
+}
+
+// eventsRequest is what /<db>/_events is asked for, via query parameters match and values
+type eventsRequest struct {
+	match  *regexp.Regexp // nil for any key
+	values bool
+}
+
+func parseEventsRequest(req *http.Request) (r eventsRequest, err error) {
+	query := req.URL.Query()
+	if match := query.Get("match"); match != "" {
+		if r.match, err = globRegexp(match); err != nil {
+			return r, err
+		}
+	}
+	if raw := query.Get("values"); raw != "" {
+		if r.values, err = strconv.ParseBool(raw); err != nil {
+			return r, errors.New("Provide true or false for values")
+		}
+	}
+	return r, nil
+}
+
+// serveEvents handles /<db>/_events, which streams keyspace notifications of exposed keys of the DB as
+// server-sent events, until the client disconnects
+func (c *service) serveEvents(res *responseRecorder, req *http.Request, params []string) {
+	var js []byte
+
+	if c.events == nil {
+		res.WriteHeader(http.StatusNotFound)
+		js, _ = json.Marshal(types.ErrorType{Error: "Keyspace events are not enabled"})
+		res.Write(js)
+		return
+	}
+	db, ok := c.exposedDB(res, params[0])
+	if !ok {
+		return
+	}
+	r, err := parseEventsRequest(req)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return
+	}
+
+	hub := c.events
+	subscriber, missed, reset, ok := hub.subscribe(req.Header.Get(lastEventIDHeader))
+	if !ok {
+		res.Header().Set("Retry-After", "60")
+		res.WriteHeader(http.StatusServiceUnavailable)
+		js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("Too many subscribers to events (no more than %d)", hub.maxSubscribers)})
+		res.Write(js)
+		return
+	}
+	defer hub.unsubscribe(subscriber)
+
+	var client conn.ExtendedClient
+	if r.values {
+		client.Init(db)
+		defer client.RedisClient.Close()
+	}
+	exposure := c.exposureOf(db)
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(res, "retry: %d\n\n", eventRetryMillis)
+	if reset {
+		fmt.Fprintf(res, "event: %s\ndata: {}\n\n", eventReset)
+	}
+
+	send := func(e keyEvent) {
+		if e.event == eventReset {
+			fmt.Fprintf(res, "id: %s\nevent: %s\ndata: {}\n\n", hub.eventID(e.seq), eventReset)
+			return
+		}
+		if e.db != db || !exposure.Exposes(e.key) || exposure.Drops(e.key) || (r.match != nil && !r.match.MatchString(e.key)) {
+			return
+		}
+		message := types.KeyEventType{Key: e.key, Event: e.event}
+		if r.values && !removalEvents[e.event] {
+			result := client.RetrieveBatch(req.Context(), exposure, c.sizeLimits, []types.BatchItemType{{Key: e.key}})[0]
+			switch result.Status {
+			case http.StatusOK:
+				message.ResponseType = result.ResponseType
+			case http.StatusNotFound:
+				// removed since
+			default:
+				message.Error = result.Error
+			}
+		}
+		data, _ := json.Marshal(message)
+		fmt.Fprintf(res, "id: %s\ndata: %s\n\n", hub.eventID(e.seq), data)
+	}
+	for _, e := range missed {
+		send(e)
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-subscriber.events:
+			if !ok {
+				return
+			}
+			send(e)
+		case <-heartbeat.C:
+			res.Write([]byte(": heartbeat\n\n"))
+		case <-req.Context().Done():
+			return
+		}
+		res.Flush()
+	}
+}
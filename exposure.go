@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xd-deng/rediseen/conn"
+)
+
+// configureExposure applies REDISEEN_KEY_PATTERN_DENIED and REDISEEN_REDACTION_RULES, on top of
+// REDISEEN_KEY_PATTERN_EXPOSED (which must be compiled already), then the patterns of specific DBs given by
+// REDISEEN_DB_KEY_PATTERN_EXPOSED and REDISEEN_DB_KEY_PATTERN_DENIED
+func (c *service) configureExposure() error {
+	c.exposure = &conn.Exposure{Allowed: c.regexpKeyPatternExposed}
+
+	var err error
+	keyPatternDenied := os.Getenv("REDISEEN_KEY_PATTERN_DENIED")
+	if keyPatternDenied != "" {
+		c.exposure.Denied, err = regexp.Compile(keyPatternDenied)
+		if err != nil {
+			return fmt.Errorf("REDISEEN_KEY_PATTERN_DENIED can not be compiled as regular expression (details: %s)", err.Error())
+		}
+		logger.Info(fmt.Sprintf("You are hiding keys of pattern `%s`", keyPatternDenied))
+	}
+
+	c.exposure.Redactions, err = parseRedactionRules(os.Getenv("REDISEEN_REDACTION_RULES"))
+	if err != nil {
+		return fmt.Errorf("REDISEEN_REDACTION_RULES provided can not be parsed properly (details: %s)", err.Error())
+	}
+	if len(c.exposure.Redactions) > 0 {
+		logger.Info(fmt.Sprintf("%d redaction rule(s) are applied to values", len(c.exposure.Redactions)))
+	}
+
+	allowed, err := c.parseDBKeyPatterns(os.Getenv("REDISEEN_DB_KEY_PATTERN_EXPOSED"))
+	if err != nil {
+		return fmt.Errorf("REDISEEN_DB_KEY_PATTERN_EXPOSED provided can not be parsed properly (details: %s)", err.Error())
+	}
+	denied, err := c.parseDBKeyPatterns(os.Getenv("REDISEEN_DB_KEY_PATTERN_DENIED"))
+	if err != nil {
+		return fmt.Errorf("REDISEEN_DB_KEY_PATTERN_DENIED provided can not be parsed properly (details: %s)", err.Error())
+	}
+
+	c.dbExposures = map[int]*conn.Exposure{}
+	for db, pattern := range allowed {
+		c.dbExposures[db] = &conn.Exposure{Allowed: pattern, Denied: c.exposure.Denied, Redactions: c.exposure.Redactions}
+	}
+	for db, pattern := range denied {
+		if c.dbExposures[db] == nil {
+			c.dbExposures[db] = &conn.Exposure{Allowed: c.exposure.Allowed, Redactions: c.exposure.Redactions}
+		}
+		c.dbExposures[db].Denied = pattern
+	}
+	if len(c.dbExposures) > 0 {
+		var dbs []string
+		for db := range c.dbExposures {
+			dbs = append(dbs, fmt.Sprint(db))
+		}
+		sort.Strings(dbs)
+		logger.Info(fmt.Sprintf("DB(s) `%s` have their own key patterns", strings.Join(dbs, ";")))
+	}
+	return nil
+}
+
+// parseDBKeyPatterns parses key patterns of specific DBs, like `0=^session:;3-5=^flag:`: semicolon-separated
+// `<DB or DB range>=<pattern>`. DBs must be exposed (see REDISEEN_DB_EXPOSED)
+func (c *service) parseDBKeyPatterns(config string) (map[int]*regexp.Regexp, error) {
+	patterns := map[int]*regexp.Regexp{}
+	if config == "" {
+		return patterns, nil
+	}
+
+	for _, raw := range strings.Split(config, ";") {
+		parts := strings.SplitN(raw, "=", 2)
+		if len(parts) != 2 || !regexpDBOrRange.MatchString(strings.TrimSpace(parts[0])) || parts[1] == "" {
+			return nil, fmt.Errorf("`%s` should be like `<DB or DB range>=<pattern>`", raw)
+		}
+		pattern, err := regexp.Compile(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("pattern `%s` can not be compiled as regular expression (details: %s)", parts[1], err.Error())
+		}
+		for db := range parseDbExposed(strings.TrimSpace(parts[0])) {
+			if !c.dbCheck(db) {
+				return nil, fmt.Errorf("DB %d is not exposed", db)
+			}
+			if patterns[db] != nil {
+				return nil, fmt.Errorf("DB %d is given more than once", db)
+			}
+			patterns[db] = pattern
+		}
+	}
+	return patterns, nil
+}
+
+var regexpDBOrRange = regexp.MustCompile("(^[0-9]+$)|(^[0-9]+)(-)([0-9]+$)")
+
+// exposureOf returns what is exposed in the DB given: its own key patterns if given, or the default ones
+func (c *service) exposureOf(db int) *conn.Exposure {
+	if e, ok := c.dbExposures[db]; ok {
+		return e
+	}
+	return c.exposure
+}
@@ -0,0 +1,48 @@
+package format
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Content codings supported, as given in header Accept-Encoding
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// NegotiateEncoding picks the content coding of the response among those offered (in order of preference),
+// given header Accept-Encoding. It returns "" if the response should not be encoded
+func NegotiateEncoding(acceptEncoding string, offered []string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		qualities[coding] = q
+	}
+
+	encoding, bestQ := "", 0.0
+	for _, coding := range offered {
+		q, ok := qualities[coding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		// on ties, the coding offered first wins
+		if ok && q > bestQ {
+			encoding, bestQ = coding, q
+		}
+	}
+	return encoding
+}
@@ -0,0 +1,112 @@
+// Package format negotiates the format (and content coding) of responses, and encodes them as YAML or MessagePack.
+// Values are encoded as they would be in JSON (i.e. respecting json tags)
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Formats supported, as given in parameter format=
+const (
+	JSON        = "json"
+	NDJSON      = "ndjson"
+	CSV         = "csv"
+	YAML        = "yaml"
+	MessagePack = "msgpack"
+)
+
+var contentTypes = map[string]string{
+	JSON:        "application/json",
+	NDJSON:      "application/x-ndjson",
+	CSV:         "text/csv; charset=utf-8",
+	YAML:        "application/yaml",
+	MessagePack: "application/msgpack",
+}
+
+// mediaTypes maps media types which may be given in header Accept to formats
+var mediaTypes = map[string]string{
+	"application/json":        JSON,
+	"application/*":           JSON,
+	"*/*":                     JSON,
+	"application/x-ndjson":    NDJSON,
+	"application/ndjson":      NDJSON,
+	"application/jsonl":       NDJSON,
+	"text/csv":                CSV,
+	"application/yaml":        YAML,
+	"application/x-yaml":      YAML,
+	"text/yaml":               YAML,
+	"application/msgpack":     MessagePack,
+	"application/x-msgpack":   MessagePack,
+	"application/vnd.msgpack": MessagePack,
+}
+
+// ContentType returns the value of header Content-Type for the format given
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Negotiate picks the format of the response. Parameter format= (if given) takes precedence over header Accept.
+// If none of the media types accepted is supported, JSON is used, as if Accept was not given
+func Negotiate(accept string, param string) (string, error) {
+	if param != "" {
+		param = strings.ToLower(param)
+		if _, ok := contentTypes[param]; !ok {
+			return "", fmt.Errorf("Format %s is not supported. Use one of json, ndjson, csv, yaml and msgpack", param)
+		}
+		return param, nil
+	}
+
+	format, bestQ, bestSpecificity := JSON, 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		f, ok := mediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		// on ties, the more specific media type wins (RFC 9110), then the one listed first
+		specificity := mediaTypeSpecificity(mediaType)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			format, bestQ, bestSpecificity = f, q, specificity
+		}
+	}
+	return format, nil
+}
+
+// mediaTypeSpecificity is 0 for */*, 1 for ranges like application/*, and 2 for media types like application/json
+func mediaTypeSpecificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// generic turns v into nil, bool, json.Number, string, []interface{} and map[string]interface{}, like JSON does
+func generic(v interface{}) (interface{}, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.UseNumber()
+	var result interface{}
+	err = decoder.Decode(&result)
+	return result, err
+}
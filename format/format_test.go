@@ -0,0 +1,133 @@
+package format
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func Test_Negotiate(t *testing.T) {
+	for _, c := range []struct {
+		accept   string
+		param    string
+		expected string
+	}{
+		{"", "", JSON},
+		{"*/*", "", JSON},
+		{"text/csv", "", CSV},
+		{"application/x-msgpack", "", MessagePack},
+		{"application/yaml;q=0.5, application/x-ndjson", "", NDJSON},
+		{"application/yaml, application/x-ndjson", "", YAML},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "", JSON},
+		{"text/html", "", JSON},
+		{"application/msgpack;q=0, text/csv;q=0.1", "", CSV},
+		{"*/*, text/csv", "", CSV},
+		{"application/*, application/yaml", "", YAML},
+		{"text/*", "", JSON},
+		{"text/csv", "YAML", YAML},
+	} {
+		format, err := Negotiate(c.accept, c.param)
+		if err != nil || format != c.expected {
+			t.Error("Expecting", c.expected, "for", c.accept, c.param, "got", format, err)
+		}
+	}
+
+	if _, err := Negotiate("", "xml"); err == nil {
+		t.Error("Expecting error for unsupported format")
+	}
+}
+
+func Test_EncodeYAML(t *testing.T) {
+	v := map[string]interface{}{
+		"type":  "hash",
+		"value": map[string]string{"key:1": "true", "plain": "line 1\nline 2"},
+		"list":  []interface{}{"a", 1.5, nil, map[string]interface{}{"k": false}, []string{}},
+		"empty": map[string]string{},
+	}
+	result, err := EncodeYAML(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"empty: {}",
+		"list:",
+		"  - a",
+		"  - 1.5",
+		"  - null",
+		"  -",
+		"    k: false",
+		"  - []",
+		"type: hash",
+		"value:",
+		`  "key:1": "true"`,
+		`  plain: "line 1\nline 2"`,
+		"",
+	}, "\n")
+	if string(result) != expected {
+		t.Error("Expecting\n", expected, "\ngot\n", string(result))
+	}
+
+	result, _ = EncodeYAML([]string{"no"})
+	if string(result) != "- \"no\"\n" {
+		t.Error("Expecting reserved words to be quoted, got", string(result))
+	}
+}
+
+func Test_EncodeMessagePack(t *testing.T) {
+	for _, c := range []struct {
+		v        interface{}
+		expected string
+	}{
+		{nil, "c0"},
+		{true, "c3"},
+		{1, "01"},
+		{-1, "ff"},
+		{-100, "d09c"},
+		{300, "d1012c"},
+		{1 << 40, "d30000010000000000"},
+		{1.5, "cb3ff8000000000000"},
+		{"abc", "a3616263"},
+		{strings.Repeat("x", 40), "d928" + strings.Repeat("78", 40)},
+		{[]string{"a"}, "91a161"},
+		{map[string]interface{}{"b": 1, "a": false}, "82a161c2a16201"},
+	} {
+		result, err := EncodeMessagePack(c.v)
+		if err != nil || hex.EncodeToString(result) != c.expected {
+			t.Error("Expecting", c.expected, "for", c.v, "got", hex.EncodeToString(result), err)
+		}
+	}
+
+	result, _ := EncodeMessagePack(make([]int, 20))
+	if !bytes.HasPrefix(result, []byte{0xdc, 0, 20}) {
+		t.Error("Expecting array16 for 20 elements, got", hex.EncodeToString(result[:3]))
+	}
+}
+
+func Test_NegotiateEncoding(t *testing.T) {
+	offered := []string{Zstd, Gzip}
+	for _, c := range []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", Gzip},
+		{"gzip, deflate, br, zstd", Zstd},
+		{"zstd;q=0.5, gzip", Gzip},
+		{"GZIP;q=0.8", Gzip},
+		{"*", Zstd},
+		{"*, zstd;q=0", Gzip},
+		{"gzip;q=0", ""},
+		{"br", ""},
+	} {
+		encoding := NegotiateEncoding(c.acceptEncoding, offered)
+		if encoding != c.expected {
+			t.Error("Expecting", c.expected, "for", c.acceptEncoding, "got", encoding)
+		}
+	}
+
+	if encoding := NegotiateEncoding("zstd, gzip", []string{Gzip}); encoding != Gzip {
+		t.Error("Expecting only codings offered to be picked, got", encoding)
+	}
+}
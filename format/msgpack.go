@@ -0,0 +1,100 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// EncodeMessagePack encodes v as MessagePack (https://github.com/msgpack/msgpack/blob/master/spec.md).
+// Map keys are sorted, so that the encoding is deterministic
+func EncodeMessagePack(v interface{}) ([]byte, error) {
+	g, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	writeMessagePack(b, g)
+	return b.Bytes(), nil
+}
+
+func writeMessagePack(b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if v {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeMessagePackInt(b, i)
+		} else {
+			f, _ := v.Float64()
+			b.WriteByte(0xcb)
+			binary.Write(b, binary.BigEndian, math.Float64bits(f))
+		}
+	case string:
+		writeMessagePackHeader(b, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		b.WriteString(v)
+	case []interface{}:
+		writeMessagePackHeader(b, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			writeMessagePack(b, e)
+		}
+	case map[string]interface{}:
+		writeMessagePackHeader(b, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeMessagePack(b, k)
+			writeMessagePack(b, v[k])
+		}
+	}
+}
+
+// writeMessagePackHeader writes the type and the length of a string, array or map, in the shortest form.
+// code8 is 0 for types without 8-bit length (i.e. array and map)
+func writeMessagePackHeader(b *bytes.Buffer, n int, fixCode byte, fixLimit int, code8 byte, code16 byte, code32 byte) {
+	switch {
+	case n < fixLimit:
+		b.WriteByte(fixCode | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		b.WriteByte(code8)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(code16)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(code32)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMessagePackInt(b *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		b.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		b.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		b.WriteByte(0xd0)
+		b.WriteByte(byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		b.WriteByte(0xd1)
+		binary.Write(b, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		b.WriteByte(0xd2)
+		binary.Write(b, binary.BigEndian, int32(i))
+	default:
+		b.WriteByte(0xd3)
+		binary.Write(b, binary.BigEndian, i)
+	}
+}
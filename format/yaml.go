@@ -0,0 +1,102 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// plainScalar matches strings which can be written without quotes, unless they are reserved (see yamlReserved)
+var plainScalar = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./-]*$`)
+
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true, "y": true, "n": true, "null": true,
+}
+
+// EncodeYAML encodes v as a YAML document
+func EncodeYAML(v interface{}) ([]byte, error) {
+	g, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	switch g := g.(type) {
+	case map[string]interface{}:
+		if len(g) == 0 {
+			b.WriteString("{}\n")
+		}
+		writeYAMLMap(b, g, 0)
+	case []interface{}:
+		if len(g) == 0 {
+			b.WriteString("[]\n")
+		}
+		writeYAMLList(b, g, 0)
+	default:
+		b.WriteString(yamlScalar(g) + "\n")
+	}
+	return b.Bytes(), nil
+}
+
+// writeYAMLValue writes v after `key:` or `-`, which is already written on the current line
+func writeYAMLValue(b *bytes.Buffer, v interface{}, indent int) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAMLMap(b, v, indent)
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAMLList(b, v, indent)
+	default:
+		b.WriteString(" " + yamlScalar(v) + "\n")
+	}
+}
+
+func writeYAMLMap(b *bytes.Buffer, m map[string]interface{}, indent int) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(strings.Repeat("  ", indent) + yamlScalar(k) + ":")
+		writeYAMLValue(b, m[k], indent+1)
+	}
+}
+
+func writeYAMLList(b *bytes.Buffer, l []interface{}, indent int) {
+	for _, e := range l {
+		b.WriteString(strings.Repeat("  ", indent) + "-")
+		writeYAMLValue(b, e, indent+1)
+	}
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if plainScalar.MatchString(v) && !yamlReserved[strings.ToLower(v)] {
+			return v
+		}
+		// JSON strings are valid double-quoted YAML scalars
+		js, _ := json.Marshal(v)
+		return string(js)
+	default:
+		return "null"
+	}
+}
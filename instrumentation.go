@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xd-deng/rediseen/logging"
+	"github.com/xd-deng/rediseen/metrics"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// Endpoint kinds, used to label self-instrumentation metrics
+const (
+	endpointOther       = "other"
+	endpointRoot        = "root"
+	endpointList        = "list"
+	endpointKey         = "key"
+	endpointField       = "field"
+	endpointInfo        = "info"
+	endpointMetrics     = "metrics"
+	endpointDiagnostics = "diagnostics"
+	endpointOpenAPI     = "openapi"
+	endpointBatch       = "batch"
+	endpointPreflight   = "preflight"
+	endpointDBs         = "dbs"
+	endpointUI          = "ui"
+	endpointEvents      = "events"
+	endpointPubSub      = "pubsub"
+	endpointPublish     = "publish"
+)
+
+// Reasons of value cache evictions
+const (
+	evictionCapacity    = "capacity"
+	evictionInvalidated = "invalidated"
+)
+
+var (
+	httpRequestsTotal = metrics.NewCounterVec("rediseen_http_requests_total",
+		"Total number of HTTP requests handled by Rediseen.", "endpoint", "code", "db")
+	httpRequestDuration = metrics.NewHistogramVec("rediseen_http_request_duration_seconds",
+		"Latency of HTTP requests handled by Rediseen.", nil, "endpoint", "code", "db")
+	redisErrorsTotal = metrics.NewCounterVec("rediseen_redis_errors_total",
+		"Total number of errors returned when talking to Redis.", "endpoint")
+	authFailuresTotal = metrics.NewCounterVec("rediseen_auth_failures_total",
+		"Total number of requests rejected by API key authentication.")
+	ipRejectedTotal = metrics.NewCounterVec("rediseen_ip_rejected_total",
+		"Total number of requests rejected since their client IP addresses are denied, or not allowed.")
+	rateLimitedTotal = metrics.NewCounterVec("rediseen_rate_limited_total",
+		"Total number of requests rejected by rate limits, or by the limit of concurrent requests.", "limit")
+	rateLimitStoreErrorsTotal = metrics.NewCounterVec("rediseen_rate_limit_store_errors_total",
+		"Total number of rate limit checks failed (hence requests allowed), when buckets are kept in Redis.")
+	concurrentRequests = metrics.NewGaugeVec("rediseen_concurrent_requests",
+		"Number of requests talking to Redis in progress.")
+	sizeLimitedTotal = metrics.NewCounterVec("rediseen_size_limited_total",
+		"Total number of values not read since they exceed size limits.", "limit")
+	valueCacheHitsTotal = metrics.NewCounterVec("rediseen_value_cache_hits_total",
+		"Total number of key reads served from the value cache.")
+	valueCacheMissesTotal = metrics.NewCounterVec("rediseen_value_cache_misses_total",
+		"Total number of key reads not found in the value cache (hence read from Redis).")
+	valueCacheEvictionsTotal = metrics.NewCounterVec("rediseen_value_cache_evictions_total",
+		"Total number of entries evicted from the value cache.", "reason")
+	valueCacheEntries = metrics.NewGaugeVec("rediseen_value_cache_entries",
+		"Number of entries in the value cache.")
+	valueCacheBytes = metrics.NewGaugeVec("rediseen_value_cache_bytes",
+		"Approximate memory used by entries in the value cache.")
+	eventSubscribers = metrics.NewGaugeVec("rediseen_event_subscribers",
+		"Number of clients subscribed to keyspace events.")
+	eventSubscribersDroppedTotal = metrics.NewCounterVec("rediseen_event_subscribers_dropped_total",
+		"Total number of subscribers to keyspace events disconnected since they fell behind.")
+	pubsubConnections = metrics.NewGaugeVec("rediseen_pubsub_connections",
+		"Number of WebSocket connections to Pub/Sub channels.")
+	pubsubMessagesTotal = metrics.NewCounterVec("rediseen_pubsub_messages_total",
+		"Total number of Pub/Sub messages delivered to WebSocket clients, or published by clients.", "direction")
+	pubsubSlowClientsTotal = metrics.NewCounterVec("rediseen_pubsub_slow_clients_total",
+		"Total number of WebSocket connections closed since their clients did not read messages fast enough.")
+
+	selfMetricsRegistry = &metrics.Registry{}
+)
+
+func init() {
+	selfMetricsRegistry.MustRegister(
+		httpRequestsTotal,
+		httpRequestDuration,
+		redisErrorsTotal,
+		authFailuresTotal,
+		ipRejectedTotal,
+		rateLimitedTotal,
+		rateLimitStoreErrorsTotal,
+		concurrentRequests,
+		sizeLimitedTotal,
+		valueCacheHitsTotal,
+		valueCacheMissesTotal,
+		valueCacheEvictionsTotal,
+		valueCacheEntries,
+		valueCacheBytes,
+		eventSubscribers,
+		eventSubscribersDroppedTotal,
+		pubsubConnections,
+		pubsubMessagesTotal,
+		pubsubSlowClientsTotal,
+		metrics.RuntimeCollector{},
+	)
+}
+
+// responseRecorder wraps http.ResponseWriter, so that we can know what has been written to the client.
+// Handlers also fill in the endpoint kind, DB, key, field, channel and caller, which are used to label metrics, in logs
+// and in audit records
+type responseRecorder struct {
+	http.ResponseWriter
+	status    int
+	bytes     int
+	endpoint  string
+	route     string
+	db        string // requested, even if not exposed
+	dbLabel   string // db if exposed, for metrics
+	key       string
+	field     string
+	batch     []types.BatchResultType // results of /<db>/_batch, one per key
+	channel   string                  // published to
+	receivers *int64                  // of the message published, if any
+	caller    string
+	clientIP  string // as told by trusted proxies, if any (see clientIP)
+	log       *logging.Logger
+}
+
+func newResponseRecorder(res http.ResponseWriter, log *logging.Logger) *responseRecorder {
+	return &responseRecorder{ResponseWriter: res, status: http.StatusOK, endpoint: endpointOther, log: log}
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush sends the data written so far to the client, if the underlying ResponseWriter supports it
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSockets take over the connection (see websocket.Upgrader)
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	c, rw, err := hijacker.Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return c, rw, err
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// setDB records the logical DB the request targets. Only exposed DBs label metrics, so that their cardinality is
+// bounded by REDISEEN_DB_EXPOSED rather than by what clients ask for
+func (r *responseRecorder) setDB(db int, exposed bool) {
+	r.db = strconv.Itoa(db)
+	if exposed {
+		r.dbLabel = r.db
+	}
+}
+
+// observe records the result of a finished request into the self-instrumentation metrics
+func (r *responseRecorder) observe(elapsed time.Duration) {
+	code := strconv.Itoa(r.status)
+	httpRequestsTotal.Inc(r.endpoint, code, r.dbLabel)
+	httpRequestDuration.Observe(elapsed.Seconds(), r.endpoint, code, r.dbLabel)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/metrics"
+	"github.com/xd-deng/rediseen/types"
+)
+
+const defaultKeyMetricsMaxSeries = 1000
+const keyMetricsScanCount = 1000
+
+var regexpMetricName = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+
+// reservedMetricPrefixes are prefixes of the metric families given by /metrics besides key metrics
+var reservedMetricPrefixes = []string{"redis_", "rediseen_", "go_", "process_"}
+
+// keyMetricRule maps Redis keys (and optionally hash fields or sorted set members) to a Prometheus metric
+type keyMetricRule struct {
+	Name         string `json:"name"`
+	Help         string `json:"help"`
+	Type         string `json:"type"`
+	DB           int    `json:"db"`
+	Source       string `json:"source"`
+	KeyPattern   string `json:"key_pattern"`
+	FieldPattern string `json:"field_pattern"`
+	ScanMatch    string `json:"scan_match"`
+
+	regexpKey   *regexp.Regexp
+	regexpField *regexp.Regexp
+	labelNames  []string
+}
+
+// keyMetricsConfig is the content of the file given via REDISEEN_KEY_METRICS_CONFIG
+type keyMetricsConfig struct {
+	MaxSeries int             `json:"max_series"`
+	Metrics   []keyMetricRule `json:"metrics"`
+}
+
+// loadKeyMetricsConfig reads and validates the key metrics configuration file
+func loadKeyMetricsConfig(configFile string) (*keyMetricsConfig, error) {
+	raw, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var config keyMetricsConfig
+	err = json.Unmarshal(raw, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MaxSeries <= 0 {
+		config.MaxSeries = defaultKeyMetricsMaxSeries
+	}
+
+	names := make(map[string]bool)
+	for i := range config.Metrics {
+		err = config.Metrics[i].prepare()
+		if err != nil {
+			return nil, fmt.Errorf("metric #%d `%s`: %s", i, config.Metrics[i].Name, err.Error())
+		}
+		if names[config.Metrics[i].Name] {
+			return nil, fmt.Errorf("metric #%d `%s`: duplicated metric name", i, config.Metrics[i].Name)
+		}
+		names[config.Metrics[i].Name] = true
+	}
+
+	return &config, nil
+}
+
+// prepare validates the rule, compiles its regular expressions and decides its label names
+func (r *keyMetricRule) prepare() error {
+	var err error
+
+	if !regexpMetricName.MatchString(r.Name) {
+		return errors.New("invalid metric name")
+	}
+	// metrics of Redis INFO, of Rediseen itself and of the Go runtime are given by /metrics as well
+	for _, prefix := range reservedMetricPrefixes {
+		if strings.HasPrefix(r.Name, prefix) {
+			return fmt.Errorf("metric names starting with %s are reserved", strings.Join(reservedMetricPrefixes, ", "))
+		}
+	}
+	if r.Help == "" {
+		r.Help = "Value of Redis keys matching " + r.KeyPattern
+	}
+
+	switch r.Type {
+	case "":
+		r.Type = "gauge"
+	case "gauge", "counter":
+	default:
+		return fmt.Errorf("unsupported type `%s` (supported: gauge, counter)", r.Type)
+	}
+
+	switch r.Source {
+	case "":
+		r.Source = "string"
+	case "string":
+	case "hash", "zset":
+		if r.FieldPattern == "" {
+			return fmt.Errorf("field_pattern is required for source `%s`", r.Source)
+		}
+	default:
+		return fmt.Errorf("unsupported source `%s` (supported: string, hash, zset)", r.Source)
+	}
+
+	if r.KeyPattern == "" {
+		return errors.New("key_pattern is required")
+	}
+	r.regexpKey, err = regexp.Compile(r.KeyPattern)
+	if err != nil {
+		return err
+	}
+	r.labelNames = captureGroupNames(r.regexpKey)
+
+	if r.FieldPattern != "" {
+		r.regexpField, err = regexp.Compile(r.FieldPattern)
+		if err != nil {
+			return err
+		}
+		r.labelNames = append(r.labelNames, captureGroupNames(r.regexpField)...)
+	}
+	labels := make(map[string]bool)
+	for _, name := range r.labelNames {
+		if labels[name] {
+			return fmt.Errorf("label `%s` is captured more than once (by key_pattern and field_pattern)", name)
+		}
+		labels[name] = true
+	}
+
+	if r.ScanMatch == "" {
+		r.ScanMatch = "*"
+	}
+
+	return nil
+}
+
+// captureGroupNames returns the names of named capture groups, which are used as label names
+func captureGroupNames(p *regexp.Regexp) []string {
+	var names []string
+	for _, n := range p.SubexpNames() {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// captureGroupValues returns the values of named capture groups given the submatches of a string
+func captureGroupValues(p *regexp.Regexp, submatches []string) []string {
+	var values []string
+	for i, n := range p.SubexpNames() {
+		if n != "" {
+			values = append(values, submatches[i])
+		}
+	}
+	return values
+}
+
+// keyMetricsLimitReached tells whether the cardinality cap was hit during the last collection of a metric
+var keyMetricsLimitReached = metrics.NewGaugeVec("rediseen_key_metrics_limit_reached",
+	"Whether series of the metric were dropped during the last scrape because max_series was reached.", "metric")
+
+// keyMetricsDuplicatedSeries tells how many samples were dropped during the last collection of a metric, since
+// their labels were the same as those of samples written already (e.g. key_pattern has no capture group)
+var keyMetricsDuplicatedSeries = metrics.NewGaugeVec("rediseen_key_metrics_duplicated_series",
+	"Number of samples of the metric dropped during the last scrape because their labels were duplicated.", "metric")
+
+// collectKeyMetrics reads values of keys matching the configured rules, and writes them as Prometheus metrics.
+// Only keys in exposed DBs and matching the key pattern exposed are collected.
+// At most MaxSeries series are collected per metric
+func (c *service) collectKeyMetrics(ctx context.Context, w io.Writer) {
+	if c.keyMetrics == nil {
+		return
+	}
+
+	for i := range c.keyMetrics.Metrics {
+		rule := &c.keyMetrics.Metrics[i]
+		if !c.dbCheck(rule.DB) {
+			continue
+		}
+
+		limitReached, duplicated := c.collectKeyMetric(ctx, w, rule)
+		if duplicated > 0 {
+			logger.Warn(fmt.Sprintf("%d samples of metric %s are dropped since their labels are duplicated "+
+				"(capture groups of key_pattern and field_pattern should tell keys apart)", duplicated, rule.Name))
+		}
+		keyMetricsDuplicatedSeries.Set(float64(duplicated), rule.Name)
+		if limitReached {
+			logger.Warn(fmt.Sprintf("Series of metric %s are dropped since max_series (%d) is reached",
+				rule.Name, c.keyMetrics.MaxSeries))
+			keyMetricsLimitReached.Set(1, rule.Name)
+		} else {
+			keyMetricsLimitReached.Set(0, rule.Name)
+		}
+	}
+	keyMetricsLimitReached.Collect(w)
+	keyMetricsDuplicatedSeries.Collect(w)
+}
+
+// collectKeyMetric writes samples of one rule. It returns true if the cardinality cap was hit, and the number of
+// samples dropped since their labels were duplicated
+func (c *service) collectKeyMetric(ctx context.Context, w io.Writer, rule *keyMetricRule) (limitReached bool, duplicated int) {
+	var client conn.ExtendedClient
+	client.Init(rule.DB)
+	defer client.RedisClient.Close()
+
+	metrics.WriteHeader(w, rule.Name, rule.Help, rule.Type)
+
+	series := 0
+	written := make(map[string]bool)
+	iter := client.RedisClient.Scan(ctx, 0, rule.ScanMatch, keyMetricsScanCount).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if exposure := c.exposureOf(rule.DB); !exposure.Exposes(key) || exposure.Drops(key) {
+			continue
+		}
+		keySubmatches := rule.regexpKey.FindStringSubmatch(key)
+		if keySubmatches == nil {
+			continue
+		}
+		keyLabelValues := captureGroupValues(rule.regexpKey, keySubmatches)
+
+		samples, err := readKeyMetricSamples(ctx, client.RedisClient, rule, key, c.exposure.Redactor(key))
+		if err != nil {
+			continue
+		}
+		for _, s := range samples {
+			labelValues := append(append([]string{}, keyLabelValues...), s.labelValues...)
+			labelSet := strings.Join(labelValues, "\xff")
+			if written[labelSet] {
+				duplicated++
+				continue
+			}
+			if series >= c.keyMetrics.MaxSeries {
+				return true, duplicated
+			}
+			metrics.WriteSample(w, rule.Name, rule.labelNames, labelValues, s.value)
+			written[labelSet] = true
+			series++
+		}
+	}
+	if err := iter.Err(); err != nil {
+		redisErrorsTotal.Inc(endpointMetrics)
+	}
+	return false, duplicated
+}
+
+type keyMetricSample struct {
+	labelValues []string
+	value       float64
+}
+
+// readKeyMetricSamples reads the value(s) of a single key according to the rule. Values redacted by redactor
+// (which may be nil) are skipped
+func readKeyMetricSamples(ctx context.Context, client *redis.Client, rule *keyMetricRule, key string, redactor *conn.Redactor) ([]keyMetricSample, error) {
+	var samples []keyMetricSample
+
+	switch rule.Source {
+	case "string":
+		raw, err := client.Get(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		if redacted, _ := redactor.Element("string", raw); redacted != raw {
+			return nil, nil
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, keyMetricSample{value: v})
+	case "hash":
+		fields, err := client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		var fieldNames []string
+		for field := range fields {
+			fieldNames = append(fieldNames, field)
+		}
+		sort.Strings(fieldNames)
+		for _, field := range fieldNames {
+			raw := fields[field]
+			if redacted, keep := redactor.Element("hash", types.HashEntryType{Field: field, Value: raw}); !keep ||
+				redacted.(types.HashEntryType).Value != raw {
+				continue
+			}
+			fieldSubmatches := rule.regexpField.FindStringSubmatch(field)
+			if fieldSubmatches == nil {
+				continue
+			}
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			samples = append(samples, keyMetricSample{labelValues: captureGroupValues(rule.regexpField, fieldSubmatches), value: v})
+		}
+	case "zset":
+		members, err := client.ZRangeWithScores(ctx, key, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			member := fmt.Sprint(m.Member)
+			if redacted, keep := redactor.Element("zset", member); !keep || redacted != member {
+				continue
+			}
+			memberSubmatches := rule.regexpField.FindStringSubmatch(member)
+			if memberSubmatches == nil {
+				continue
+			}
+			samples = append(samples, keyMetricSample{labelValues: captureGroupValues(rule.regexpField, memberSubmatches), value: m.Score})
+		}
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,264 @@
+// Package logging provides a minimal leveled logger, which can write either human-readable text,
+// JSON or logfmt lines. Each line can carry structured key-value fields.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+// Supported levels, from the most verbose to the least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Supported output formats
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+const textTimeLayout = "2006/01/02 15:04:05"
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// ParseLevel parses strings like "debug", "info", "warn" (or "warning") and "error"
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level `%s` (supported: debug, info, warn, error)", s)
+}
+
+// ValidateFormat checks if the format given is supported. "" stands for FormatText
+func ValidateFormat(format string) error {
+	switch format {
+	case "", FormatText, FormatJSON, FormatLogfmt:
+		return nil
+	}
+	return errors.New("unknown log format `" + format + "` (supported: text, json, logfmt)")
+}
+
+// output is shared by a Logger and all the Loggers derived from it via With
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	level  Level
+}
+
+// Logger writes leveled log lines with structured fields
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New creates a Logger writing lines of the format given into w
+func New(w io.Writer, format string, level Level) *Logger {
+	if format == "" {
+		format = FormatText
+	}
+	return &Logger{out: &output{w: w, format: format, level: level}}
+}
+
+// Default creates a Logger writing human-readable text lines of level info and above into stderr
+func Default() *Logger {
+	return New(os.Stderr, FormatText, LevelInfo)
+}
+
+// Configure changes the format and the level of the Logger, and of all the Loggers derived from it
+func (l *Logger) Configure(format string, level Level) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	if format == "" {
+		format = FormatText
+	}
+	l.out.format = format
+	l.out.level = level
+}
+
+// SetOutput changes where log lines are written into
+func (l *Logger) SetOutput(w io.Writer) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w = w
+}
+
+// With returns a Logger which adds the key-value pairs given to every line it writes
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyValues...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled tells whether lines of the level given will be written
+func (l *Logger) Enabled(level Level) bool {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return level >= l.out.level
+}
+
+// Debug writes a line of level debug
+func (l *Logger) Debug(msg string, keyValues ...interface{}) {
+	l.log(LevelDebug, msg, keyValues)
+}
+
+// Info writes a line of level info
+func (l *Logger) Info(msg string, keyValues ...interface{}) {
+	l.log(LevelInfo, msg, keyValues)
+}
+
+// Warn writes a line of level warn
+func (l *Logger) Warn(msg string, keyValues ...interface{}) {
+	l.log(LevelWarn, msg, keyValues)
+}
+
+// Error writes a line of level error
+func (l *Logger) Error(msg string, keyValues ...interface{}) {
+	l.log(LevelError, msg, keyValues)
+}
+
+func (l *Logger) log(level Level, msg string, keyValues []interface{}) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	if level < l.out.level {
+		return
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), keyValues...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+
+	var line []byte
+	now := time.Now()
+	switch l.out.format {
+	case FormatJSON:
+		line = formatJSON(now, level, msg, fields)
+	case FormatLogfmt:
+		line = formatLogfmt(now, level, msg, fields)
+	default:
+		line = formatText(now, level, msg, fields)
+	}
+	l.out.w.Write(line)
+}
+
+func formatText(now time.Time, level Level, msg string, fields []interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(now.Format(textTimeLayout))
+	buf.WriteString(" [")
+	if level == LevelWarn {
+		// keep the prefix used by Rediseen before structured logging was introduced
+		buf.WriteString("WARNING")
+	} else {
+		buf.WriteString(strings.ToUpper(level.String()))
+	}
+	buf.WriteString("] ")
+	buf.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		buf.WriteString(" ")
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteString("=")
+		buf.WriteString(logfmtValue(fields[i+1]))
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+func formatLogfmt(now time.Time, level Level, msg string, fields []interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("time=")
+	buf.WriteString(now.Format(time.RFC3339Nano))
+	buf.WriteString(" level=")
+	buf.WriteString(level.String())
+	buf.WriteString(" msg=")
+	buf.WriteString(logfmtValue(msg))
+	for i := 0; i < len(fields); i += 2 {
+		buf.WriteString(" ")
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteString("=")
+		buf.WriteString(logfmtValue(fields[i+1]))
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+func formatJSON(now time.Time, level Level, msg string, fields []interface{}) []byte {
+	entry := map[string]interface{}{
+		"time":  now.Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if _, reserved := entry[key]; reserved {
+			key = "field." + key
+		}
+		value := fields[i+1]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		entry[key] = value
+	}
+
+	// encoding/json sorts map keys, which makes lines stable and easy to read
+	js, err := json.Marshal(entry)
+	if err != nil {
+		js, _ = json.Marshal(map[string]string{"level": level.String(), "msg": msg, "log_error": err.Error()})
+	}
+	return append(js, '\n')
+}
+
+// logfmtValue renders a value, quoting it when needed
+func logfmtValue(v interface{}) string {
+	var s string
+	switch value := v.(type) {
+	case string:
+		s = value
+	case error:
+		s = value.Error()
+	case time.Duration:
+		s = value.String()
+	default:
+		s = fmt.Sprint(value)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
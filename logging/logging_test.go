@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_ParseLevel(t *testing.T) {
+	cases := map[string]Level{"": LevelInfo, "debug": LevelDebug, "INFO": LevelInfo, "warning": LevelWarn, "warn": LevelWarn, "error": LevelError}
+	for input, expected := range cases {
+		level, err := ParseLevel(input)
+		if err != nil || level != expected {
+			t.Error("Expecting\n", expected, "\ngot\n", level)
+		}
+	}
+
+	_, err := ParseLevel("verbose")
+	if err == nil {
+		t.Error("Expecting error but got nil")
+	}
+}
+
+func Test_Logger_level(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, FormatText, LevelWarn)
+
+	l.Debug("debug line")
+	l.Info("info line")
+	l.Warn("warn line")
+	l.Error("error line")
+
+	output := buf.String()
+	if strings.Contains(output, "debug line") || strings.Contains(output, "info line") {
+		t.Error("Lines below the configured level should not be written")
+	}
+	if !strings.Contains(output, "[WARNING] warn line\n") || !strings.Contains(output, "[ERROR] error line\n") {
+		t.Error("Lines of or above the configured level should be written, got\n", output)
+	}
+}
+
+func Test_Logger_json(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, FormatJSON, LevelDebug).With("request_id", "abc")
+
+	l.Info("request completed", "status", 200, "key", "key:1")
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Error("Line is not valid JSON: ", buf.String())
+	}
+	if entry["level"] != "info" || entry["msg"] != "request completed" || entry["request_id"] != "abc" ||
+		entry["status"] != float64(200) || entry["key"] != "key:1" || entry["time"] == nil {
+		t.Error("Unexpected JSON line: ", buf.String())
+	}
+}
+
+func Test_Logger_logfmt(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, FormatLogfmt, LevelDebug).With("request_id", "abc")
+
+	l.Warn("key not found", "key", "key with space", "db", 0)
+
+	output := buf.String()
+	if !strings.HasPrefix(output, "time=") {
+		t.Error("Unexpected logfmt line: ", output)
+	}
+	if !strings.HasSuffix(output, ` level=warn msg="key not found" request_id=abc key="key with space" db=0`+"\n") {
+		t.Error("Unexpected logfmt line: ", output)
+	}
+}
+
+func Test_Logger_Configure(t *testing.T) {
+	var buf bytes.Buffer
+	parent := New(&buf, FormatText, LevelInfo)
+	child := parent.With("a", "b")
+
+	parent.Configure(FormatJSON, LevelError)
+	child.Warn("dropped")
+	child.Error("kept")
+
+	if strings.Contains(buf.String(), "dropped") || !strings.HasPrefix(buf.String(), "{") {
+		t.Error("Derived loggers should follow configuration of the parent, got\n", buf.String())
+	}
+}
@@ -0,0 +1,286 @@
+// Package metrics provides minimal Prometheus-compatible instruments (counters, gauges, histograms)
+// and helpers to render them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default upper bounds (in seconds) used by histograms for request latency
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Collector is anything which can write itself in Prometheus text exposition format
+type Collector interface {
+	Collect(w io.Writer)
+}
+
+// Registry holds a list of collectors and renders them in registration order
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// MustRegister adds collectors to the registry
+func (r *Registry) MustRegister(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Collect renders all registered collectors into w
+func (r *Registry) Collect(w io.Writer) {
+	r.mu.Lock()
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.Collect(w)
+	}
+}
+
+// WriteHeader writes the `# HELP` and `# TYPE` lines of a metric family
+func WriteHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// WriteSample writes a single sample line, e.g. `name{a="1"} 2`
+func WriteSample(w io.Writer, name string, labelNames []string, labelValues []string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, FormatLabels(labelNames, labelValues), FormatValue(value))
+}
+
+// FormatLabels renders label pairs as `{a="1",b="2"}`. It returns "" when there is no label
+func FormatLabels(labelNames []string, labelValues []string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("{")
+	for i, n := range labelNames {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labelValues[i]))
+		b.WriteString(`"`)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// FormatValue renders a sample value the way Prometheus expects it
+func FormatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// SanitizeName turns an arbitrary string into a valid metric (or label) name
+func SanitizeName(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (r >= '0' && r <= '9' && i > 0) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func escapeLabelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+// vec is the common part of all labelled instruments
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	keys       []string
+	values     map[string][]string
+}
+
+func newVec(name string, help string, labelNames []string) vec {
+	return vec{name: name, help: help, labelNames: labelNames, values: make(map[string][]string)}
+}
+
+// key returns the internal key for a combination of label values, registering it if it is new.
+// Caller must hold v.mu
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	k := strings.Join(labelValues, "\xff")
+	if _, ok := v.values[k]; !ok {
+		lv := make([]string, len(labelValues))
+		copy(lv, labelValues)
+		v.values[k] = lv
+		v.keys = append(v.keys, k)
+		sort.Strings(v.keys)
+	}
+	return k
+}
+
+// CounterVec is a set of monotonically increasing counters sharing the same name, split by labels
+type CounterVec struct {
+	vec
+	counts map[string]float64
+}
+
+// NewCounterVec creates a CounterVec
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{vec: newVec(name, help, labelNames), counts: make(map[string]float64)}
+}
+
+// Inc increases the counter identified by labelValues by 1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter identified by labelValues by v
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.key(labelValues)] += v
+}
+
+// Value returns the current value of the counter identified by labelValues
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[strings.Join(labelValues, "\xff")]
+}
+
+// Collect implements Collector
+func (c *CounterVec) Collect(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	WriteHeader(w, c.name, c.help, "counter")
+	for _, k := range c.keys {
+		WriteSample(w, c.name, c.labelNames, c.values[k], c.counts[k])
+	}
+}
+
+// GaugeVec is a set of gauges sharing the same name, split by labels
+type GaugeVec struct {
+	vec
+	gauges map[string]float64
+}
+
+// NewGaugeVec creates a GaugeVec
+func NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{vec: newVec(name, help, labelNames), gauges: make(map[string]float64)}
+}
+
+// Set sets the gauge identified by labelValues to v
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gauges[g.key(labelValues)] = v
+}
+
+// Add adds v (which may be negative) to the gauge identified by labelValues
+func (g *GaugeVec) Add(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gauges[g.key(labelValues)] += v
+}
+
+// Value returns the current value of the gauge identified by labelValues
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.gauges[strings.Join(labelValues, "\xff")]
+}
+
+// Collect implements Collector
+func (g *GaugeVec) Collect(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	WriteHeader(w, g.name, g.help, "gauge")
+	for _, k := range g.keys {
+		WriteSample(w, g.name, g.labelNames, g.values[k], g.gauges[k])
+	}
+}
+
+// HistogramVec is a set of histograms sharing the same name and buckets, split by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogramVec creates a HistogramVec. DefaultBuckets is used if buckets is nil
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &HistogramVec{
+		vec:     newVec(name, help, labelNames),
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+	}
+}
+
+// Observe adds an observation to the histogram identified by labelValues
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(labelValues)
+	if _, ok := h.counts[k]; !ok {
+		h.counts[k] = make([]uint64, len(h.buckets))
+	}
+	for i, upperBound := range h.buckets {
+		if v <= upperBound {
+			h.counts[k][i]++
+		}
+	}
+	h.sums[k] += v
+	h.totals[k]++
+}
+
+// Collect implements Collector
+func (h *HistogramVec) Collect(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	WriteHeader(w, h.name, h.help, "histogram")
+	bucketLabelNames := append(append([]string{}, h.labelNames...), "le")
+	for _, k := range h.keys {
+		labelValues := h.values[k]
+		for i, upperBound := range h.buckets {
+			WriteSample(w, h.name+"_bucket", bucketLabelNames,
+				append(append([]string{}, labelValues...), FormatValue(upperBound)), float64(h.counts[k][i]))
+		}
+		WriteSample(w, h.name+"_bucket", bucketLabelNames,
+			append(append([]string{}, labelValues...), "+Inf"), float64(h.totals[k]))
+		WriteSample(w, h.name+"_sum", h.labelNames, labelValues, h.sums[k])
+		WriteSample(w, h.name+"_count", h.labelNames, labelValues, float64(h.totals[k]))
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_CounterVec(t *testing.T) {
+	c := NewCounterVec("test_total", "A test counter.", "a", "b")
+	c.Inc("1", "x")
+	c.Inc("1", "x")
+	c.Add(3, "2", "y\"z")
+
+	var buf bytes.Buffer
+	c.Collect(&buf)
+
+	expected := "# HELP test_total A test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{a=\"1\",b=\"x\"} 2\n" +
+		"test_total{a=\"2\",b=\"y\\\"z\"} 3\n"
+	if buf.String() != expected {
+		t.Error("Expecting\n", expected, "\ngot\n", buf.String())
+	}
+}
+
+func Test_HistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "A test histogram.", []float64{0.1, 1}, "kind")
+	h.Observe(0.05, "x")
+	h.Observe(0.5, "x")
+	h.Observe(5, "x")
+
+	var buf bytes.Buffer
+	h.Collect(&buf)
+
+	for _, expected := range []string{
+		"# TYPE test_seconds histogram",
+		"test_seconds_bucket{kind=\"x\",le=\"0.1\"} 1",
+		"test_seconds_bucket{kind=\"x\",le=\"1\"} 2",
+		"test_seconds_bucket{kind=\"x\",le=\"+Inf\"} 3",
+		"test_seconds_sum{kind=\"x\"} 5.55",
+		"test_seconds_count{kind=\"x\"} 3",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Error("Expected content not found:\n", expected, "\ngot\n", buf.String())
+		}
+	}
+}
+
+func Test_SanitizeName(t *testing.T) {
+	cases := map[string]string{
+		"used_memory":        "used_memory",
+		"cmdstat_config|get": "cmdstat_config_get",
+		"0abc":               "_abc",
+	}
+	for input, expected := range cases {
+		if SanitizeName(input) != expected {
+			t.Error("Expecting\n", expected, "\ngot\n", SanitizeName(input))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"io"
+	"runtime"
+	"time"
+)
+
+var processStartTime = time.Now()
+
+// RuntimeCollector exposes Go runtime metrics (goroutines, memory, GC) of the current process
+type RuntimeCollector struct{}
+
+// Collect implements Collector
+func (RuntimeCollector) Collect(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	gauges := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine())},
+		{"go_threads", "Number of OS threads created.", float64(threadCount())},
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(ms.Alloc)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(ms.Sys)},
+		{"go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(ms.HeapAlloc)},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(ms.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", float64(ms.HeapObjects)},
+		{"go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", float64(ms.StackInuse)},
+		{"go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(ms.NextGC)},
+		{"process_start_time_seconds", "Start time of the process since unix epoch in seconds.", float64(processStartTime.Unix())},
+	}
+	for _, g := range gauges {
+		WriteHeader(w, g.name, g.help, "gauge")
+		WriteSample(w, g.name, nil, nil, g.value)
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(ms.TotalAlloc)},
+		{"go_memstats_mallocs_total", "Total number of mallocs.", float64(ms.Mallocs)},
+		{"go_memstats_frees_total", "Total number of frees.", float64(ms.Frees)},
+		{"go_gc_cycles_total", "Number of completed GC cycles.", float64(ms.NumGC)},
+		{"go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", float64(ms.PauseTotalNs) / 1e9},
+	}
+	for _, c := range counters {
+		WriteHeader(w, c.name, c.help, "counter")
+		WriteSample(w, c.name, nil, nil, c.value)
+	}
+
+	WriteHeader(w, "go_info", "Information about the Go environment.", "gauge")
+	WriteSample(w, "go_info", []string{"version"}, []string{runtime.Version()}, 1)
+}
+
+func threadCount() int {
+	n, _ := runtime.ThreadCreateProfile(nil)
+	return n
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/format"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// rows are flushed to the client every flushEveryRows rows, when streamed
+const flushEveryRows = 1000
+
+// negotiateFormat picks the format of the response, from parameter format= or header Accept.
+// If format= is not supported, it responds 400 and ok is false. Errors are always given as JSON
+func negotiateFormat(res *responseRecorder, req *http.Request) (f string, ok bool) {
+	res.Header().Add("Vary", "Accept")
+	f, err := format.Negotiate(req.Header.Get("Accept"), req.URL.Query().Get("format"))
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		js, _ := json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return "", false
+	}
+	return f, true
+}
+
+// writeEncoded writes v as a single YAML or MessagePack document
+func writeEncoded(res *responseRecorder, f string, v interface{}) {
+	var encoded []byte
+	var err error
+	if f == format.YAML {
+		encoded, err = format.EncodeYAML(v)
+	} else {
+		encoded, err = format.EncodeMessagePack(v)
+	}
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		js, _ := json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return
+	}
+	res.Header().Set("Content-Type", format.ContentType(f))
+	res.Write(encoded)
+}
+
+// isRowFormat tells if the format writes one row per element (of a list, set, hash, sorted set or stream, or of keys)
+func isRowFormat(f string) bool {
+	return f == format.NDJSON || f == format.CSV
+}
+
+// rowWriter writes elements as NDJSON lines or CSV rows, or as the JSON value of a key, as they come.
+// Nothing is written until the first element, so that errors happening before can still be given as JSON
+type rowWriter struct {
+	res     *responseRecorder
+	format  string
+	header  []string // CSV only
+	keyType string   // JSON only
+	csv     *csv.Writer
+	rows    int
+}
+
+func newRowWriter(res *responseRecorder, f string, header ...string) *rowWriter {
+	return &rowWriter{res: res, format: f, header: header}
+}
+
+// newValueWriter returns the rowWriter for the elements of a key of the type given (list, set, hash, sorted set or stream)
+func newValueWriter(res *responseRecorder, f string, keyType string) *rowWriter {
+	return &rowWriter{res: res, format: f, header: rowHeaders[keyType], keyType: keyType}
+}
+
+func (w *rowWriter) start() {
+	w.res.Header().Set("Content-Type", format.ContentType(w.format))
+	switch w.format {
+	case format.CSV:
+		w.csv = csv.NewWriter(w.res)
+		w.csv.Write(w.header)
+	case format.JSON:
+		// same as types.ResponseType
+		keyType, _ := json.Marshal(w.keyType)
+		opening := "["
+		if w.keyType == "hash" {
+			opening = "{"
+		}
+		w.res.Write([]byte(`{"type":` + string(keyType) + `,"value":` + opening))
+	}
+}
+
+func (w *rowWriter) write(element interface{}) error {
+	if w.rows == 0 {
+		w.start()
+	}
+	w.rows++
+
+	var err error
+	switch w.format {
+	case format.NDJSON:
+		js, _ := json.Marshal(element)
+		_, err = w.res.Write(append(js, '\n'))
+	case format.CSV:
+		err = w.csv.Write(csvRow(element))
+	case format.JSON:
+		var js []byte
+		if w.rows > 1 {
+			js = append(js, ',')
+		}
+		switch e := element.(type) {
+		case types.HashEntryType:
+			field, _ := json.Marshal(e.Field)
+			value, _ := json.Marshal(e.Value)
+			js = append(append(append(js, field...), ':'), value...)
+		case types.SortedSetEntryType:
+			// like ZRANGE without scores
+			member, _ := json.Marshal(e.Member)
+			js = append(js, member...)
+		default:
+			value, _ := json.Marshal(e)
+			js = append(js, value...)
+		}
+		_, err = w.res.Write(js)
+	}
+
+	if w.rows%flushEveryRows == 0 {
+		w.flush()
+	}
+	return err
+}
+
+// close flushes the rows. If no row is written, the response is still in the format (i.e. the CSV header only)
+func (w *rowWriter) close() {
+	if w.rows == 0 {
+		w.start()
+	}
+	if w.format == format.JSON {
+		closing := "]}"
+		if w.keyType == "hash" {
+			closing = "}}"
+		}
+		w.res.Write([]byte(closing))
+	}
+	w.flush()
+}
+
+func (w *rowWriter) flush() {
+	if w.csv != nil {
+		w.csv.Flush()
+	}
+	w.res.Flush()
+}
+
+func csvRow(element interface{}) []string {
+	switch e := element.(type) {
+	case string:
+		return []string{e}
+	case bool:
+		return []string{strconv.FormatBool(e)}
+	case int64:
+		return []string{strconv.FormatInt(e, 10)}
+	case types.KeyInfoType:
+		return []string{e.Key, e.Type}
+	case types.HashEntryType:
+		return []string{e.Field, e.Value}
+	case types.SortedSetEntryType:
+		return []string{e.Member, strconv.FormatFloat(e.Score, 'g', -1, 64)}
+	case types.StreamEntryType:
+		fields, _ := json.Marshal(e.Fields)
+		return []string{e.ID, string(fields)}
+	case types.InfoEntryType:
+		return []string{e.Section, e.Field, e.Value}
+	default:
+		return []string{fmt.Sprint(e)}
+	}
+}
+
+// rowHeaders are the CSV headers of the elements of each Redis type
+var rowHeaders = map[string][]string{
+	"list":   {"value"},
+	"set":    {"member"},
+	"hash":   {"field", "value"},
+	"zset":   {"member", "score"},
+	"stream": {"id", "fields"},
+}
+
+// writeKeyList writes the keys of /<db> in the format given (other than JSON)
+func writeKeyList(res *responseRecorder, f string, keys types.KeyListType) {
+	if !isRowFormat(f) {
+		writeEncoded(res, f, keys)
+		return
+	}
+	rows := newRowWriter(res, f, "key", "type")
+	for _, k := range keys.Keys {
+		rows.write(k)
+	}
+	rows.close()
+}
+
+// writeValue writes the value of a key (or its element) in the format given. In JSON, NDJSON and CSV, lists, sets, streams,
+// hashes and sorted sets are read from Redis chunk by chunk and streamed, so that memory stays bounded whatever
+// their size. Other values are read at once. Values are redacted by redactor (which may be nil).
+// It returns true if the value is written successfully (and whole)
+func writeValue(res *responseRecorder, req *http.Request, client *conn.ExtendedClient, f string, key string, field string,
+	redactor *conn.Redactor) bool {
+	var js []byte
+
+	if field == "" && (f == format.JSON || isRowFormat(f)) {
+		keyType, err := client.RedisClient.Type(req.Context(), key).Result()
+		if err != nil {
+			redisErrorsTotal.Inc(res.endpoint)
+			res.WriteHeader(http.StatusInternalServerError)
+			js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+			res.Write(js)
+			return false
+		}
+		if _, isCollection := rowHeaders[keyType]; isCollection {
+			rows := newValueWriter(res, f, keyType)
+			err = client.ScanValue(req.Context(), key, keyType, redactor.Emit(keyType, rows.write))
+			if err == errNotModified {
+				return false
+			}
+			if err != nil && rows.rows == 0 {
+				redisErrorsTotal.Inc(res.endpoint)
+				res.WriteHeader(http.StatusInternalServerError)
+				js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+				res.Write(js)
+				return false
+			}
+			if err != nil {
+				// the status is already sent, so the response can only be cut short
+				redisErrorsTotal.Inc(res.endpoint)
+				res.log.Error("Failed to stream value. Details: " + err.Error())
+				res.Flush()
+				return false
+			}
+			rows.close()
+			return true
+		}
+	}
+
+	if f == format.JSON {
+		js, errorCode := client.Retrieve(req.Context(), key, field, redactor)
+		if errorCode != 0 {
+			res.WriteHeader(errorCode)
+		}
+		res.Write(js)
+		return errorCode == 0
+	}
+
+	value, errorCode, err := client.RetrieveValue(req.Context(), key, field, redactor)
+	if err != nil {
+		res.WriteHeader(errorCode)
+		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return false
+	}
+	if !isRowFormat(f) {
+		writeEncoded(res, f, value)
+		return true
+	}
+	// strings, and elements given by index or field, are a single row
+	rows := newRowWriter(res, f, "value")
+	rows.write(value.Value)
+	rows.close()
+	return true
+}
+
+// writeInfo writes Redis INFO (as JSON given by conn.RedisInfo) in the format given (other than JSON).
+// In NDJSON and CSV, every field is a row, ordered by section and field
+func writeInfo(res *responseRecorder, f string, js []byte) {
+	var info map[string]map[string]string
+	json.Unmarshal(js, &info)
+	if !isRowFormat(f) {
+		writeEncoded(res, f, info)
+		return
+	}
+
+	var sections []string
+	for section := range info {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	rows := newRowWriter(res, f, "section", "field", "value")
+	for _, section := range sections {
+		var fields []string
+		for field := range info[section] {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			rows.write(types.InfoEntryType{Section: section, Field: field, Value: info[section][field]})
+		}
+	}
+	rows.close()
+}
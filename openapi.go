@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/xd-deng/rediseen/format"
+	"github.com/xd-deng/rediseen/types"
+)
+
+const openAPIVersion = "3.0.3"
+
+// schema is a JSON Schema object (as used by OpenAPI), or any other object of the OpenAPI document
+type schema map[string]interface{}
+
+// componentSchemas are the named schemas in components/schemas, registered by schemaOf and valueSchema
+var componentSchemas = schema{}
+
+var errorSchema = schemaOf(types.ErrorType{})
+
+var infoSchema = schema{"type": "object", "description": "Sections of Redis INFO, each of which maps field names to values",
+	"additionalProperties": schema{"type": "object", "additionalProperties": schema{"type": "string"}}}
+
+// schemaOf returns the schema of the value given. Structs from package types are registered as components,
+// so that the document is derived from the same types used to write responses
+func schemaOf(v interface{}) schema {
+	return schemaOfType(reflect.TypeOf(v))
+}
+
+func schemaOfType(t reflect.Type) schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOfType(t.Elem())
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Uint, reflect.Uint32:
+		return schema{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return schema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": schemaOfType(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": schemaOfType(t.Elem())}
+	case reflect.Struct:
+		name := strings.TrimSuffix(t.Name(), "Type")
+		if _, ok := componentSchemas[name]; !ok {
+			componentSchemas[name] = structSchema(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + name}
+	default:
+		// interface{}: any value
+		return schema{}
+	}
+}
+
+func structSchema(t reflect.Type) schema {
+	properties := schema{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.Anonymous && field.Tag.Get("json") == "" {
+			// fields of embedded structs are promoted (and are absent if the pointer is nil)
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			for name, property := range structSchema(embedded)["properties"].(schema) {
+				properties[name] = property
+			}
+			continue
+		}
+		name, omitEmpty := jsonFieldName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		properties[name] = schemaOfType(t.Field(i).Type)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+	result := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		result["required"] = required
+	}
+	return result
+}
+
+// jsonFieldName returns the name of the field in JSON, or "" if it is not encoded
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := strings.Split(field.Tag.Get("json"), ",")
+	if tag[0] == "-" {
+		return "", false
+	}
+	name := tag[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, option := range tag[1:] {
+		omitEmpty = omitEmpty || option == "omitempty"
+	}
+	return name, omitEmpty
+}
+
+// redisTypeShapes describe the shape of `value` in types.ResponseType, for each Redis type supported
+var redisTypeShapes = []struct {
+	redisType string
+	name      string
+	value     schema // for /<db>/<key>
+	element   schema // for /<db>/<key>/<index or field>, nil if not supported
+}{
+	{"string", "String",
+		schema{"type": "string"},
+		schema{"type": "string", "description": "Character at the index"}},
+	{"list", "List",
+		schema{"type": "array", "items": schema{"type": "string"}},
+		schema{"type": "string", "description": "Element at the index"}},
+	{"set", "Set",
+		schema{"type": "array", "items": schema{"type": "string"}, "uniqueItems": true},
+		schema{"type": "boolean", "description": "Whether the member exists"}},
+	{"hash", "Hash",
+		schema{"type": "object", "additionalProperties": schema{"type": "string"}},
+		schema{"type": "string", "description": "Value of the field"}},
+	{"zset", "SortedSet",
+		schema{"type": "array", "items": schema{"type": "string"}, "description": "Members, ordered by score"},
+		schema{"type": "integer", "description": "Rank of the member (0-based, ordered by score)"}},
+	{"stream", "Stream",
+		schema{"type": "array", "items": schemaOf(types.StreamEntryType{}), "description": "Entries, oldest first"},
+		nil},
+}
+
+// valueSchema returns the schema of types.ResponseType, as one of the shapes per Redis type
+func valueSchema(element bool) schema {
+	responseType := reflect.TypeOf(types.ResponseType{})
+	typeField, _ := responseType.FieldByName("ValueType")
+	valueField, _ := responseType.FieldByName("Value")
+	typeName, _ := jsonFieldName(typeField)
+	valueName, _ := jsonFieldName(valueField)
+
+	suffix := "Value"
+	if element {
+		suffix = "Element"
+	}
+
+	var oneOf []schema
+	mapping := schema{}
+	for _, shape := range redisTypeShapes {
+		value := shape.value
+		if element {
+			value = shape.element
+		}
+		if value == nil {
+			continue
+		}
+		name := shape.name + suffix
+		componentSchemas[name] = schema{
+			"type":     "object",
+			"required": []string{typeName, valueName},
+			"properties": schema{
+				typeName:  schema{"type": "string", "enum": []string{shape.redisType}},
+				valueName: value,
+			},
+		}
+		ref := "#/components/schemas/" + name
+		oneOf = append(oneOf, schema{"$ref": ref})
+		mapping[shape.redisType] = ref
+	}
+
+	return schema{"oneOf": oneOf, "discriminator": schema{"propertyName": typeName, "mapping": mapping}}
+}
+
+// openAPIDocument generates the OpenAPI document from the route table
+func openAPIDocument() schema {
+	paths := schema{}
+	for _, r := range routes {
+		var parameters []schema
+		for _, p := range r.parameters {
+			parameters = append(parameters, p.document())
+		}
+
+		if r.negotiated {
+			parameters = append(parameters, formatParameter.document())
+		}
+
+		responses := schema{
+			"401": schema{"description": "API key is not given or wrong (only if REDISEEN_API_KEY is set)",
+				"content": schema{"application/json": schema{"schema": errorSchema}}},
+			"429": schema{"description": "Too many requests (only if REDISEEN_RATE_LIMITS or REDISEEN_MAX_CONCURRENT_REQUESTS is set)",
+				"headers": schema{"Retry-After": schema{"description": "Seconds to wait before retrying",
+					"schema": schema{"type": "integer"}}},
+				"content": schema{"application/json": schema{"schema": errorSchema}}},
+		}
+		for _, res := range r.responses {
+			contentType := res.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			content := schema{contentType: schema{"schema": res.schema}}
+			if r.negotiated && res.status == http.StatusOK {
+				for _, f := range []string{format.YAML, format.MessagePack} {
+					content[format.ContentType(f)] = schema{"schema": res.schema}
+				}
+				for _, f := range []string{format.NDJSON, format.CSV} {
+					content[format.ContentType(f)] = schema{"schema": schema{"type": "string"}}
+				}
+			}
+			response := schema{"description": res.description}
+			if res.schema != nil {
+				response["content"] = content
+			}
+			responses[strconv.Itoa(res.status)] = response
+		}
+
+		methods := r.methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+		item := schema{}
+		for _, method := range methods {
+			operation := schema{
+				"operationId": operationID(method, r.path),
+				"summary":     r.summary,
+				"responses":   responses,
+			}
+			if r.description != "" {
+				operation["description"] = r.description
+			}
+			if len(parameters) > 0 {
+				operation["parameters"] = parameters
+			}
+			if method == http.MethodPost && r.requestBody != nil {
+				requestTypes := r.requestTypes
+				if len(requestTypes) == 0 {
+					requestTypes = []string{"application/json"}
+				}
+				content := schema{}
+				for _, requestType := range requestTypes {
+					content[requestType] = schema{"schema": r.requestBody}
+				}
+				operation["requestBody"] = schema{"required": true, "content": content}
+			}
+			item[strings.ToLower(method)] = operation
+		}
+		paths[r.path] = item
+	}
+
+	return schema{
+		"openapi": openAPIVersion,
+		"info": schema{
+			"title":       "Rediseen",
+			"version":     rediseenVersion,
+			"description": "REST-like API for Redis, exposing keys and values (read-only), Redis INFO and metrics.",
+		},
+		"paths": paths,
+		"components": schema{
+			"schemas": componentSchemas,
+			"securitySchemes": schema{
+				"ApiKeyAuth": schema{"type": "apiKey", "in": "header", "name": "X-API-KEY"},
+			},
+		},
+		// API key is only required if REDISEEN_API_KEY is set
+		"security": []schema{{}, {"ApiKeyAuth": []string{}}},
+	}
+}
+
+// document describes the parameter in the OpenAPI document
+func (p routeParameter) document() schema {
+	return schema{
+		"name":        p.name,
+		"in":          p.in,
+		"description": p.description,
+		"required":    p.in == "path",
+		"schema":      p.schema,
+	}
+}
+
+// operationID turns the method and paths like /{db}/{key} into getDbKey
+func operationID(method string, path string) string {
+	words := strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		words = []string{"root"}
+	}
+	id := strings.ToLower(method)
+	for _, w := range words {
+		id += strings.ToUpper(w[:1]) + w[1:]
+	}
+	return id
+}
+
+// marshalOpenAPIDocument returns the OpenAPI document as indented JSON
+func marshalOpenAPIDocument() []byte {
+	js, _ := json.MarshalIndent(openAPIDocument(), "", "  ")
+	return append(js, '\n')
+}
+
+// serveOpenAPI handles requests to /openapi.json
+func (c *service) serveOpenAPI(res *responseRecorder, req *http.Request, params []string) {
+	res.Write(marshalOpenAPIDocument())
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/types"
+)
+
+const maxPublishBodyBytes = 1 << 20
+
+// publishContentTypes are the content types accepted by POST /_publish/<channel>. Unlike text/plain or form data,
+// web pages can not send them cross-origin without a CORS preflight
+var publishContentTypes = []string{"application/json", "application/octet-stream"}
+
+// publishMessage publishes the message to the channel, giving the number of clients which received it.
+// It is replaced in tests
+var publishMessage = func(ctx context.Context, channel string, message string) (int64, error) {
+	var client conn.ExtendedClient
+	client.Init(0)
+	defer client.RedisClient.Close()
+	return client.RedisClient.Publish(ctx, channel, message).Result()
+}
+
+// servePublish handles POST /_publish/<channel>, which publishes the request body to the channel (only if it matches
+// REDISEEN_CHANNEL_PATTERN_PUBLISHABLE), for producers which can only make HTTP calls
+func (c *service) servePublish(res *responseRecorder, req *http.Request, params []string) {
+	var js []byte
+
+	// audited whether published or not (see auditDataAccess)
+	res.channel = params[0]
+	pubsub := c.pubsub
+	if pubsub == nil || pubsub.publishable == nil {
+		res.WriteHeader(http.StatusNotFound)
+		js, _ = json.Marshal(types.ErrorType{Error: "Publishing is not enabled"})
+		res.Write(js)
+		return
+	}
+	// web pages of other origins must be allowed by CORS, since browsers may send their requests with cookies
+	if !c.allowsOrigin(req) {
+		res.WriteHeader(http.StatusForbidden)
+		js, _ = json.Marshal(types.ErrorType{Error: "Origin is not allowed"})
+		res.Write(js)
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || !containsString(publishContentTypes, mediaType) {
+		res.WriteHeader(http.StatusUnsupportedMediaType)
+		js, _ = json.Marshal(types.ErrorType{Error: "Content-Type should be " + strings.Join(publishContentTypes, " or ")})
+		res.Write(js)
+		return
+	}
+	channel := params[0]
+	if !pubsub.allowsPublishing(channel) {
+		res.WriteHeader(http.StatusForbidden)
+		js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("Channel `%s` can not be published to", channel)})
+		res.Write(js)
+		return
+	}
+
+	message, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, maxPublishBodyBytes))
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			res.WriteHeader(http.StatusRequestEntityTooLarge)
+			js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("Request body should be no larger than %d bytes", maxPublishBodyBytes)})
+		} else {
+			res.WriteHeader(http.StatusBadRequest)
+			js, _ = json.Marshal(types.ErrorType{Error: "Request body can not be read"})
+		}
+		res.Write(js)
+		return
+	}
+
+	res.log.Debug("Publish message", "channel", channel, "bytes", len(message))
+	receivers, err := publishMessage(req.Context(), channel, string(message))
+	if err != nil {
+		redisErrorsTotal.Inc(res.endpoint)
+		res.WriteHeader(http.StatusInternalServerError)
+		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return
+	}
+	pubsubMessagesTotal.Inc(pubsubPublished)
+	res.receivers = &receivers
+
+	js, _ = json.Marshal(types.PublishResponseType{Channel: channel, Receivers: receivers})
+	res.Write(js)
+}
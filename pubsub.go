@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/logging"
+	"github.com/xd-deng/rediseen/types"
+)
+
+const (
+	defaultMaxPubSubConnections = 100
+	// defaultMaxPubSubPending is the number of messages queued per connection by default. Connections whose clients
+	// fall further behind are closed
+	defaultMaxPubSubPending = 256
+	// maxPubSubSubscriptions is the number of channels and patterns a connection can subscribe to
+	maxPubSubSubscriptions = 100
+	pubsubPingInterval     = 30 * time.Second
+	pubsubWriteTimeout     = 10 * time.Second
+	// maxPubSubRequestBytes is the size limit of requests sent over WebSockets
+	maxPubSubRequestBytes = 64 << 10
+	// maxPubSubRateLimitViolations is the number of publish actions in a row rejected by rate limits, after which
+	// the connection is closed
+	maxPubSubRateLimitViolations = 10
+	// pubsubProtocol is the WebSocket subprotocol selected, if offered
+	pubsubProtocol = "rediseen"
+	// apiKeyProtocolPrefix prefixes the API key (base64url-encoded, without padding) given as WebSocket subprotocol,
+	// since browsers can not send X-API-KEY when opening WebSockets
+	apiKeyProtocolPrefix = "rediseen.api-key."
+)
+
+// Directions of Pub/Sub messages, in metrics
+const (
+	pubsubDelivered = "delivered"
+	pubsubPublished = "published"
+)
+
+// pubsubConfig tells which channels clients of /_pubsub can subscribe and publish to
+type pubsubConfig struct {
+	exposed        *regexp.Regexp // nil if no channel can be subscribed to
+	publishable    *regexp.Regexp // nil if no channel can be published to
+	maxConnections int32
+	maxPending     int
+	connections    int32 // accessed atomically
+}
+
+// configurePubSub applies REDISEEN_CHANNEL_PATTERN_* and REDISEEN_PUBSUB_* environment variables. Pub/Sub is
+// disabled unless channels are exposed or publishable
+func (c *service) configurePubSub() error {
+	c.pubsub = nil
+	configExposed := os.Getenv("REDISEEN_CHANNEL_PATTERN_EXPOSED")
+	configPublishable := os.Getenv("REDISEEN_CHANNEL_PATTERN_PUBLISHABLE")
+
+	maxConnections, err := intFromEnv("REDISEEN_PUBSUB_MAX_CONNECTIONS", defaultMaxPubSubConnections)
+	if err != nil {
+		return err
+	}
+	maxPending, err := intFromEnv("REDISEEN_PUBSUB_MAX_PENDING", defaultMaxPubSubPending)
+	if err != nil {
+		return err
+	}
+	if configExposed == "" && configPublishable == "" {
+		return nil
+	}
+	if maxConnections == 0 || maxPending == 0 {
+		return errors.New("REDISEEN_PUBSUB_MAX_CONNECTIONS and REDISEEN_PUBSUB_MAX_PENDING should be positive")
+	}
+
+	pubsub := &pubsubConfig{maxConnections: int32(maxConnections), maxPending: maxPending}
+	if configExposed != "" {
+		if pubsub.exposed, err = regexp.Compile(configExposed); err != nil {
+			return fmt.Errorf("REDISEEN_CHANNEL_PATTERN_EXPOSED can not be compiled as regular expression (details: %s)", err.Error())
+		}
+		logger.Info(fmt.Sprintf("You are exposing Pub/Sub channels of pattern `%s` at /_pubsub", configExposed))
+	}
+	if configPublishable != "" {
+		if pubsub.publishable, err = regexp.Compile(configPublishable); err != nil {
+			return fmt.Errorf("REDISEEN_CHANNEL_PATTERN_PUBLISHABLE can not be compiled as regular expression (details: %s)", err.Error())
+		}
+		logger.Warn(fmt.Sprintf("You are allowing clients to publish to Pub/Sub channels of pattern `%s`", configPublishable))
+	}
+	c.pubsub = pubsub
+	return nil
+}
+
+// exposes tells if the channel can be subscribed to
+func (p *pubsubConfig) exposes(channel string) bool {
+	return p.exposed != nil && p.exposed.MatchString(channel)
+}
+
+// allowsPublishing tells if the channel can be published to
+func (p *pubsubConfig) allowsPublishing(channel string) bool {
+	return p.publishable != nil && p.publishable.MatchString(channel)
+}
+
+// acquire takes a connection slot, unless there are maxConnections already
+func (p *pubsubConfig) acquire() bool {
+	if atomic.AddInt32(&p.connections, 1) > p.maxConnections {
+		atomic.AddInt32(&p.connections, -1)
+		return false
+	}
+	pubsubConnections.Add(1)
+	return true
+}
+
+func (p *pubsubConfig) release() {
+	atomic.AddInt32(&p.connections, -1)
+	pubsubConnections.Add(-1)
+}
+
+// requestAPIKey gives the API key of the request, in header X-API-KEY or, when opening a WebSocket,
+// as subprotocol `rediseen.api-key.<base64url-encoded API key>`
+func requestAPIKey(req *http.Request) string {
+	if key := req.Header.Get("X-API-KEY"); key != "" || !websocket.IsWebSocketUpgrade(req) {
+		return key
+	}
+	for _, protocol := range websocket.Subprotocols(req) {
+		if strings.HasPrefix(protocol, apiKeyProtocolPrefix) {
+			key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(protocol, apiKeyProtocolPrefix))
+			if err == nil {
+				return string(key)
+			}
+		}
+	}
+	return ""
+}
+
+// allowsOrigin tells if the web page (if any) sending the request is of the same origin, or allowed by CORS
+func (c *service) allowsOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	return origin == "" || sameOrigin(origin, req) || (c.cors != nil && c.cors.allows(origin))
+}
+
+// sameOrigin tells if the origin given (of a web page opening a WebSocket) is the host of the request
+func sameOrigin(origin string, req *http.Request) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// channelSubscription is the Redis side of a connection to /_pubsub
+type channelSubscription interface {
+	Subscribe(ctx context.Context, channels ...string) error
+	PSubscribe(ctx context.Context, patterns ...string) error
+	Unsubscribe(ctx context.Context, channels ...string) error
+	PUnsubscribe(ctx context.Context, patterns ...string) error
+	// Channel gives the messages received, until closed
+	Channel() <-chan *redis.Message
+	Publish(ctx context.Context, channel string, message string) (receivers int64, err error)
+	Close() error
+}
+
+// redisSubscription subscribes on a dedicated connection of its own client
+type redisSubscription struct {
+	*redis.PubSub
+	client *conn.ExtendedClient
+}
+
+func (s *redisSubscription) Channel() <-chan *redis.Message {
+	return s.PubSub.Channel()
+}
+
+func (s *redisSubscription) Publish(ctx context.Context, channel string, message string) (int64, error) {
+	return s.client.RedisClient.Publish(ctx, channel, message).Result()
+}
+
+func (s *redisSubscription) Close() error {
+	err := s.PubSub.Close()
+	s.client.RedisClient.Close()
+	return err
+}
+
+// openSubscription opens the Redis side of a connection to /_pubsub. It is replaced in tests
+var openSubscription = func(ctx context.Context) channelSubscription {
+	client := &conn.ExtendedClient{}
+	client.Init(0)
+	return &redisSubscription{PubSub: client.RedisClient.Subscribe(ctx), client: client}
+}
+
+// servePubSub handles /_pubsub, which bridges a WebSocket to Pub/Sub channels of Redis. Clients send requests like
+// {"action": "subscribe", "channels": ["news"], "patterns": ["news.*"]} as text messages, and are sent messages
+// of the channels they subscribe to (only channels matching REDISEEN_CHANNEL_PATTERN_EXPOSED, even by pattern)
+func (c *service) servePubSub(res *responseRecorder, req *http.Request, params []string) {
+	var js []byte
+
+	pubsub := c.pubsub
+	if pubsub == nil {
+		res.WriteHeader(http.StatusNotFound)
+		js, _ = json.Marshal(types.ErrorType{Error: "Pub/Sub is not enabled"})
+		res.Write(js)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(req) {
+		res.Header().Set("Upgrade", "websocket")
+		res.WriteHeader(http.StatusUpgradeRequired)
+		js, _ = json.Marshal(types.ErrorType{Error: "Open a WebSocket to use Pub/Sub"})
+		res.Write(js)
+		return
+	}
+	// browsers let any web page open WebSockets (with cookies), so pages of other origins must be allowed by CORS
+	if !c.allowsOrigin(req) {
+		res.WriteHeader(http.StatusForbidden)
+		js, _ = json.Marshal(types.ErrorType{Error: "Origin is not allowed"})
+		res.Write(js)
+		return
+	}
+	if !pubsub.acquire() {
+		res.Header().Set("Retry-After", "60")
+		res.WriteHeader(http.StatusServiceUnavailable)
+		js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("Too many connections to Pub/Sub (no more than %d)", pubsub.maxConnections)})
+		res.Write(js)
+		return
+	}
+	defer pubsub.release()
+
+	// invalid handshakes are answered by pubsubUpgrader
+	ws, err := pubsubUpgrader.Upgrade(res, req, nil)
+	if err != nil {
+		res.log.Debug("WebSocket to Pub/Sub can not be opened", "details", err.Error())
+		return
+	}
+
+	s := &pubsubSession{
+		config:   pubsub,
+		ws:       ws,
+		sub:      openSubscription(req.Context()),
+		out:      make(chan []byte, pubsub.maxPending),
+		done:     make(chan struct{}),
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		log:      res.log,
+		// publishing shares the rate limits of POST /_publish/<channel>
+		rateLimited: func(ctx context.Context) (string, time.Duration) {
+			return c.rateLimited(ctx, res, endpointPublish)
+		},
+		// and is audited like it, as requested by the caller opening the WebSocket
+		audit: func(channel string, status int, receivers *int64) {
+			c.auditPublish(res, req, channel, status, receivers)
+		},
+	}
+	s.run(req.Context())
+}
+
+// pubsubUpgrader opens WebSockets to /_pubsub, selecting subprotocol pubsubProtocol if offered. Origins are checked
+// by servePubSub beforehand
+var pubsubUpgrader = websocket.Upgrader{
+	Subprotocols: []string{pubsubProtocol},
+	CheckOrigin:  func(req *http.Request) bool { return true },
+	Error: func(w http.ResponseWriter, req *http.Request, status int, reason error) {
+		w.WriteHeader(status)
+		js, _ := json.Marshal(types.ErrorType{Error: reason.Error()})
+		w.Write(js)
+	},
+}
+
+// pubsubSession is a WebSocket connected to /_pubsub
+type pubsubSession struct {
+	config    *pubsubConfig
+	ws        *websocket.Conn
+	sub       channelSubscription
+	out       chan []byte // messages pending, written in order by write
+	done      chan struct{}
+	closeOnce sync.Once
+	log       *logging.Logger
+	// rateLimited is checked for every publish action (see service.rateLimited)
+	rateLimited func(ctx context.Context) (rejectedBy string, retryAfter time.Duration)
+	// audit records every publish action, except those rejected by rate limits (see service.auditPublish)
+	audit func(channel string, status int, receivers *int64)
+
+	// accessed by run only
+	channels   map[string]bool
+	patterns   map[string]bool
+	violations int // publish actions in a row rejected by rate limits
+}
+
+// run serves requests of the client until the connection is closed
+func (s *pubsubSession) run(ctx context.Context) {
+	defer func() {
+		s.close(websocket.CloseNormalClosure, "")
+		close(s.done)
+		s.sub.Close()
+	}()
+	go s.forward()
+	go s.write()
+
+	// clients are disconnected if nothing (not even pongs) is received from them for two ping intervals
+	s.ws.SetReadLimit(maxPubSubRequestBytes)
+	s.ws.SetReadDeadline(time.Now().Add(2 * pubsubPingInterval))
+	s.ws.SetPongHandler(func(string) error {
+		return s.ws.SetReadDeadline(time.Now().Add(2 * pubsubPingInterval))
+	})
+	for {
+		messageType, data, err := s.ws.ReadMessage()
+		if err != nil {
+			s.log.Debug("WebSocket to Pub/Sub is closed", "details", err.Error())
+			return
+		}
+		s.ws.SetReadDeadline(time.Now().Add(2 * pubsubPingInterval))
+		var request types.PubSubRequestType
+		if messageType != websocket.TextMessage || json.Unmarshal(data, &request) != nil {
+			s.sendError("Send requests as JSON text messages, like {\"action\": \"subscribe\", \"channels\": [\"<channel>\"]}")
+			continue
+		}
+		s.handle(ctx, request)
+	}
+}
+
+// handle serves a request of the client
+func (s *pubsubSession) handle(ctx context.Context, request types.PubSubRequestType) {
+	switch request.Action {
+	case "subscribe":
+		if len(request.Channels) == 0 && len(request.Patterns) == 0 {
+			s.sendError("Provide channels and/or patterns to subscribe to")
+			return
+		}
+		if s.config.exposed == nil {
+			s.sendError("No channel is exposed")
+			return
+		}
+		for _, channel := range request.Channels {
+			if !s.config.exposes(channel) {
+				s.sendError(fmt.Sprintf("Channel `%s` is not exposed", channel))
+				return
+			}
+		}
+		if len(s.channels)+len(s.patterns)+len(request.Channels)+len(request.Patterns) > maxPubSubSubscriptions {
+			s.sendError(fmt.Sprintf("Subscribe to no more than %d channels and patterns", maxPubSubSubscriptions))
+			return
+		}
+		if err := s.subscribe(ctx, request.Channels, request.Patterns, true); err != nil {
+			redisErrorsTotal.Inc(endpointPubSub)
+			s.sendError(err.Error())
+			return
+		}
+		s.send(types.PubSubEventType{Type: "subscribed", Channels: request.Channels, Patterns: request.Patterns})
+	case "unsubscribe":
+		if len(request.Channels) == 0 && len(request.Patterns) == 0 {
+			s.sendError("Provide channels and/or patterns to unsubscribe from")
+			return
+		}
+		if err := s.subscribe(ctx, request.Channels, request.Patterns, false); err != nil {
+			redisErrorsTotal.Inc(endpointPubSub)
+			s.sendError(err.Error())
+			return
+		}
+		s.send(types.PubSubEventType{Type: "unsubscribed", Channels: request.Channels, Patterns: request.Patterns})
+	case "publish":
+		if !s.config.allowsPublishing(request.Channel) {
+			s.audit(request.Channel, http.StatusForbidden, nil)
+			s.sendError(fmt.Sprintf("Channel `%s` can not be published to", request.Channel))
+			return
+		}
+		if limit, retryAfter := s.rateLimited(ctx); limit != "" {
+			rateLimitedTotal.Inc(limit)
+			// clients ignoring rate limits persistently are disconnected
+			s.violations++
+			if s.violations >= maxPubSubRateLimitViolations {
+				s.close(websocket.ClosePolicyViolation, "Too many requests")
+				return
+			}
+			s.sendError(fmt.Sprintf("Too many requests (limit: %s), retry after %d second(s)", limit,
+				int(math.Max(1, math.Ceil(retryAfter.Seconds())))))
+			return
+		}
+		s.violations = 0
+		receivers, err := s.sub.Publish(ctx, request.Channel, request.Message)
+		if err != nil {
+			s.audit(request.Channel, http.StatusInternalServerError, nil)
+			redisErrorsTotal.Inc(endpointPubSub)
+			s.sendError(err.Error())
+			return
+		}
+		pubsubMessagesTotal.Inc(pubsubPublished)
+		s.audit(request.Channel, http.StatusOK, &receivers)
+		s.send(types.PubSubEventType{Type: "published", Channel: request.Channel, Receivers: &receivers})
+	default:
+		s.sendError("Provide action subscribe, unsubscribe or publish")
+	}
+}
+
+// subscribe subscribes to (or unsubscribes from) the channels and patterns given
+func (s *pubsubSession) subscribe(ctx context.Context, channels []string, patterns []string, on bool) error {
+	if len(channels) > 0 {
+		subscribe := s.sub.Unsubscribe
+		if on {
+			subscribe = s.sub.Subscribe
+		}
+		if err := subscribe(ctx, channels...); err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			if on {
+				s.channels[channel] = true
+			} else {
+				delete(s.channels, channel)
+			}
+		}
+	}
+	if len(patterns) > 0 {
+		subscribe := s.sub.PUnsubscribe
+		if on {
+			subscribe = s.sub.PSubscribe
+		}
+		if err := subscribe(ctx, patterns...); err != nil {
+			return err
+		}
+		for _, pattern := range patterns {
+			if on {
+				s.patterns[pattern] = true
+			} else {
+				delete(s.patterns, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// forward sends messages received from Redis to the client. Channels subscribed to by pattern are only
+// sent if they are exposed
+func (s *pubsubSession) forward() {
+	for m := range s.sub.Channel() {
+		if !s.config.exposes(m.Channel) {
+			continue
+		}
+		payload := m.Payload
+		pubsubMessagesTotal.Inc(pubsubDelivered)
+		s.send(types.PubSubEventType{Type: "message", Channel: m.Channel, Pattern: m.Pattern, Message: &payload})
+	}
+}
+
+func (s *pubsubSession) sendError(message string) {
+	s.send(types.PubSubEventType{Type: "error", Error: message})
+}
+
+// send queues the message for the client. If too many messages are pending, the connection is closed
+func (s *pubsubSession) send(event types.PubSubEventType) {
+	js, _ := json.Marshal(event)
+	select {
+	case s.out <- js:
+	default:
+		pubsubSlowClientsTotal.Inc()
+		s.close(websocket.ClosePolicyViolation, "Messages are not read fast enough")
+	}
+}
+
+// write writes the messages pending, and pings the client meanwhile
+func (s *pubsubSession) write() {
+	ticker := time.NewTicker(pubsubPingInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case js := <-s.out:
+			s.ws.SetWriteDeadline(time.Now().Add(pubsubWriteTimeout))
+			err = s.ws.WriteMessage(websocket.TextMessage, js)
+		case <-ticker.C:
+			err = s.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(pubsubWriteTimeout))
+		case <-s.done:
+			return
+		}
+		if err != nil {
+			s.ws.Close()
+			return
+		}
+	}
+}
+
+// close closes the connection (once), which finishes run. It may be called concurrently with write
+func (s *pubsubSession) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		s.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(pubsubWriteTimeout))
+		s.ws.Close()
+	})
+}
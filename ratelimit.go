@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/ratelimit"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// Buckets kept in Redis (REDISEEN_RATE_LIMIT_REDIS_URI) are named with rateLimitKeyPrefix
+const rateLimitKeyPrefix = "rediseen:ratelimit:"
+
+// Scopes of rate limits
+const (
+	rateLimitScopeIP     = "ip"
+	rateLimitScopeAPIKey = "api_key"
+)
+
+// limitConcurrency labels rejections by REDISEEN_MAX_CONCURRENT_REQUESTS in metrics
+const limitConcurrency = "concurrency"
+
+// redisEndpoints are endpoint kinds which talk to Redis, hence count towards REDISEEN_MAX_CONCURRENT_REQUESTS
+var redisEndpoints = map[string]bool{
+	endpointList:        true,
+	endpointKey:         true,
+	endpointField:       true,
+	endpointBatch:       true,
+	endpointInfo:        true,
+	endpointMetrics:     true,
+	endpointDiagnostics: true,
+	endpointDBs:         true,
+	endpointPublish:     true,
+}
+
+var rateLimitEndpoints = []string{endpointRoot, endpointList, endpointKey, endpointField, endpointBatch,
+	endpointInfo, endpointMetrics, endpointDiagnostics, endpointOpenAPI, endpointDBs, endpointUI, endpointEvents, endpointPubSub, endpointPublish}
+
+var rateLimitRulePattern = regexp.MustCompile(`^([a-z_]+)(?::([a-z]+))?=([0-9]+)/(s|m|h)(?:,([0-9]+))?$`)
+
+var rateLimitPeriods = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// rateLimitRule limits requests of each client IP (or of each API key), to all endpoint kinds or to one only
+type rateLimitRule struct {
+	name     string // like `ip` or `api_key:list`
+	scope    string
+	endpoint string // "" for all endpoint kinds
+	limit    ratelimit.Limit
+}
+
+// parseRateLimits parses REDISEEN_RATE_LIMITS, like `ip=20/s;ip:list=1/s,5;api_key=1000/m`:
+// semicolon-separated rules `<scope>[:<endpoint kind>]=<requests>/<s, m or h>[,<burst>]`
+func parseRateLimits(config string) ([]rateLimitRule, error) {
+	var rules []rateLimitRule
+	if config == "" {
+		return rules, nil
+	}
+
+	seen := map[string]bool{}
+	for _, raw := range strings.Split(config, ";") {
+		m := rateLimitRulePattern.FindStringSubmatch(strings.TrimSpace(raw))
+		if m == nil {
+			return nil, fmt.Errorf("rule `%s` should be like `<scope>[:<endpoint kind>]=<requests>/<s, m or h>[,<burst>]`", raw)
+		}
+		scope, endpoint := m[1], m[2]
+		if scope != rateLimitScopeIP && scope != rateLimitScopeAPIKey {
+			return nil, fmt.Errorf("unsupported scope `%s` (supported: %s, %s)", scope, rateLimitScopeIP, rateLimitScopeAPIKey)
+		}
+		if endpoint != "" && !containsString(rateLimitEndpoints, endpoint) {
+			return nil, fmt.Errorf("unsupported endpoint kind `%s` (supported: %s)", endpoint, strings.Join(rateLimitEndpoints, ", "))
+		}
+
+		requests, _ := strconv.Atoi(m[3])
+		burst := requests
+		if m[5] != "" {
+			burst, _ = strconv.Atoi(m[5])
+		}
+		if requests == 0 || burst == 0 {
+			return nil, fmt.Errorf("rule `%s` should allow at least 1 request", raw)
+		}
+
+		name := scope
+		if endpoint != "" {
+			name += ":" + endpoint
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("rule `%s` is given more than once", name)
+		}
+		seen[name] = true
+
+		rate := float64(requests) / rateLimitPeriods[m[4]].Seconds()
+		rules = append(rules, rateLimitRule{name: name, scope: scope, endpoint: endpoint,
+			limit: ratelimit.Limit{Rate: rate, Burst: burst}})
+	}
+	return rules, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// configureRateLimits applies REDISEEN_RATE_LIMITS, REDISEEN_MAX_CONCURRENT_REQUESTS and REDISEEN_RATE_LIMIT_REDIS_URI
+func (c *service) configureRateLimits() error {
+	if c.rateLimitClient != nil {
+		c.rateLimitClient.RedisClient.Close()
+		c.rateLimitClient = nil
+	}
+
+	var err error
+	c.rateLimits, err = parseRateLimits(os.Getenv("REDISEEN_RATE_LIMITS"))
+	if err != nil {
+		return fmt.Errorf("REDISEEN_RATE_LIMITS provided can not be parsed properly (details: %s)", err.Error())
+	}
+
+	maxConcurrent, err := intFromEnv("REDISEEN_MAX_CONCURRENT_REQUESTS", 0)
+	if err != nil {
+		return err
+	}
+	c.concurrency = nil
+	if maxConcurrent > 0 {
+		c.concurrency = make(chan struct{}, maxConcurrent)
+		logger.Info(fmt.Sprintf("Up to %d request(s) talking to Redis are served at the same time", maxConcurrent))
+	}
+
+	c.rateLimitStore = ratelimit.NewMemoryStore()
+	if uri := os.Getenv("REDISEEN_RATE_LIMIT_REDIS_URI"); uri != "" {
+		client := &conn.ExtendedClient{}
+		if err = client.InitFromURI(uri); err != nil {
+			return fmt.Errorf("REDISEEN_RATE_LIMIT_REDIS_URI is not valid (details: %s)", err.Error())
+		}
+		c.rateLimitClient = client
+		c.rateLimitStore = ratelimit.NewRedisStore(client.RedisClient, rateLimitKeyPrefix)
+	}
+
+	if len(c.rateLimits) > 0 {
+		store := "in memory"
+		if c.rateLimitClient != nil {
+			store = "in Redis, shared by replicas"
+		}
+		logger.Info(fmt.Sprintf("%d rate limit(s) are enforced (kept %s)", len(c.rateLimits), store))
+	}
+	return nil
+}
+
+// admit enforces rate limits, and the limit of concurrent requests talking to Redis. If the request is rejected,
+// it responds 429 and ok is false. Otherwise release must be called once the request is served
+func (c *service) admit(res *responseRecorder, req *http.Request, endpoint string) (release func(), ok bool) {
+	if rejectedBy, retryAfter := c.rateLimited(req.Context(), res, endpoint); rejectedBy != "" {
+		writeTooManyRequests(res, rejectedBy, retryAfter)
+		return nil, false
+	}
+
+	if !redisEndpoints[endpoint] {
+		return func() {}, true
+	}
+	semaphore := c.concurrency
+	if semaphore != nil {
+		select {
+		case semaphore <- struct{}{}:
+		default:
+			writeTooManyRequests(res, limitConcurrency, time.Second)
+			return nil, false
+		}
+	}
+	concurrentRequests.Add(1)
+	return func() {
+		concurrentRequests.Add(-1)
+		if semaphore != nil {
+			<-semaphore
+		}
+	}, true
+}
+
+// rateLimited takes a token of every rate limit of the endpoint kind given, for the caller of the request. If any
+// limit is exceeded, it gives the name of the limit (waiting the longest), and how long to wait
+func (c *service) rateLimited(ctx context.Context, res *responseRecorder, endpoint string) (rejectedBy string, retryAfter time.Duration) {
+	now := time.Now()
+	for _, rule := range c.rateLimits {
+		if rule.endpoint != "" && rule.endpoint != endpoint {
+			continue
+		}
+		subject := res.clientIP
+		if rule.scope == rateLimitScopeAPIKey {
+			// the fingerprint of the API key (see callerIdentity)
+			subject = res.caller
+		}
+		allowed, wait, err := c.rateLimitStore.Take(ctx, rule.name+"|"+subject, rule.limit, now)
+		if err != nil {
+			// failing open, since rate limits protect Redis, rather than guard the data
+			rateLimitStoreErrorsTotal.Inc()
+			res.log.Warn("Rate limit can not be checked, so the request is allowed. Details: " + err.Error())
+			continue
+		}
+		if !allowed && (rejectedBy == "" || wait > retryAfter) {
+			rejectedBy, retryAfter = rule.name, wait
+		}
+	}
+	return rejectedBy, retryAfter
+}
+
+// writeTooManyRequests responds 429, with Retry-After in seconds (at least 1)
+func writeTooManyRequests(res *responseRecorder, limit string, retryAfter time.Duration) {
+	rateLimitedTotal.Inc(limit)
+	res.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(retryAfter.Seconds())))))
+	res.WriteHeader(http.StatusTooManyRequests)
+	js, _ := json.Marshal(types.ErrorType{Error: fmt.Sprintf("Too many requests (limit: %s)", limit)})
+	res.Write(js)
+	res.log.Info("Too many requests", "limit", limit)
+}
@@ -0,0 +1,146 @@
+// Package ratelimit implements token buckets, kept either in memory or in Redis (so that several processes
+// share one limit). A bucket holds up to Burst tokens, refilled at Rate tokens per second, and every request
+// takes one token.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var errUnexpectedReply = errors.New("unexpected reply from rate limit script")
+
+// idle buckets are dropped from memory (once full again) every sweepInterval
+const sweepInterval = time.Minute
+
+// Limit of a bucket
+type Limit struct {
+	Rate  float64 // tokens per second
+	Burst int
+}
+
+// refillTime tells how long it takes to get n tokens
+func (l Limit) refillTime(n float64) time.Duration {
+	return time.Duration(math.Ceil(n / l.Rate * float64(time.Second)))
+}
+
+// Store keeps token buckets
+type Store interface {
+	// Take takes a token from the bucket given. If there is none, allowed is false, and retryAfter tells
+	// when there will be one
+	Take(ctx context.Context, bucket string, limit Limit, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore keeps buckets in memory. It is safe for concurrent use
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	limits    map[string]Limit
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, limits: map[string]Limit{}}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(ctx context.Context, name string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[name]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[name] = b
+		s.limits[name] = limit
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed.Seconds()*limit.Rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false, limit.refillTime(1 - b.tokens), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// sweep drops buckets which are full again, since they are the same as new ones
+func (s *MemoryStore) sweep(now time.Time) {
+	for name, b := range s.buckets {
+		limit := s.limits[name]
+		if now.Sub(b.last) >= limit.refillTime(float64(limit.Burst)-b.tokens) {
+			delete(s.buckets, name)
+			delete(s.limits, name)
+		}
+	}
+	s.lastSweep = now
+}
+
+// takeScript is the same as MemoryStore.Take. Buckets expire once they are full again.
+// The time is given by the caller, so that the script is deterministic (as needed by script replication)
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) / 1000 * rate)
+	last = now
+end
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HMSET", KEYS[1], "tokens", string.format("%.6f", tokens), "last", string.format("%d", last))
+redis.call("PEXPIRE", KEYS[1], string.format("%d", math.ceil((burst - tokens) / rate * 1000) + 1000))
+return {allowed, wait}
+`)
+
+// RedisStore keeps buckets in Redis, as hashes whose keys are the names of buckets with a prefix
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore returns a RedisStore using the client given
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Take implements Store
+func (s *RedisStore) Take(ctx context.Context, name string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	result, err := takeScript.Run(ctx, s.client, []string{s.prefix + name},
+		limit.Rate, limit.Burst, now.UnixNano()/int64(time.Millisecond)).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, errUnexpectedReply
+	}
+	allowed, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Burst: 3}
+	now := time.Unix(1600000000, 0)
+
+	// burst
+	for i := 0; i < 3; i++ {
+		if allowed, _, err := store.Take(ctx, "a", limit, now); !allowed || err != nil {
+			t.Fatal("Expecting token", i, "taken, got", allowed, err)
+		}
+	}
+	allowed, retryAfter, err := store.Take(ctx, "a", limit, now)
+	if allowed || err != nil || retryAfter != 500*time.Millisecond {
+		t.Error("Expecting no token, with retry after 500ms, got", allowed, retryAfter, err)
+	}
+
+	// other buckets are not affected
+	if allowed, _, _ := store.Take(ctx, "b", limit, now); !allowed {
+		t.Error("Expecting token of another bucket taken")
+	}
+
+	// refill
+	if allowed, _, _ := store.Take(ctx, "a", limit, now.Add(500*time.Millisecond)); !allowed {
+		t.Error("Expecting token taken once refilled")
+	}
+	if allowed, _, _ := store.Take(ctx, "a", limit, now.Add(600*time.Millisecond)); allowed {
+		t.Error("Expecting no token before refilled")
+	}
+	// never more than burst
+	for i := 0; i < 4; i++ {
+		allowed, _, _ = store.Take(ctx, "a", limit, now.Add(time.Hour))
+		if allowed != (i < 3) {
+			t.Error("Expecting token", i, "taken:", i < 3, "got", allowed)
+		}
+	}
+}
+
+func Test_MemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+
+	store := NewMemoryStore()
+	store.Take(context.Background(), "c", Limit{Rate: 1, Burst: 1}, time.Unix(1600000000, 0))
+	store.sweep(time.Unix(1600000000, 0).Add(time.Hour))
+	if len(store.buckets) != 0 || len(store.limits) != 0 {
+		t.Error("Expecting full buckets swept, got", len(store.buckets))
+	}
+}
+
+func Test_RedisStore(t *testing.T) {
+	mr, _ := miniredis.Run()
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	testStore(t, NewRedisStore(client, "rediseen:ratelimit:"))
+	if !mr.Exists("rediseen:ratelimit:a") {
+		t.Error("Expecting bucket kept in Redis")
+	}
+	if ttl := mr.TTL("rediseen:ratelimit:a"); ttl <= 0 {
+		t.Error("Expecting bucket to expire, got TTL", ttl)
+	}
+
+	mr.Close()
+	if _, _, err := NewRedisStore(client, "").Take(context.Background(), "a", Limit{Rate: 1, Burst: 1}, time.Now()); err == nil {
+		t.Error("Expecting error once Redis is gone")
+	}
+}
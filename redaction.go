@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xd-deng/rediseen/conn"
+)
+
+// Actions of redaction rules
+const (
+	redactionMask = "mask"
+	redactionDrop = "drop"
+)
+
+// parseRedactionRules parses REDISEEN_REDACTION_RULES, like `mask ^user:[0-9]+$ ^(password|ssn)$;drop ^session:`:
+// semicolon-separated rules `<mask or drop> <key pattern> [<field pattern>]`, where patterns are regular expressions.
+// Without field pattern, the rule applies to the whole value
+func parseRedactionRules(config string) ([]conn.Redaction, error) {
+	var rules []conn.Redaction
+	if config == "" {
+		return rules, nil
+	}
+
+	for _, raw := range strings.Split(config, ";") {
+		parts := strings.Fields(raw)
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("rule `%s` should be like `<mask or drop> <key pattern> [<field pattern>]`", raw)
+		}
+		if parts[0] != redactionMask && parts[0] != redactionDrop {
+			return nil, fmt.Errorf("unsupported action `%s` (supported: %s, %s)", parts[0], redactionMask, redactionDrop)
+		}
+
+		rule := conn.Redaction{Drop: parts[0] == redactionDrop}
+		var err error
+		rule.Key, err = regexp.Compile(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("key pattern `%s` can not be compiled as regular expression (details: %s)", parts[1], err.Error())
+		}
+		if len(parts) == 3 {
+			rule.Element, err = regexp.Compile(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("field pattern `%s` can not be compiled as regular expression (details: %s)", parts[2], err.Error())
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
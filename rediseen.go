@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
@@ -82,7 +81,7 @@ func main() {
 		Short: "Start Rediseen service",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Println(strHeader)
-			log.Println("[INFO] Daemon mode:", daemonMode)
+			logger.Info(fmt.Sprint("Daemon mode: ", daemonMode))
 
 			var s service
 			var pidFile = getPidFilePath()
@@ -93,7 +92,7 @@ func main() {
 				return
 			}
 
-			log.Printf("[INFO] Serving at %s", s.bindAddress)
+			logger.Info(fmt.Sprintf("Serving at %s", s.bindAddress))
 
 			if daemonMode {
 				// check if daemon is already running
@@ -109,7 +108,7 @@ func main() {
 					fmt.Println("[ERROR] " + err.Error())
 					return
 				}
-				log.Println("[INFO] Running in daemon. PID:", cmd.Process.Pid)
+				logger.Info(fmt.Sprint("Running in daemon. PID: ", cmd.Process.Pid))
 				err = savePID(cmd.Process.Pid, pidFile)
 				if err != nil {
 					fmt.Println(err.Error())
@@ -118,11 +117,11 @@ func main() {
 				os.Exit(0)
 			}
 
-			http.Handle("/", &s)
-
-			serve := http.ListenAndServe(s.bindAddress, nil)
+			// The service is used as handler directly (instead of via http.ServeMux), so that paths are not cleaned:
+			// decoded /v2 paths may contain segments like `.` or `//` which are legitimate in keys
+			serve := http.ListenAndServe(s.bindAddress, &s)
 			if serve != nil {
-				log.Println("[ERROR] Failed to launch. Details: ", serve.Error())
+				logger.Error("Failed to launch. Details: " + serve.Error())
 			}
 		},
 	}
@@ -160,7 +159,44 @@ func main() {
 		},
 	}
 
+	var cmdAudit = &cobra.Command{
+		Use:   "audit",
+		Short: "Manage the audit log",
+	}
+
+	var cmdAuditVerify = &cobra.Command{
+		Use:   "verify [file...]",
+		Short: "Verify the hash chain of audit log file(s), given from the oldest to the latest",
+		Long: "Verify the hash chain of audit log file(s), given from the oldest to the latest.\n" +
+			"If no file is given, REDISEEN_AUDIT_FILE and its rotated backups are verified.",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := verifyAuditLog(args)
+			if err != nil {
+				fmt.Println("[ERROR] " + err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	cmdAudit.AddCommand(cmdAuditVerify)
+
+	var cmdOpenAPI = &cobra.Command{
+		Use:   "openapi [file]",
+		Short: "Write the OpenAPI specification (as served at /openapi.json) into a file, or to stdout if no file is given",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				os.Stdout.Write(marshalOpenAPIDocument())
+				return
+			}
+			err := ioutil.WriteFile(args[0], marshalOpenAPIDocument(), 0644)
+			if err != nil {
+				fmt.Println("[ERROR] " + err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+
 	var rootCmd = &cobra.Command{Use: "rediseen"}
-	rootCmd.AddCommand(cmdStart, cmdStop, cmdVersion, cmdConfigDoc)
+	rootCmd.AddCommand(cmdStart, cmdStop, cmdVersion, cmdConfigDoc, cmdAudit, cmdOpenAPI)
 	rootCmd.Execute()
 }
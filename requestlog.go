@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/xd-deng/rediseen/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+const maxRequestIDLength = 128
+
+// logger is shared by the whole service. Its format and level are set in loadConfigFromEnv
+var logger = logging.Default()
+
+// configureLogger applies REDISEEN_LOG_FORMAT and REDISEEN_LOG_LEVEL
+func configureLogger(configLogFormat string, configLogLevel string) error {
+	err := logging.ValidateFormat(configLogFormat)
+	if err != nil {
+		return err
+	}
+
+	level, err := logging.ParseLevel(configLogLevel)
+	if err != nil {
+		return err
+	}
+
+	logger.Configure(configLogFormat, level)
+	return nil
+}
+
+// requestID returns the request ID given by the client in header X-Request-ID if it is valid,
+// otherwise a newly generated one
+func requestID(req *http.Request) string {
+	id := req.Header.Get(requestIDHeader)
+	if id != "" && len(id) <= maxRequestIDLength && isPrintableASCII(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// logRequest writes the access log line of a finished request
+func (r *responseRecorder) logRequest(req *http.Request, elapsed time.Duration) {
+	keyValues := []interface{}{
+		"method", req.Method,
+		"path", req.URL.Path,
+		"client_ip", r.clientIP,
+		"remote_addr", req.RemoteAddr,
+		"user_agent", req.UserAgent(),
+		"endpoint", r.endpoint,
+		"status", r.status,
+		"latency_ms", float64(elapsed.Microseconds()) / 1000,
+		"bytes", r.bytes,
+	}
+	if r.db != "" {
+		keyValues = append(keyValues, "db", r.db)
+	}
+	if r.key != "" {
+		keyValues = append(keyValues, "key", r.key)
+	}
+	if r.field != "" {
+		keyValues = append(keyValues, "field", r.field)
+	}
+	if len(r.batch) > 0 {
+		keyValues = append(keyValues, "batch_size", len(r.batch))
+	}
+	r.log.Info("request completed", keyValues...)
+}
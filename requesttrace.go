@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/xd-deng/rediseen"
+
+// newSpanExporter creates the exporter sending spans to the OTLP/HTTP endpoint given. It is replaced in tests
+var newSpanExporter = func(endpoint *url.URL, headers map[string]string) (sdktrace.SpanExporter, error) {
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(endpoint.Path, "/") + "/v1/traces"),
+		otlptracehttp.WithHeaders(headers),
+	}
+	if endpoint.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), options...)
+}
+
+// configureTracing applies REDISEEN_TRACING_* environment variables. Tracing is disabled if
+// REDISEEN_TRACING_OTLP_ENDPOINT is not set
+func (c *service) configureTracing() error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if c.tracer != nil {
+		c.tracer.Shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		c.tracer = nil
+	}
+
+	endpoint := os.Getenv("REDISEEN_TRACING_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("REDISEEN_TRACING_OTLP_ENDPOINT should be like `http://<host>:4318`")
+	}
+
+	headers, err := parseTracingHeaders(os.Getenv("REDISEEN_TRACING_OTLP_HEADERS"))
+	if err != nil {
+		return err
+	}
+
+	sampleRatio := 1.0
+	if raw := os.Getenv("REDISEEN_TRACING_SAMPLE_RATIO"); raw != "" {
+		sampleRatio, err = strconv.ParseFloat(raw, 64)
+		if err != nil || sampleRatio < 0 || sampleRatio > 1 {
+			return errors.New("REDISEEN_TRACING_SAMPLE_RATIO should be a number between 0 and 1")
+		}
+	}
+
+	exporter, err := newSpanExporter(u, headers)
+	if err != nil {
+		return err
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("Failed to export traces. Details: " + err.Error())
+	}))
+	c.tracer = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		// the sampling decision of the client (if any) is respected
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "rediseen"),
+			attribute.String("service.version", rediseenVersion),
+		)),
+	)
+	otel.SetTracerProvider(c.tracer)
+	logger.Info(fmt.Sprintf("Traces are exported to %s (sample ratio: %v)", u.Host, sampleRatio))
+	return nil
+}
+
+// parseTracingHeaders parses semicolon-separated headers like `Authorization=Bearer xxx;X-Tenant=a`
+func parseTracingHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, h := range strings.Split(raw, ";") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		parts := strings.SplitN(h, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.New("REDISEEN_TRACING_OTLP_HEADERS should be like `<name>=<value>;<name>=<value>`")
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return headers, nil
+}
+
+// startServerSpan starts the span of a request, continuing the trace of the client given by W3C Trace Context
+// headers (if any)
+func startServerSpan(req *http.Request) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	return otel.Tracer(tracerName).Start(ctx, "HTTP "+req.Method, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// finishServerSpan names the span of a finished request after its route, records its outcome, and ends it
+func finishServerSpan(span trace.Span, res *responseRecorder, req *http.Request) {
+	// route templates (instead of paths) are used in span names, to keep their cardinality low
+	route := res.route
+	if route == "" {
+		route = "unknown"
+	}
+	span.SetName(req.Method + " " + route)
+	span.SetAttributes(
+		attribute.String("http.request.method", req.Method),
+		attribute.String("http.route", route),
+		attribute.String("url.path", req.URL.Path),
+		attribute.Int("http.response.status_code", res.status),
+		attribute.Int("http.response.body.size", res.bytes),
+		attribute.String("client.address", res.clientIP),
+		attribute.String("network.peer.address", req.RemoteAddr),
+		attribute.String("rediseen.request_id", res.Header().Get(requestIDHeader)),
+	)
+	if res.db != "" {
+		span.SetAttributes(attribute.String("db.namespace", res.db))
+	}
+	if res.status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(res.status))
+	}
+	span.End()
+}
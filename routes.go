@@ -0,0 +1,598 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xd-deng/rediseen/format"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// routeParameter describes a path or query parameter of a route
+type routeParameter struct {
+	name        string
+	in          string // "path" or "query"
+	description string
+	schema      schema
+}
+
+// routeResponse describes a possible response of a route
+type routeResponse struct {
+	status      int
+	description string
+	contentType string // "application/json" if empty
+	schema      schema
+}
+
+// route is an endpoint of the service. The route table drives both the dispatching in serve
+// and the OpenAPI document served at /openapi.json
+type route struct {
+	path         string // template like /{db}/{key}, where {...} matches any single path segment
+	endpoint     string // endpoint kind used in metrics and logs
+	summary      string
+	description  string
+	parameters   []routeParameter
+	requestBody  schema   // schema of the body, for POST
+	requestTypes []string // content types of the body, "application/json" only if empty
+	methods      []string // GET if empty
+	negotiated   bool     // whether the format of responses can be chosen (see negotiateFormat)
+	responses    []routeResponse
+	handle       func(c *service, res *responseRecorder, req *http.Request, params []string)
+	// target is set for routes to data, and parses what the request refers to. handle is derived from it
+	target func(req *http.Request, params []string) (dataTarget, error)
+
+	segments []string
+}
+
+const apiV2 = "v2"
+
+// routes is built in init, since some handlers refer to it
+var routes []*route
+
+func init() {
+	routes = []*route{
+		{
+			path:     "/",
+			endpoint: endpointRoot,
+			summary:  "Version and available endpoints, as plain text",
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Version and available endpoints", contentType: "text/plain", schema: schema{"type": "string"}},
+			},
+			handle: (*service).serveRoot,
+		},
+		{
+			path:     "/info",
+			endpoint: endpointInfo,
+			summary:  "Redis INFO (all sections)",
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Fields of Redis INFO, grouped by section", schema: infoSchema},
+				{status: http.StatusInternalServerError, description: "Failed to talk to Redis", schema: errorSchema},
+			},
+			negotiated: true,
+			handle:     (*service).serveInfo,
+		},
+		{
+			path:     "/info/{section}",
+			endpoint: endpointInfo,
+			summary:  "Redis INFO (one section)",
+			parameters: []routeParameter{
+				{name: "section", in: "path", description: "Section of Redis INFO (case-insensitive), like `server` or `memory`", schema: schema{"type": "string"}},
+			},
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Fields of the section of Redis INFO", schema: infoSchema},
+				{status: http.StatusBadRequest, description: "Invalid section", schema: errorSchema},
+				{status: http.StatusInternalServerError, description: "Failed to talk to Redis", schema: errorSchema},
+			},
+			negotiated: true,
+			handle:     (*service).serveInfo,
+		},
+		{
+			path:        "/metrics",
+			endpoint:    endpointMetrics,
+			summary:     "Metrics in Prometheus exposition format",
+			description: "Without `target`, metrics of REDISEEN_REDIS_URI and of Rediseen itself are returned. With `target`, only metrics of that Redis are returned.",
+			parameters: []routeParameter{
+				{name: "target", in: "query", description: "Address (`<host>:<port>`) of a Redis listed in REDISEEN_METRICS_TARGETS", schema: schema{"type": "string"}},
+			},
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Metrics", contentType: "text/plain; version=0.0.4", schema: schema{"type": "string"}},
+				{status: http.StatusForbidden, description: "Target is not allowed", schema: errorSchema},
+				{status: http.StatusInternalServerError, description: "Failed to connect to the target", schema: errorSchema},
+			},
+			handle: func(c *service, res *responseRecorder, req *http.Request, params []string) {
+				c.serveMetrics(res, req)
+			},
+		},
+		diagnosticRoute(diagnosticSlowlog, "", "Latest entries of the slow log (SLOWLOG GET)",
+			[]routeParameter{{name: "count", in: "query", description: "Number of entries to return",
+				schema: schema{"type": "integer", "minimum": 0, "default": defaultSlowlogCount}}},
+			schemaOf(types.SlowLogType{})),
+		diagnosticRoute(diagnosticLatency, "", "Latest latency spikes per event (LATENCY LATEST)",
+			nil, schema{"type": "array", "items": schemaOf(types.LatencyEventType{})}),
+		diagnosticRoute(diagnosticLatency, "event", "Latency spikes of an event (LATENCY HISTORY)",
+			[]routeParameter{{name: "event", in: "path", description: "Latency event, like `command`", schema: schema{"type": "string"}}},
+			schema{"type": "array", "items": schemaOf(types.LatencySampleType{})}),
+		diagnosticRoute(diagnosticClients, "", "Connected clients (CLIENT LIST)",
+			nil, schema{"type": "array", "items": schema{"type": "object", "additionalProperties": schema{"type": "string"}}}),
+		diagnosticRoute(diagnosticMemory, "", "Memory usage details (MEMORY STATS)",
+			nil, schema{"type": "object", "additionalProperties": true}),
+		diagnosticRoute(diagnosticConfig, "", "Configuration parameters listed in REDISEEN_CONFIG_PARAMS_EXPOSED (CONFIG GET)",
+			nil, schema{"type": "object", "additionalProperties": schema{"type": "string"}}),
+		{
+			path:        "/dbs",
+			endpoint:    endpointDBs,
+			summary:     "Exposed logical DBs, with their numbers of exposed keys if asked for",
+			description: "Keys are counted like /{db} lists them (hence by scanning the whole DB, which takes time for large DBs). If all DBs are exposed, they are the DBs of Redis (CONFIG GET databases, or 16 if not allowed).",
+			parameters: []routeParameter{
+				{name: "keys", in: "query", description: "Whether to count the exposed keys of each DB",
+					schema: schema{"type": "boolean", "default": false}},
+			},
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "DBs", schema: schemaOf(types.DBListType{})},
+				{status: http.StatusBadRequest, description: "Invalid query parameter keys", schema: errorSchema},
+				{status: http.StatusInternalServerError, description: "Failed to talk to Redis", schema: errorSchema},
+			},
+			handle: (*service).serveDBs,
+		},
+		{
+			path:        "/ui",
+			endpoint:    endpointUI,
+			summary:     "Web UI for browsing exposed keys (only if REDISEEN_UI_ENABLED is true)",
+			description: "Files of the UI are served without X-API-KEY, since they hold no data. The UI reads data from this API, with the API key given by users.",
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Page of the UI", contentType: "text/html", schema: schema{"type": "string"}},
+				{status: http.StatusNotFound, description: "UI is not enabled", schema: errorSchema},
+			},
+			handle: (*service).serveUI,
+		},
+		{
+			path:     "/ui/{file}",
+			endpoint: endpointUI,
+			summary:  "Scripts and styles of the web UI",
+			parameters: []routeParameter{
+				{name: "file", in: "path", description: "File of the UI, like `app.js`", schema: schema{"type": "string"}},
+			},
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "File of the UI", contentType: "text/javascript", schema: schema{"type": "string"}},
+				{status: http.StatusNotFound, description: "File does not exist, or UI is not enabled", schema: errorSchema},
+			},
+			handle: (*service).serveUI,
+		},
+		{
+			path:     "/_pubsub",
+			endpoint: endpointPubSub,
+			summary:  "WebSocket to Pub/Sub channels (only if REDISEEN_CHANNEL_PATTERN_EXPOSED or REDISEEN_CHANNEL_PATTERN_PUBLISHABLE is set)",
+			description: "Clients send requests as JSON text messages: {\"action\": \"subscribe\"} or {\"action\": \"unsubscribe\"} with `channels` " +
+				"and/or `patterns`, or {\"action\": \"publish\", \"channel\": \"<channel>\", \"message\": \"<message>\"}. They are sent messages of " +
+				"the channels subscribed to which match REDISEEN_CHANNEL_PATTERN_EXPOSED (even if subscribed to by pattern), and can only publish to " +
+				"channels matching REDISEEN_CHANNEL_PATTERN_PUBLISHABLE. Browsers can give the API key as subprotocol `rediseen.api-key.<base64url-encoded API key>`.",
+			parameters: []routeParameter{
+				{name: "Sec-WebSocket-Protocol", in: "header", description: "`rediseen`, and optionally the API key as `rediseen.api-key.<base64url-encoded API key>`", schema: schema{"type": "string"}},
+			},
+			responses: []routeResponse{
+				{status: http.StatusSwitchingProtocols, description: "WebSocket is opened. Messages sent to clients are like this schema", schema: schemaOf(types.PubSubEventType{})},
+				{status: http.StatusBadRequest, description: "Invalid WebSocket handshake", schema: errorSchema},
+				{status: http.StatusForbidden, description: "Origin is not allowed (see REDISEEN_CORS_ALLOWED_ORIGINS)", schema: errorSchema},
+				{status: http.StatusNotFound, description: "Pub/Sub is not enabled", schema: errorSchema},
+				{status: http.StatusUpgradeRequired, description: "Request does not open a WebSocket", schema: errorSchema},
+				{status: http.StatusServiceUnavailable, description: "Too many connections (REDISEEN_PUBSUB_MAX_CONNECTIONS)", schema: errorSchema},
+			},
+			handle: (*service).servePubSub,
+		},
+		{
+			path:     "/_publish/{channel}",
+			endpoint: endpointPublish,
+			summary:  "Publish the request body to a Pub/Sub channel (only if REDISEEN_CHANNEL_PATTERN_PUBLISHABLE is set)",
+			description: fmt.Sprintf("The body (no larger than %d bytes) is published as it is, to channels matching "+
+				"REDISEEN_CHANNEL_PATTERN_PUBLISHABLE only.", maxPublishBodyBytes),
+			parameters: []routeParameter{
+				{name: "channel", in: "path", description: "Name of the channel", schema: schema{"type": "string"}},
+			},
+			requestBody:  schema{"type": "string"},
+			requestTypes: publishContentTypes,
+			methods:      []string{http.MethodPost},
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Number of clients which received the message", schema: schemaOf(types.PublishResponseType{})},
+				{status: http.StatusForbidden, description: "Channel can not be published to, or origin is not allowed", schema: errorSchema},
+				{status: http.StatusNotFound, description: "Publishing is not enabled", schema: errorSchema},
+				{status: http.StatusRequestEntityTooLarge, description: "Request body is too large", schema: errorSchema},
+				{status: http.StatusUnsupportedMediaType, description: "Content-Type is neither application/json nor application/octet-stream", schema: errorSchema},
+				{status: http.StatusInternalServerError, description: "Failed to talk to Redis", schema: errorSchema},
+			},
+			handle: (*service).servePublish,
+		},
+		{
+			path:     "/openapi.json",
+			endpoint: endpointOpenAPI,
+			summary:  "This OpenAPI document",
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "OpenAPI 3 document", schema: schema{"type": "object"}},
+			},
+			handle: (*service).serveOpenAPI,
+		},
+		{
+			path:        "/{db}",
+			endpoint:    endpointList,
+			summary:     "List keys matching REDISEEN_KEY_PATTERN_EXPOSED, with their types",
+			description: "At most 1000 keys are returned.",
+			parameters:  []routeParameter{dbParameter},
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Keys", schema: schemaOf(types.KeyListType{})},
+				{status: http.StatusBadRequest, description: "DB is not an integer", schema: errorSchema},
+				{status: http.StatusForbidden, description: "DB is not exposed", schema: errorSchema},
+			},
+			target: v1DataTarget,
+		},
+		{
+			// before /{db}/{key}, so that _batch is not taken as a key (key `_batch` can still be read via /v2)
+			path:     "/{db}/_batch",
+			endpoint: endpointBatch,
+			summary:  "Values of many keys in one request",
+			description: fmt.Sprintf("Keys are given as repeated query parameter `key` (GET), or as JSON body (POST), "+
+				"in which index/field can be given as well. At most %d keys can be given. Every key is checked against "+
+				"REDISEEN_KEY_PATTERN_EXPOSED on its own, and the outcome is given per key (in the order of the request), "+
+				"as `status` together with `type` and `value` (like /{db}/{key}) or `error`. Keys exceeding size limits are given "+
+				"status 413.", maxBatchKeys),
+			parameters: []routeParameter{dbParameter,
+				{name: "key", in: "query", description: "Name of a key (GET only). Repeat it to read many keys", schema: schema{"type": "array", "items": schema{"type": "string"}}},
+			},
+			requestBody: schemaOf(types.BatchRequestType{}),
+			methods:     []string{http.MethodGet, http.MethodPost},
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Outcome per key", schema: schemaOf(types.BatchResponseType{})},
+				{status: http.StatusBadRequest, description: "DB is not an integer, or keys are not given properly", schema: errorSchema},
+				{status: http.StatusForbidden, description: "DB is not exposed", schema: errorSchema},
+				{status: http.StatusRequestEntityTooLarge, description: "Request body is too large", schema: errorSchema},
+			},
+			handle: (*service).serveBatch,
+		},
+		{
+			// before /{db}/{key} as well, like /{db}/_batch
+			path:     "/{db}/_events",
+			endpoint: endpointEvents,
+			summary:  "Keyspace events of exposed keys, as server-sent events (only if REDISEEN_EVENTS_ENABLED is true)",
+			description: "Keyspace notifications (which must be enabled in Redis with notify-keyspace-events `KA`) of keys matching " +
+				"REDISEEN_KEY_PATTERN_EXPOSED are streamed until the client disconnects, as events whose data is like " +
+				"{\"key\": \"<key>\", \"event\": \"set\"}. Clients reconnecting with header Last-Event-ID are given the events they missed, " +
+				"or event `reset` if they are not known any more (as well as when notifications may have been missed), after which keys should be read again.",
+			parameters: []routeParameter{dbParameter,
+				{name: "match", in: "query", description: "Glob-style pattern (like `user:*`) which keys must match as well", schema: schema{"type": "string"}},
+				{name: "values", in: "query", description: "Whether to give the values of keys as well (like /{db}/{key}), except for events removing keys", schema: schema{"type": "boolean", "default": false}},
+				{name: "Last-Event-ID", in: "header", description: "ID of the last event received, when reconnecting", schema: schema{"type": "string"}},
+			},
+			responses: []routeResponse{
+				{status: http.StatusOK, description: "Stream of events, whose data is given as JSON", contentType: "text/event-stream", schema: schemaOf(types.KeyEventType{})},
+				{status: http.StatusBadRequest, description: "DB is not an integer, or invalid parameter", schema: errorSchema},
+				{status: http.StatusForbidden, description: "DB is not exposed", schema: errorSchema},
+				{status: http.StatusNotFound, description: "Keyspace events are not enabled", schema: errorSchema},
+				{status: http.StatusServiceUnavailable, description: "Too many subscribers (REDISEEN_EVENTS_MAX_SUBSCRIBERS)", schema: errorSchema},
+			},
+			handle: (*service).serveEvents,
+		},
+		{
+			path:        "/{db}/{key}",
+			endpoint:    endpointKey,
+			summary:     "Value of a key",
+			description: "The shape of `value` depends on `type`: see the schemas of each Redis type.",
+			parameters:  append([]routeParameter{dbParameter, keyParameter}, pageParameters...),
+			responses: append([]routeResponse{
+				{status: http.StatusOK, description: "Value of the key, or a page of it", schema: valueSchema(false)},
+				tooLargeResponse,
+			}, keyResponses...),
+			target: v1DataTarget,
+		},
+		{
+			path:     "/{db}/{key}/{index_or_field}",
+			endpoint: endpointField,
+			summary:  "Element of a key, by index (string and list) or by field/member (hash, set and sorted set)",
+			description: "For string, the character at the index. For list, the element at the index. For hash, the value of the field. " +
+				"For set, whether the member exists. For sorted set, the rank of the member.",
+			parameters: []routeParameter{dbParameter, keyParameter,
+				{name: "index_or_field", in: "path", description: "Index (string and list), or field/member (hash, set and sorted set)", schema: schema{"type": "string"}},
+			},
+			responses: append([]routeResponse{
+				{status: http.StatusOK, description: "Element of the key", schema: valueSchema(true)},
+			}, keyResponses...),
+			target: v1DataTarget,
+		},
+		{
+			path:     "/v2/{db}",
+			endpoint: endpointList,
+			summary:  "List keys (like /{db}), or get the value of a key given as query parameters (like /{db}/{key}[/{index_or_field}])",
+			parameters: []routeParameter{dbParameter,
+				{name: "key", in: "query", description: "Name of the key, as is (query-encoded)", schema: schema{"type": "string"}},
+				{name: "field", in: "query", description: "Index (string and list), or field/member (hash, set and sorted set). Only valid together with key", schema: schema{"type": "string"}},
+				pageParameters[0], pageParameters[1],
+			},
+			responses: append([]routeResponse{
+				{status: http.StatusOK, description: "Keys, value of the key (or a page of it), or element of the key",
+					schema: schema{"oneOf": []schema{schemaOf(types.KeyListType{}), valueSchema(false), valueSchema(true)}}},
+				tooLargeResponse,
+			}, keyResponses...),
+			target: v2DataTarget,
+		},
+		{
+			path:        "/v2/{db}/{key}",
+			endpoint:    endpointKey,
+			summary:     "Value of a key (with the key percent-encoded)",
+			description: "The shape of `value` depends on `type`: see the schemas of each Redis type.",
+			parameters:  append([]routeParameter{dbParameter, keyParameterV2}, pageParameters...),
+			responses: append([]routeResponse{
+				{status: http.StatusOK, description: "Value of the key, or a page of it", schema: valueSchema(false)},
+				tooLargeResponse,
+			}, keyResponses...),
+			target: v2DataTarget,
+		},
+		{
+			path:     "/v2/{db}/{key}/{index_or_field}",
+			endpoint: endpointField,
+			summary:  "Element of a key (with the key and the index/field percent-encoded)",
+			parameters: []routeParameter{dbParameter, keyParameterV2,
+				{name: "index_or_field", in: "path", description: "Index (string and list), or field/member (hash, set and sorted set), percent-encoded", schema: schema{"type": "string"}},
+			},
+			responses: append([]routeResponse{
+				{status: http.StatusOK, description: "Element of the key", schema: valueSchema(true)},
+			}, keyResponses...),
+			target: v2DataTarget,
+		},
+	}
+
+	for _, r := range routes {
+		r.segments = strings.Split(strings.TrimPrefix(r.path, "/"), "/")
+		if r.target != nil {
+			r.handle = dataHandler(r.target)
+			r.negotiated = true
+		}
+		for _, res := range r.responses {
+			if res.status == http.StatusNotModified {
+				r.parameters = append(r.parameters, ifNoneMatchParameter)
+			}
+		}
+	}
+}
+
+var dbParameter = routeParameter{name: "db", in: "path", description: "Index of the logical database, listed in REDISEEN_DB_EXPOSED",
+	schema: schema{"type": "integer", "minimum": 0}}
+
+var keyParameter = routeParameter{name: "key", in: "path",
+	description: "Name of the key, matching REDISEEN_KEY_PATTERN_EXPOSED. Wrap it with backticks if it contains `/`, like /0/`a/b`/1",
+	schema:      schema{"type": "string"}}
+
+var keyParameterV2 = routeParameter{name: "key", in: "path",
+	description: "Name of the key, matching REDISEEN_KEY_PATTERN_EXPOSED, percent-encoded (e.g. `a/b` as `a%2Fb`)",
+	schema:      schema{"type": "string"}}
+
+var formatParameter = routeParameter{name: "format", in: "query",
+	description: "Format of the response, which takes precedence over header Accept. In ndjson and csv, " +
+		"every element (of lists, sets, hashes, sorted sets and streams), key or INFO field is a row. Errors are always given as JSON",
+	schema: schema{"type": "string", "enum": []string{format.JSON, format.NDJSON, format.CSV, format.YAML, format.MessagePack}, "default": format.JSON}}
+
+// pageParameters ask for a page of the value of a key, instead of the value whole
+var pageParameters = []routeParameter{
+	{name: "cursor", in: "query",
+		description: "Cursor of the page, given by the previous page as `cursor` (and header X-Next-Cursor), or 0 for the first page. " +
+			"It is 0 again once the last page is given",
+		schema: schema{"type": "string", "default": "0"}},
+	{name: "count", in: "query",
+		description: "Number of elements (of lists, sets, hashes, sorted sets and streams) or bytes (of strings) per page. " +
+			"Pages of sets and hashes may hold a few more elements",
+		schema: schema{"type": "integer", "minimum": 1, "default": defaultPageCount}},
+}
+
+// tooLargeResponse is given if the value of a key exceeds size limits (and no page is asked for)
+var tooLargeResponse = routeResponse{status: http.StatusRequestEntityTooLarge,
+	description: "Value exceeds REDISEEN_MAX_RESPONSE_BYTES, REDISEEN_MAX_ELEMENTS or REDISEEN_MAX_STRING_LENGTH. Read it page by page instead",
+	schema:      schemaOf(types.LimitErrorType{})}
+
+var ifNoneMatchParameter = routeParameter{name: "If-None-Match", in: "header",
+	description: "ETag of the response the client has already. If it matches, 304 is given instead",
+	schema:      schema{"type": "string"}}
+
+// keyResponses are the responses of routes to keys, other than 200
+var keyResponses = append([]routeResponse{
+	{status: http.StatusNotModified, description: "The response is not modified since the ETag given in If-None-Match"},
+}, keyErrorResponses...)
+
+var keyErrorResponses = []routeResponse{
+	{status: http.StatusBadRequest, description: "DB is not an integer, or index is given for types which only accept field (and vice versa)", schema: errorSchema},
+	{status: http.StatusForbidden, description: "DB is not exposed, or key does not match REDISEEN_KEY_PATTERN_EXPOSED (or matches REDISEEN_KEY_PATTERN_DENIED)", schema: errorSchema},
+	{status: http.StatusNotFound, description: "Key (or index/field) does not exist", schema: errorSchema},
+	{status: http.StatusInternalServerError, description: "Failed to talk to Redis", schema: errorSchema},
+	{status: http.StatusNotImplemented, description: "Type of the key is not supported", schema: errorSchema},
+}
+
+// diagnosticRoute describes /<name> (or /<name>/{<param>} if param is given)
+func diagnosticRoute(name string, param string, summary string, parameters []routeParameter, result schema) *route {
+	path := "/" + name
+	if param != "" {
+		path += "/{" + param + "}"
+	}
+	return &route{
+		path:        path,
+		endpoint:    endpointDiagnostics,
+		summary:     summary,
+		description: "Only available if `" + name + "` is in REDISEEN_DIAGNOSTICS_ENABLED.",
+		parameters:  parameters,
+		responses: []routeResponse{
+			{status: http.StatusOK, description: summary, schema: result},
+			{status: http.StatusBadRequest, description: "Invalid parameter", schema: errorSchema},
+			{status: http.StatusForbidden, description: "Endpoint is not enabled", schema: errorSchema},
+			{status: http.StatusInternalServerError, description: "Failed to talk to Redis", schema: errorSchema},
+		},
+		handle: func(c *service, res *responseRecorder, req *http.Request, params []string) {
+			var argument string
+			if len(params) > 0 {
+				argument = params[0]
+			}
+			c.serveDiagnostics(res, req, name, argument)
+		},
+	}
+}
+
+// allows tells if the route accepts the request method given
+func (r *route) allows(method string) bool {
+	if len(r.methods) == 0 {
+		return method == http.MethodGet
+	}
+	for _, m := range r.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// usage formats the path like /info/<section>
+func (r *route) usage() string {
+	return strings.NewReplacer("{", "<", "}", ">").Replace(r.path)
+}
+
+// pathSegments splits the path of the request into segments (without the leading slash).
+// For /v2 paths, the escaped path is split and then each segment is decoded, so that keys may contain `/`
+// (as %2F). segments is nil if a /v2 path can not be decoded
+func pathSegments(req *http.Request) (segments []string, isV2 bool) {
+	escaped := strings.Split(strings.TrimPrefix(req.URL.EscapedPath(), "/"), "/")
+	if escaped[0] != apiV2 {
+		return strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/"), false
+	}
+
+	segments = make([]string, len(escaped))
+	for i, e := range escaped {
+		s, err := url.PathUnescape(e)
+		if err != nil {
+			return nil, true
+		}
+		segments[i] = s
+	}
+	return segments, true
+}
+
+// matchRoute finds the route of the path segments given, and returns the values of its parameters.
+// Routes starting with a literal segment (like /info) take precedence over routes starting with a parameter
+// (like /{db}). If no route matches, it returns the usage of the routes which were candidates
+func matchRoute(segments []string) (*route, []string, string) {
+	var candidates []*route
+	for _, r := range routes {
+		if r.segments[0] == segments[0] {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, r := range routes {
+			if isRouteParameter(r.segments[0]) {
+				candidates = append(candidates, r)
+			}
+		}
+	}
+
+	var usages []string
+	for _, r := range candidates {
+		usages = append(usages, r.usage())
+		if len(r.segments) != len(segments) {
+			continue
+		}
+
+		var params []string
+		matched := true
+		for i, s := range r.segments {
+			if isRouteParameter(s) {
+				params = append(params, segments[i])
+			} else if s != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return r, params, ""
+		}
+	}
+	return nil, nil, strings.Join(usages, ", ")
+}
+
+func isRouteParameter(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// dataHandler serves routes to data, given how the route parses what the request refers to
+func dataHandler(target func(req *http.Request, params []string) (dataTarget, error)) func(*service, *responseRecorder, *http.Request, []string) {
+	return func(c *service, res *responseRecorder, req *http.Request, params []string) {
+		t, err := target(req, params)
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			js, _ := json.Marshal(types.ErrorType{Error: err.Error()})
+			res.Write(js)
+			return
+		}
+		c.serveData(res, req, t)
+	}
+}
+
+// v1DataTarget parses /<db>/<key>/<index or field>, where keys containing `/` are wrapped with backticks
+func v1DataTarget(req *http.Request, params []string) (dataTarget, error) {
+	t := dataTarget{db: params[0]}
+	switch len(params) {
+	case 2:
+		t.key = params[1]
+	case 3:
+		t.key, t.field = parseKeyAndIndex(strings.Join(params[1:], "/"))
+	}
+	return t, nil
+}
+
+// v2DataTarget parses /v2/<db>/<key>/<index or field> (whose segments are already decoded),
+// or /v2/<db>?key=<key>&field=<index or field>
+func v2DataTarget(req *http.Request, params []string) (dataTarget, error) {
+	t := dataTarget{db: params[0]}
+	query := req.URL.Query()
+
+	if len(params) == 1 {
+		t.key, t.field = query.Get("key"), query.Get("field")
+		if t.key == "" && t.field != "" {
+			return t, errors.New("Provide key together with field")
+		}
+		return t, nil
+	}
+
+	if query.Get("key") != "" || query.Get("field") != "" {
+		return t, errors.New("Provide key and field either in path or as query parameters, not both")
+	}
+	t.key = params[1]
+	if len(params) == 3 {
+		t.field = params[2]
+	}
+	if t.key == "" || (len(params) == 3 && t.field == "") {
+		return t, errors.New("Key and field in path can not be empty")
+	}
+	return t, nil
+}
+
+// requestDataTarget returns what the request refers to. ok is false if it is not a (valid) request to data
+func requestDataTarget(req *http.Request) (target dataTarget, ok bool) {
+	segments, _ := pathSegments(req)
+	if segments == nil {
+		return target, false
+	}
+	r, params, _ := matchRoute(segments)
+	if r == nil || r.target == nil {
+		return target, false
+	}
+	target, err := r.target(req, params)
+	return target, err == nil
+}
+
+// requestPublishChannel gives the channel a request publishes to, if it is POST /_publish/<channel>
+func requestPublishChannel(req *http.Request) string {
+	segments, _ := pathSegments(req)
+	if segments == nil || req.Method != http.MethodPost {
+		return ""
+	}
+	r, params, _ := matchRoute(segments)
+	if r == nil || r.endpoint != endpointPublish {
+		return ""
+	}
+	return params[0]
+}
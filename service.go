@@ -2,20 +2,24 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
+	"github.com/xd-deng/rediseen/audit"
+	"github.com/xd-deng/rediseen/cache"
 	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/format"
+	"github.com/xd-deng/rediseen/ratelimit"
 	"github.com/xd-deng/rediseen/types"
-	"log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"net"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type service struct {
@@ -31,10 +35,31 @@ type service struct {
 	authEnforced            bool
 	testMode                bool
 	regexpKeyPatternExposed *regexp.Regexp
+	exposure                *conn.Exposure         // keys exposed (allowed and not denied), and redaction of their values
+	dbExposures             map[int]*conn.Exposure // DBs with their own key patterns
+	metricsTargets          map[string]string
+	metricsTargetClients    targetClients
+	keyMetrics              *keyMetricsConfig
+	diagnosticsEnabled      map[string]bool
+	configParamsExposed     []string
+	auditor                 *audit.Logger
+	tracer                  *sdktrace.TracerProvider
+	compression             []string
+	cacheMaxAge             int
+	valueCache              *valueCache
+	rateLimits              []rateLimitRule
+	rateLimitStore          ratelimit.Store
+	rateLimitClient         *conn.ExtendedClient // set if buckets are kept in Redis
+	concurrency             chan struct{}        // semaphore of requests talking to Redis, nil if unlimited
+	sizeLimits              conn.SizeLimits
+	cors                    *corsConfig     // nil if CORS is disabled
+	ipAccess                *ipAccessConfig // nil if no IP address is allowed or denied specifically
+	trustedProxies          cidrList        // proxies whose X-Forwarded-For is trusted
+	uiEnabled               bool
+	events                  *eventHub     // nil if keyspace events are not served
+	pubsub                  *pubsubConfig // nil if Pub/Sub is disabled
 }
 
-var ctx = context.Background()
-
 func (c *service) loadConfigFromEnv() error {
 	c.port = os.Getenv("REDISEEN_PORT")
 	c.host = os.Getenv("REDISEEN_HOST")
@@ -44,6 +69,14 @@ func (c *service) loadConfigFromEnv() error {
 	c.keyPatternExposeAll = os.Getenv("REDISEEN_KEY_PATTERN_EXPOSE_ALL") == "true"
 	c.testMode = os.Getenv("REDISEEN_TEST_MODE") == "true"
 	c.apiKey = os.Getenv("REDISEEN_API_KEY")
+	configLogFormat := os.Getenv("REDISEEN_LOG_FORMAT")
+	configLogLevel := os.Getenv("REDISEEN_LOG_LEVEL")
+	configMetricsTargets := os.Getenv("REDISEEN_METRICS_TARGETS")
+	configKeyMetrics := os.Getenv("REDISEEN_KEY_METRICS_CONFIG")
+	configDiagnosticsEnabled := os.Getenv("REDISEEN_DIAGNOSTICS_ENABLED")
+	configParamsExposed := os.Getenv("REDISEEN_CONFIG_PARAMS_EXPOSED")
+	configCompression := os.Getenv("REDISEEN_COMPRESSION")
+	configCacheMaxAge := os.Getenv("REDISEEN_CACHE_MAX_AGE")
 
 	if c.host == "" {
 		c.host = defaultHost
@@ -59,6 +92,12 @@ func (c *service) loadConfigFromEnv() error {
 
 	var err error
 
+	err = configureLogger(configLogFormat, configLogLevel)
+	if err != nil {
+		return fmt.Errorf("Logging can not be configured (via environment variables REDISEEN_LOG_FORMAT "+
+			"and REDISEEN_LOG_LEVEL) (details: %s)", err.Error())
+	}
+
 	if c.redisURI == "" {
 		return errors.New("No valid Redis URI is provided (via environment variable REDISEEN_REDIS_URI)")
 	}
@@ -83,10 +122,114 @@ func (c *service) loadConfigFromEnv() error {
 
 	c.dbExposedMap = parseDbExposed(c.dbExposed)
 
+	c.metricsTargets = map[string]string{}
+	if configMetricsTargets != "" {
+		c.metricsTargets, err = parseMetricsTargets(configMetricsTargets)
+		if err != nil {
+			return fmt.Errorf("REDISEEN_METRICS_TARGETS provided can not be parsed properly (details: %s)", err.Error())
+		}
+		logger.Info(fmt.Sprintf("%d target(s) allowed for /metrics?target=<target>", len(c.metricsTargets)))
+	}
+
+	c.keyMetrics = nil
+	if configKeyMetrics != "" {
+		c.keyMetrics, err = loadKeyMetricsConfig(configKeyMetrics)
+		if err != nil {
+			return fmt.Errorf("REDISEEN_KEY_METRICS_CONFIG provided can not be loaded properly (details: %s)", err.Error())
+		}
+		logger.Info(fmt.Sprintf("%d metric(s) will be collected from key values", len(c.keyMetrics.Metrics)))
+	}
+
+	c.diagnosticsEnabled, err = parseDiagnosticsEnabled(configDiagnosticsEnabled)
+	if err != nil {
+		return fmt.Errorf("REDISEEN_DIAGNOSTICS_ENABLED provided can not be parsed properly (details: %s)", err.Error())
+	}
+	if len(c.diagnosticsEnabled) > 0 {
+		logger.Warn(fmt.Sprintf("You are exposing diagnostic endpoint(s) `%s`", configDiagnosticsEnabled))
+	}
+
+	c.configParamsExposed = nil
+	if c.diagnosticsEnabled[diagnosticConfig] {
+		c.configParamsExposed, err = parseConfigParamsExposed(configParamsExposed)
+		if err != nil {
+			return fmt.Errorf("REDISEEN_CONFIG_PARAMS_EXPOSED provided can not be parsed properly (details: %s)", err.Error())
+		}
+	}
+
+	c.compression, err = parseCompression(configCompression)
+	if err != nil {
+		return fmt.Errorf("REDISEEN_COMPRESSION provided can not be parsed properly (details: %s)", err.Error())
+	}
+
+	c.cacheMaxAge, err = parseCacheMaxAge(configCacheMaxAge)
+	if err != nil {
+		return fmt.Errorf("REDISEEN_CACHE_MAX_AGE provided can not be parsed properly (details: %s)", err.Error())
+	}
+
+	auditConfig, err := loadAuditConfigFromEnv()
+	if err != nil {
+		return fmt.Errorf("Audit log can not be configured (details: %s)", err.Error())
+	}
+	if c.auditor != nil {
+		c.auditor.Close()
+	}
+	c.auditor, err = openAuditLogger(auditConfig)
+	if err != nil {
+		return fmt.Errorf("Audit log can not be opened (details: %s)", err.Error())
+	}
+	if c.auditor != nil {
+		logger.Info(fmt.Sprintf("Data accesses are audited (sink: %s)", auditConfig.sink))
+	}
+
+	err = c.configureTracing()
+	if err != nil {
+		return fmt.Errorf("Tracing can not be configured (details: %s)", err.Error())
+	}
+
+	err = c.configureIPAccess()
+	if err != nil {
+		return fmt.Errorf("IP access control can not be configured (details: %s)", err.Error())
+	}
+
+	err = c.configureUI()
+	if err != nil {
+		return fmt.Errorf("Web UI can not be configured (details: %s)", err.Error())
+	}
+
+	err = c.configureCORS()
+	if err != nil {
+		return fmt.Errorf("CORS can not be configured (details: %s)", err.Error())
+	}
+
+	err = c.configureSizeLimits()
+	if err != nil {
+		return fmt.Errorf("Size limits can not be configured (details: %s)", err.Error())
+	}
+
+	err = c.configureValueCache()
+	if err != nil {
+		return fmt.Errorf("Value cache can not be configured (details: %s)", err.Error())
+	}
+
+	err = c.configureEvents()
+	if err != nil {
+		return fmt.Errorf("Keyspace events can not be configured (details: %s)", err.Error())
+	}
+
+	err = c.configurePubSub()
+	if err != nil {
+		return fmt.Errorf("Pub/Sub can not be configured (details: %s)", err.Error())
+	}
+
+	err = c.configureRateLimits()
+	if err != nil {
+		return fmt.Errorf("Rate limiting can not be configured (details: %s)", err.Error())
+	}
+
 	if c.authEnforced {
-		log.Println("[INFO] API is secured with X-API-KEY (to access, specify X-API-KEY in request header)")
+		logger.Info("API is secured with X-API-KEY (to access, specify X-API-KEY in request header)")
 	} else {
-		log.Println("[WARNING] API is NOT secured with X-API-KEY")
+		logger.Warn("API is NOT secured with X-API-KEY")
 	}
 
 	c.regexpKeyPatternExposed, err = regexp.Compile(c.keyPatternExposed)
@@ -100,7 +243,7 @@ func (c *service) loadConfigFromEnv() error {
 			return errors.New("You have specified both REDISEEN_KEY_PATTERN_EXPOSED " +
 				"and REDISEEN_KEY_PATTERN_EXPOSE_ALL=true, which is conflicting.")
 		}
-		log.Println("[WARNING] You are exposing ALL keys.")
+		logger.Warn("You are exposing ALL keys.")
 	} else {
 		if c.keyPatternExposed == "" {
 			strError := "You have not specified any key pattern to allow being accessed " +
@@ -109,7 +252,12 @@ func (c *service) loadConfigFromEnv() error {
 				"set environment variable REDISEEN_KEY_PATTERN_EXPOSE_ALL=true"
 			return errors.New(strError)
 		}
-		log.Println(fmt.Sprintf("[INFO] You are exposing keys of pattern `%s`", c.keyPatternExposed))
+		logger.Info(fmt.Sprintf("You are exposing keys of pattern `%s`", c.keyPatternExposed))
+	}
+
+	err = c.configureExposure()
+	if err != nil {
+		return err
 	}
 
 	if !c.testMode {
@@ -136,111 +284,201 @@ func (c *service) dbCheck(db int) bool {
 }
 
 func (c *service) apiKeyMatch(req *http.Request) bool {
-	if req.Header.Get("X-API-KEY") == c.apiKey {
+	if requestAPIKey(req) == c.apiKey {
 		return true
 	}
 	return false
 }
 
 func (c *service) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+
+	ctx, span := startServerSpan(req)
+	req = req.WithContext(ctx)
+
+	id := requestID(req)
+	res.Header().Set(requestIDHeader, id)
+	requestLogger := logger.With("request_id", id)
+	if span.IsRecording() {
+		requestLogger = requestLogger.With("trace_id", span.SpanContext().TraceID().String())
+	}
+	writer := newCompressWriter(res, req, c.compression)
+	recorder := newResponseRecorder(writer, requestLogger)
+	recorder.clientIP = c.clientIP(req)
+
+	c.serve(recorder, req)
+	if cw, ok := writer.(*compressWriter); ok {
+		if err := cw.Close(); err != nil {
+			recorder.log.Warn("Failed to finish compressed response. Details: " + err.Error())
+		}
+	}
+
+	elapsed := time.Since(start)
+	finishServerSpan(span, recorder, req)
+	recorder.observe(elapsed)
+	recorder.logRequest(req, elapsed)
+	c.auditDataAccess(recorder, req)
+}
+
+func (c *service) serve(res *responseRecorder, req *http.Request) {
 	res.Header().Set("Content-Type", "application/json")
 
-	log.Printf("%s %s '%s' %s\n", req.Method, req.RemoteAddr, req.URL.Path, req.UserAgent())
+	res.log.Debug("request received", "method", req.Method, "remote_addr", req.RemoteAddr,
+		"path", req.URL.Path, "user_agent", req.UserAgent())
+
+	if c.writeSecurityHeaders(res, req) {
+		return
+	}
 
 	var js []byte
 
-	if c.authEnforced {
+	res.caller = callerAnonymous
+	vouched, ok := c.checkClientIP(res, req)
+	if !ok {
+		return
+	}
+	// files of the UI hold no data, and browsers can not send X-API-KEY when loading pages. The UI asks users for
+	// the API key, and reads data from the API with it
+	uiFile := c.uiEnabled && isUIPath(req)
+	if c.authEnforced && !vouched && !uiFile {
+		res.caller = callerIdentity(requestAPIKey(req))
 		if !c.apiKeyMatch(req) {
 			res.WriteHeader(http.StatusUnauthorized)
 			js, _ = json.Marshal(types.ErrorType{Error: "unauthorized"})
 			res.Write(js)
-			log.Println("Unauthorized request")
+			authFailuresTotal.Inc()
+			res.log.Warn("Unauthorized request", "remote_addr", req.RemoteAddr)
 			return
 		}
 	}
 
-	if req.Method != "GET" {
-		res.WriteHeader(http.StatusMethodNotAllowed)
-		js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("Method %s is not allowed", req.Method)})
-		res.Write(js)
-		log.Println("Method not allowed")
+	// POST is only allowed by some routes (checked once the route is matched)
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		writeMethodNotAllowed(res, req)
 		return
 	}
 
 	// Process URL Path into detailed information, like DB and Key
-	arguments := strings.Split(req.URL.Path, "/")
-	countArguments := len(arguments)
-
-	if req.URL.Path == "/" {
-		res.Header().Set("Content-Type", "text/plain")
-		res.Write([]byte(strHeader))
-		res.Write([]byte("\n\n"))
-		res.Write([]byte("Available Endpoints:\n - /info\n - /info/<info_section>\n - /metrics (Prometheus-compatible)\n - /<db>\n - /<db>/<key>\n - /<db>/<key>/<index>\n - /<db>/<key>/<field>"))
+	segments, isV2 := pathSegments(req)
+	countArguments := len(segments) + 1
+
+	if !isV2 && req.URL.Path != "/" && (strings.HasSuffix(req.URL.Path, "/") || countArguments < 2 || countArguments > 4) {
+		writeUsageError(res, "/info, /info/<info_section>, /metrics, /<db>, /<db>/<key>, /<db>/<key>/<index>, or /<db>/<key>/<field>")
+		return
+	}
+	if segments == nil {
+		writeUsageError(res, "/v2/<db>, /v2/<db>/<key>, or /v2/<db>/<key>/<index or field>, with each segment percent-encoded")
 		return
 	}
 
-	if strings.HasSuffix(req.URL.Path, "/") || countArguments < 2 || countArguments > 4 {
-		res.WriteHeader(http.StatusBadRequest)
-		buffer := &bytes.Buffer{}
-		encoder := json.NewEncoder(buffer)
-		encoder.SetEscapeHTML(false)
-		encoder.Encode(types.ErrorType{Error: "Usage: /info, /info/<info_section>, /metrics, /<db>, /<db>/<key>, /<db>/<key>/<index>, or /<db>/<key>/<field>"})
-		res.Write(buffer.Bytes())
+	r, params, usage := matchRoute(segments)
+	if (r == nil && req.Method != http.MethodGet) || (r != nil && !r.allows(req.Method)) {
+		writeMethodNotAllowed(res, req)
 		return
 	}
+	if r == nil {
+		writeUsageError(res, usage)
+		return
+	}
+	res.endpoint = r.endpoint
+	res.route = r.path
+	release, ok := c.admit(res, req, r.endpoint)
+	if !ok {
+		return
+	}
+	defer release()
+	r.handle(c, res, req, params)
+}
 
-	var pathPart1 string // pathPart1 is either logical DB index OR "info"
-	var pathPart2 string // pathPart2 is either key name in Redis database OR section name for Redis INFO command
-	var pathPart3 string // pathPart3 is either index or field when clients submit queries like /<db>/<key>/<index> or /<db>/<key>/<field>
+// writeMethodNotAllowed responds 405
+func writeMethodNotAllowed(res *responseRecorder, req *http.Request) {
+	res.WriteHeader(http.StatusMethodNotAllowed)
+	js, _ := json.Marshal(types.ErrorType{Error: fmt.Sprintf("Method %s is not allowed", req.Method)})
+	res.Write(js)
+	res.log.Info("Method not allowed", "method", req.Method)
+}
 
-	pathPart1 = arguments[1]
-	if countArguments == 3 {
-		pathPart2 = arguments[2]
-		// When clients query "/<DB>/<key>", <key> is case-sensitive
+// writeUsageError responds 400 with the usage given (without escaping characters like < and >)
+func writeUsageError(res http.ResponseWriter, usage string) {
+	res.WriteHeader(http.StatusBadRequest)
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(types.ErrorType{Error: "Usage: " + usage})
+	res.Write(buffer.Bytes())
+}
+
+// serveRoot handles requests to /
+func (c *service) serveRoot(res *responseRecorder, req *http.Request, params []string) {
+	res.Header().Set("Content-Type", "text/plain")
+	res.Write([]byte(strHeader))
+	res.Write([]byte("\n\n"))
+	res.Write([]byte("Available Endpoints:\n - /info\n - /info/<info_section>\n - /metrics (Prometheus-compatible)\n - /slowlog, /latency, /latency/<event>, /clients, /memory, /config (only if enabled)\n - /openapi.json (OpenAPI 3 specification)\n - /dbs\n - /ui (web UI, only if enabled)\n - /<db> (or /<db>?cursor=&count=&match=&type=&meta= page by page)\n - /<db>/<key>\n - /<db>/<key>/<index>\n - /<db>/<key>/<field>\n - /<db>/_batch (GET or POST)\n - /<db>/_events (server-sent events, only if enabled)\n - /_pubsub (WebSocket to Pub/Sub channels, only if enabled)\n - /_publish/<channel> (POST, only if enabled)\n - /v2/<db>, /v2/<db>/<key>, /v2/<db>/<key>/<index or field> (percent-encoded), /v2/<db>?key=<key>&field=<index or field>"))
+}
+
+// serveInfo handles requests to /info and /info/<info_section>
+func (c *service) serveInfo(res *responseRecorder, req *http.Request, params []string) {
+	var section string
+	if len(params) > 0 {
 		// When clients query "/info/<section>", <section> is case-insensitive
-		if pathPart1 == "info" {
-			pathPart2 = strings.ToLower(pathPart2)
-		}
-	}
-	if countArguments == 4 {
-		pathPart2, pathPart3 = parseKeyAndIndex(strings.Join(arguments[2:], "/"))
+		section = strings.ToLower(params[0])
 	}
 
-	if pathPart1 == "metrics" {
-		var client conn.ExtendedClient
-		client.Init(0)
-		defer client.RedisClient.Close()
+	var client conn.ExtendedClient
+	client.Init(0)
+	defer client.RedisClient.Close()
 
-		res.Header().Set("Content-Type", "text/plain")
-		infoMetrics, _ := client.RedisInfo("all", "prometheus")
+	f, ok := negotiateFormat(res, req)
+	if !ok {
+		return
+	}
 
-		res.Write(infoMetrics)
+	js, err := client.RedisInfo(req.Context(), section, "json")
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid section") {
+			res.WriteHeader(http.StatusBadRequest)
+		} else {
+			res.WriteHeader(http.StatusInternalServerError)
+			redisErrorsTotal.Inc(res.endpoint)
+		}
+		js, _ = json.Marshal(types.ErrorType{Error: "Exception while getting Redis Info. Details: " + err.Error()})
+	} else if f != format.JSON {
+		writeInfo(res, f, js)
 		return
 	}
+	res.Write(js)
+}
 
-	if pathPart1 == "info" {
-		var client conn.ExtendedClient
-		client.Init(0)
-		defer client.RedisClient.Close()
+// dataTarget is what a request to /<db>, /<db>/<key>, or /<db>/<key>/<index or field> refers to.
+// key is empty for /<db>, and field is empty unless an index or field is given
+type dataTarget struct {
+	db    string
+	key   string
+	field string
+}
 
-		js, err := client.RedisInfo(pathPart2, "json")
-		if err != nil {
-			if strings.Contains(err.Error(), "invalid section") {
-				res.WriteHeader(http.StatusBadRequest)
-			} else {
-				res.WriteHeader(http.StatusInternalServerError)
-			}
-			js, _ = json.Marshal(types.ErrorType{Error: "Exception while getting Redis Info. Details: " + err.Error()})
-		}
-		res.Write(js)
+// serveData handles requests to /<db>, /<db>/<key>, and /<db>/<key>/<index or field>, of either v1 or v2
+func (c *service) serveData(res *responseRecorder, req *http.Request, target dataTarget) {
+	var js []byte
+
+	key, field := target.key, target.field
+	switch {
+	case key == "":
+		res.endpoint = endpointList
+	case field == "":
+		res.endpoint = endpointKey
+	default:
+		res.endpoint = endpointField
+	}
+
+	f, ok := negotiateFormat(res, req)
+	if !ok {
 		return
 	}
 
-	db, err := strconv.Atoi(pathPart1)
-	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
-		js, _ = json.Marshal(types.ErrorType{Error: "Provide an integer for DB"})
-		res.Write(js)
+	res.key, res.field = key, field
+	db, ok := c.exposedDB(res, target.db)
+	if !ok {
 		return
 	}
 
@@ -248,60 +486,138 @@ func (c *service) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	client.Init(db)
 	defer client.RedisClient.Close()
 
-	if !c.dbCheck(db) {
+	exposure := c.exposureOf(db)
+	if key == "" {
+		// request type-1: /db
+		page, err := parseKeyPageRequest(req)
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+			res.Write(js)
+			return
+		}
+		if page != nil {
+			c.serveKeyPage(res, req, &client, exposure, f, page)
+			return
+		}
+		if f != format.JSON {
+			writeKeyList(res, f, client.ListKeyInfo(req.Context(), exposure))
+			return
+		}
+		res.Write(client.ListKeys(req.Context(), exposure))
+		return
+	}
+
+	if !exposure.Exposes(key) {
 		res.WriteHeader(http.StatusForbidden)
-		js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("DB %d is not exposed", db)})
+		js, _ = json.Marshal(types.ErrorType{Error: "Key pattern is forbidden from access"})
 		res.Write(js)
 		return
 	}
-
-	if countArguments == 2 {
-		// request type-1: /db
-		res.Write(client.ListKeys(c.regexpKeyPatternExposed))
+	if exposure.Drops(key) {
+		// as if it does not exist
+		res.WriteHeader(http.StatusNotFound)
+		js, _ = json.Marshal(types.ErrorType{Error: "Key provided does not exist."})
+		res.Write(js)
 		return
 	}
 
-	if !c.regexpKeyPatternExposed.MatchString(pathPart2) {
-		res.WriteHeader(http.StatusForbidden)
-		js, _ = json.Marshal(types.ErrorType{Error: "Key pattern is forbidden from access"})
+	page, err := parsePageRequest(req)
+	if err != nil || (page != nil && field != "") {
+		if err == nil {
+			err = errors.New("cursor and count are only valid for keys read whole")
+		}
+		res.WriteHeader(http.StatusBadRequest)
+		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
 		res.Write(js)
 		return
 	}
+	if page != nil {
+		// pages are neither cached nor given ETags
+		c.servePage(res, req, &client, key, f, page)
+		return
+	}
+
+	if c.valueCache != nil {
+		c.serveCachedValue(res, req, &client, db, key, field, f)
+		return
+	}
+	c.serveValue(res, req, &client, key, field, f)
+}
 
-	// Check if key exists, meanwhile check Redis connection
-	keyExists, err := client.RedisClient.Exists(ctx, pathPart2).Result()
+// serveValue serves the value of a key (or its element). If the response is successful and held whole
+// (see etagWriter), it is given back as entry, with ok true
+func (c *service) serveValue(res *responseRecorder, req *http.Request, client *conn.ExtendedClient,
+	key string, field string, f string) (entry cache.Entry, ok bool) {
+	var js []byte
+
+	// Check if key exists (meanwhile check Redis connection), and get its TTL for Cache-Control
+	// (and its type, if its size is to be checked)
+	checkSize := field == "" && c.sizeLimits.Enabled()
+	pipe := client.RedisClient.Pipeline()
+	existsCmd := pipe.Exists(req.Context(), key)
+	ttlCmd := pipe.PTTL(req.Context(), key)
+	var typeCmd *redis.StatusCmd
+	if checkSize {
+		typeCmd = pipe.Type(req.Context(), key)
+	}
+	pipe.Exec(req.Context())
+	keyExists, err := existsCmd.Result()
 	if err != nil {
+		redisErrorsTotal.Inc(res.endpoint)
 		res.WriteHeader(http.StatusInternalServerError)
 		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
 		res.Write(js)
-		return
+		return entry, false
 	}
 
 	if keyExists == 0 {
 		res.WriteHeader(http.StatusNotFound)
 		js, _ = json.Marshal(types.ErrorType{Error: "Key provided does not exist."})
 		res.Write(js)
-		return
+		return entry, false
 	}
 
-	var logMsg strings.Builder
-	logMsg.WriteString("Submit query for: db ")
-	logMsg.WriteString(strconv.Itoa(db))
-	logMsg.WriteString(", key `")
-	logMsg.WriteString(pathPart2)
-	logMsg.WriteString("`")
-	if pathPart3 != "" {
-		logMsg.WriteString(", index/field `")
-		logMsg.WriteString(pathPart3)
-		logMsg.WriteString("`")
+	if checkSize && !c.checkSize(res, req, client, key, typeCmd.Val()) {
+		return entry, false
 	}
 
-	log.Println(logMsg.String())
-	js, errorCode := client.Retrieve(pathPart2, pathPart3)
-	if errorCode != 0 {
-		res.WriteHeader(errorCode)
+	res.log.Debug("Submit query", "db", res.db, "key", key, "field", field)
+	ttl := ttlCmd.Val()
+	held := writeWithETag(res, req, c.cacheControl(ttl), func() bool {
+		complete := writeValue(res, req, client, f, key, field, c.exposure.Redactor(key))
+		entry.ContentType = res.Header().Get("Content-Type")
+		return complete
+	})
+	if held == nil {
+		return entry, false
 	}
-	res.Write(js)
+	entry.Body = held
+	if ttl >= 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	return entry, true
+}
+
+// exposedDB parses the DB given. If it is not an integer or not exposed, it responds with the error and ok is false
+func (c *service) exposedDB(res *responseRecorder, rawDB string) (db int, ok bool) {
+	db, err := strconv.Atoi(rawDB)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		js, _ := json.Marshal(types.ErrorType{Error: "Provide an integer for DB"})
+		res.Write(js)
+		return 0, false
+	}
+
+	exposed := c.dbCheck(db)
+	res.setDB(db, exposed)
+	if !exposed {
+		res.WriteHeader(http.StatusForbidden)
+		js, _ := json.Marshal(types.ErrorType{Error: fmt.Sprintf("DB %d is not exposed", db)})
+		res.Write(js)
+		return db, false
+	}
+	return db, true
 }
 
 // validate if the string given as DB(s) to expose is legal.
@@ -309,7 +625,7 @@ func (c *service) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 func validateDbExposeConfig(configDbExposed string) error {
 	// case-1: "*"
 	if configDbExposed == "*" {
-		log.Println("[WARNING] You are exposing ALL logical databases.")
+		logger.Warn("You are exposing ALL logical databases.")
 		return nil
 	}
 
@@ -326,7 +642,7 @@ func validateDbExposeConfig(configDbExposed string) error {
 		}
 	}
 
-	log.Println(fmt.Sprintf("[INFO] You are exposing logical database(s) `%s`", configDbExposed))
+	logger.Info(fmt.Sprintf("You are exposing logical database(s) `%s`", configDbExposed))
 	return nil
 }
 
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/xd-deng/rediseen/conn"
+	"github.com/xd-deng/rediseen/format"
+	"github.com/xd-deng/rediseen/types"
+)
+
+// defaultPageCount is the number of elements (or bytes of strings) per page, if only cursor is given
+const defaultPageCount = 1000
+
+// nextCursorHeader gives the cursor of the next page ("0" once the last page is given)
+const nextCursorHeader = "X-Next-Cursor"
+
+// cursorPattern matches cursors given by pages: offsets, cursors of SCAN, SSCAN and HSCAN, and IDs of stream entries
+var cursorPattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)?$`)
+
+var errInvalidCursor = errors.New("Provide a cursor given by a previous page (or 0 for the first page)")
+
+// configureSizeLimits applies REDISEEN_MAX_RESPONSE_BYTES, REDISEEN_MAX_ELEMENTS and REDISEEN_MAX_STRING_LENGTH
+func (c *service) configureSizeLimits() error {
+	maxBytes, err := intFromEnv("REDISEEN_MAX_RESPONSE_BYTES", 0)
+	if err != nil {
+		return err
+	}
+	maxElements, err := intFromEnv("REDISEEN_MAX_ELEMENTS", 0)
+	if err != nil {
+		return err
+	}
+	maxStringLength, err := intFromEnv("REDISEEN_MAX_STRING_LENGTH", 0)
+	if err != nil {
+		return err
+	}
+
+	c.sizeLimits = conn.SizeLimits{MaxBytes: int64(maxBytes), MaxElements: int64(maxElements),
+		MaxStringLength: int64(maxStringLength)}
+	if c.sizeLimits.Enabled() {
+		logger.Info(fmt.Sprintf("Values larger than size limits (bytes: %d, elements: %d, string length: %d, 0 for no limit) "+
+			"are only given page by page", maxBytes, maxElements, maxStringLength))
+	}
+	return nil
+}
+
+// pageRequest is a page of a value asked for, via query parameters cursor and count
+type pageRequest struct {
+	cursor string
+	count  int64
+}
+
+// parsePageRequest parses query parameters cursor and count. page is nil if neither is given
+func parsePageRequest(req *http.Request) (page *pageRequest, err error) {
+	query := req.URL.Query()
+	rawCursor, rawCount := query.Get("cursor"), query.Get("count")
+	if rawCursor == "" && rawCount == "" {
+		return nil, nil
+	}
+
+	page = &pageRequest{cursor: "0", count: defaultPageCount}
+	if rawCursor != "" {
+		if !cursorPattern.MatchString(rawCursor) {
+			return nil, errInvalidCursor
+		}
+		page.cursor = rawCursor
+	}
+	if rawCount != "" {
+		page.count, err = strconv.ParseInt(rawCount, 10, 64)
+		if err != nil || page.count < 1 {
+			return nil, errors.New("Provide a positive integer for count")
+		}
+	}
+	return page, nil
+}
+
+// checkSize responds 413 if the value of the key (of the type given) exceeds size limits, in which case ok is false
+func (c *service) checkSize(res *responseRecorder, req *http.Request, client *conn.ExtendedClient, key string, keyType string) (ok bool) {
+	size, err := client.Size(req.Context(), key, keyType, c.sizeLimits.MaxBytes > 0)
+	if err != nil {
+		redisErrorsTotal.Inc(res.endpoint)
+		res.WriteHeader(http.StatusInternalServerError)
+		js, _ := json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return false
+	}
+
+	exceeded := c.sizeLimits.Check(size)
+	if exceeded == nil {
+		return true
+	}
+	writeLimitError(res, req, exceeded)
+	res.log.Info("Value exceeds size limits", "limit", exceeded.Limit, "size", exceeded.Size)
+	return false
+}
+
+// writeLimitError responds 413 with the error given, and the link to the page suggested (keeping other query
+// parameters, like format)
+func writeLimitError(res *responseRecorder, req *http.Request, exceeded *types.LimitErrorType) {
+	next := *req.URL
+	query := next.Query()
+	query.Set("cursor", exceeded.Paging.Cursor)
+	query.Set("count", strconv.FormatInt(exceeded.Paging.Count, 10))
+	next.RawQuery = query.Encode()
+	exceeded.Paging.Next = next.RequestURI()
+
+	sizeLimitedTotal.Inc(exceeded.Limit)
+	res.WriteHeader(http.StatusRequestEntityTooLarge)
+	// without escaping characters like & in paging.next
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(exceeded)
+	res.Write(bytes.TrimSuffix(buffer.Bytes(), []byte("\n")))
+}
+
+// checkPageBytes returns the error to respond if the page (as JSON) exceeds REDISEEN_MAX_RESPONSE_BYTES, or nil.
+// The paging suggested is the same page, with a count small enough for it to fit, if elements are alike.
+// Pages of a single element are given anyway, since they can not be any smaller
+func (c *service) checkPageBytes(keyType string, page *pageRequest, elements []interface{}) *types.LimitErrorType {
+	maxBytes := c.sizeLimits.MaxBytes
+	if maxBytes <= 0 || page.count <= 1 {
+		return nil
+	}
+	js, _ := json.Marshal(pageValue(keyType, elements))
+	size := int64(len(js))
+	if size <= maxBytes {
+		return nil
+	}
+	count := page.count * maxBytes / size
+	if count < 1 {
+		count = 1
+	}
+	return &types.LimitErrorType{
+		Error:   fmt.Sprintf("Page is too large (%d bytes, while the maximum is %d). Read it with a smaller count", size, maxBytes),
+		Limit:   conn.LimitBytes,
+		Maximum: maxBytes,
+		Size:    size,
+		Paging:  types.PagingType{Cursor: page.cursor, Count: count},
+	}
+}
+
+// servePage serves a page of the value of a key. Pages are read from Redis at once (they are small), so the
+// cursor of the next page is given in header X-Next-Cursor, as well as in field `cursor` of structured formats
+func (c *service) servePage(res *responseRecorder, req *http.Request, client *conn.ExtendedClient, key string, f string, page *pageRequest) {
+	var js []byte
+
+	keyType, err := client.RedisClient.Type(req.Context(), key).Result()
+	if err != nil {
+		redisErrorsTotal.Inc(res.endpoint)
+		res.WriteHeader(http.StatusInternalServerError)
+		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return
+	}
+	if keyType == "none" {
+		res.WriteHeader(http.StatusNotFound)
+		js, _ = json.Marshal(types.ErrorType{Error: "Key provided does not exist."})
+		res.Write(js)
+		return
+	}
+	if _, isCollection := rowHeaders[keyType]; !isCollection && keyType != "string" {
+		res.WriteHeader(http.StatusNotImplemented)
+		js, _ = json.Marshal(types.ErrorType{Error: "not implemented"})
+		res.Write(js)
+		return
+	}
+
+	maxCount := c.sizeLimits.MaxElements
+	if keyType == "string" {
+		maxCount = c.sizeLimits.MaxStringLength
+	}
+	if maxCount > 0 && page.count > maxCount {
+		res.WriteHeader(http.StatusBadRequest)
+		js, _ = json.Marshal(types.ErrorType{Error: fmt.Sprintf("Provide a count no more than %d", maxCount)})
+		res.Write(js)
+		return
+	}
+
+	var elements []interface{}
+	next, err := client.ReadPage(req.Context(), key, keyType, page.cursor, page.count, c.exposure.Redactor(key).Emit(keyType,
+		func(element interface{}) error {
+			elements = append(elements, element)
+			return nil
+		}))
+	if err == conn.ErrInvalidCursor {
+		res.WriteHeader(http.StatusBadRequest)
+		js, _ = json.Marshal(types.ErrorType{Error: errInvalidCursor.Error()})
+		res.Write(js)
+		return
+	}
+	if err != nil {
+		redisErrorsTotal.Inc(res.endpoint)
+		res.WriteHeader(http.StatusInternalServerError)
+		js, _ = json.Marshal(types.ErrorType{Error: err.Error()})
+		res.Write(js)
+		return
+	}
+
+	if exceeded := c.checkPageBytes(keyType, page, elements); exceeded != nil {
+		writeLimitError(res, req, exceeded)
+		res.log.Info("Page exceeds size limits", "limit", exceeded.Limit, "size", exceeded.Size)
+		return
+	}
+
+	cursor := next
+	res.Header().Set(nextCursorHeader, cursor)
+
+	if isRowFormat(f) {
+		header, isCollection := rowHeaders[keyType]
+		if !isCollection {
+			header = []string{"value"}
+		}
+		rows := newRowWriter(res, f, header...)
+		for _, element := range elements {
+			rows.write(element)
+		}
+		rows.close()
+		return
+	}
+
+	value := types.PageType{ValueType: keyType, Cursor: cursor, Value: pageValue(keyType, elements)}
+	if f != format.JSON {
+		writeEncoded(res, f, value)
+		return
+	}
+	js, _ = json.Marshal(value)
+	res.Write(js)
+}
+
+// pageValue shapes the elements of a page like the whole value: a string, an object of fields for hash,
+// an array of entries for stream, or an array (of members only for sorted set, like ZRANGE)
+func pageValue(keyType string, elements []interface{}) interface{} {
+	switch keyType {
+	case "string":
+		if len(elements) == 0 {
+			return ""
+		}
+		return elements[0]
+	case "hash":
+		fields := make(map[string]string, len(elements))
+		for _, e := range elements {
+			entry := e.(types.HashEntryType)
+			fields[entry.Field] = entry.Value
+		}
+		return fields
+	case "stream":
+		entries := make([]types.StreamEntryType, 0, len(elements))
+		for _, e := range elements {
+			entries = append(entries, e.(types.StreamEntryType))
+		}
+		return entries
+	default:
+		values := make([]string, 0, len(elements))
+		for _, e := range elements {
+			switch v := e.(type) {
+			case string:
+				values = append(values, v)
+			case types.SortedSetEntryType:
+				values = append(values, v.Member)
+			}
+		}
+		return values
+	}
+}
@@ -13,8 +13,10 @@ type ErrorType struct {
 
 // KeyInfoType acts as the JSON template for element in KeyListType
 type KeyInfoType struct {
-	Key  string `json:"key"`
-	Type string `json:"type"`
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	TTL   *int64 `json:"ttl,omitempty"`   // seconds to live (-1 if no expiry), only given if asked for
+	Bytes *int64 `json:"bytes,omitempty"` // memory used (MEMORY USAGE), only given if asked for and known
 }
 
 // KeyListType acts as the JSON template for API response (successful calls)
@@ -23,3 +25,166 @@ type KeyListType struct {
 	Total int           `json:"total"`
 	Keys  []KeyInfoType `json:"keys"`
 }
+
+// KeyPageType acts as the JSON template for a page of keys of /<db>, scanned with cursor
+type KeyPageType struct {
+	Count  int           `json:"count"`
+	Cursor string        `json:"cursor"` // "0" once the last page is given
+	Keys   []KeyInfoType `json:"keys"`
+}
+
+// DBInfoType acts as the JSON template for element in DBListType
+type DBInfoType struct {
+	DB   int    `json:"db"`
+	Keys *int64 `json:"keys,omitempty"` // only if asked for, since keys are counted by scanning the whole DB
+}
+
+// DBListType acts as the JSON template for response of /dbs
+type DBListType struct {
+	DBs []DBInfoType `json:"dbs"`
+}
+
+// SlowLogEntryType acts as the JSON template for element in SlowLogType
+type SlowLogEntryType struct {
+	ID                   int64    `json:"id"`
+	Timestamp            int64    `json:"timestamp"`
+	DurationMicroseconds int64    `json:"duration_us"`
+	Args                 []string `json:"args"`
+	ClientAddr           string   `json:"client_addr,omitempty"`
+	ClientName           string   `json:"client_name,omitempty"`
+}
+
+// SlowLogType acts as the JSON template for API response of /slowlog
+type SlowLogType struct {
+	Length  int64              `json:"length"`
+	Entries []SlowLogEntryType `json:"entries"`
+}
+
+// LatencyEventType acts as the JSON template for element in API response of /latency
+type LatencyEventType struct {
+	Event              string `json:"event"`
+	Timestamp          int64  `json:"timestamp"`
+	LatestMilliseconds int64  `json:"latest_ms"`
+	MaxMilliseconds    int64  `json:"max_ms"`
+}
+
+// LatencySampleType acts as the JSON template for element in API response of /latency/<event>
+type LatencySampleType struct {
+	Timestamp           int64 `json:"timestamp"`
+	LatencyMilliseconds int64 `json:"latency_ms"`
+}
+
+// BatchItemType acts as the JSON template for element in BatchRequestType
+type BatchItemType struct {
+	Key   string `json:"key"`
+	Field string `json:"field,omitempty"`
+}
+
+// BatchRequestType acts as the JSON template for API request of /<db>/_batch
+type BatchRequestType struct {
+	Keys []BatchItemType `json:"keys"`
+}
+
+// BatchResultType acts as the JSON template for element in BatchResponseType.
+// Either type and value (if status is 200), or error is given
+type BatchResultType struct {
+	Key    string `json:"key"`
+	Field  string `json:"field,omitempty"`
+	Status int    `json:"status"`
+	*ResponseType
+	Error string `json:"error,omitempty"`
+}
+
+// KeyEventType acts as the JSON template for data of server-sent events of /<db>/_events. Type and value
+// are only given if values are asked for, and the key still exists. Error is given if its value can not be read
+type KeyEventType struct {
+	Key   string `json:"key"`
+	Event string `json:"event"`
+	*ResponseType
+	Error string `json:"error,omitempty"`
+}
+
+// PubSubRequestType acts as the JSON template for messages of clients of /_pubsub. Action is `subscribe` and
+// `unsubscribe` (with channels and/or patterns), or `publish` (with channel and message)
+type PubSubRequestType struct {
+	Action   string   `json:"action"`
+	Channels []string `json:"channels,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+	Channel  string   `json:"channel,omitempty"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// PubSubEventType acts as the JSON template for messages to clients of /_pubsub. Type is `message` (with channel,
+// pattern if subscribed by pattern, and message), `subscribed` and `unsubscribed` (with channels and patterns),
+// `published` (with channel and receivers), or `error`
+type PubSubEventType struct {
+	Type      string   `json:"type"`
+	Channel   string   `json:"channel,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Message   *string  `json:"message,omitempty"`
+	Channels  []string `json:"channels,omitempty"`
+	Patterns  []string `json:"patterns,omitempty"`
+	Receivers *int64   `json:"receivers,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// PublishResponseType acts as the JSON template for API response of /_publish/<channel>
+type PublishResponseType struct {
+	Channel   string `json:"channel"`
+	Receivers int64  `json:"receivers"`
+}
+
+// BatchResponseType acts as the JSON template for API response of /<db>/_batch
+type BatchResponseType struct {
+	Count   int               `json:"count"`
+	Results []BatchResultType `json:"results"`
+}
+
+// HashEntryType acts as the template for element of hash, in row-based formats (NDJSON and CSV)
+type HashEntryType struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// StreamEntryType acts as the template for entry of stream
+type StreamEntryType struct {
+	ID     string            `json:"id"`
+	Fields map[string]string `json:"fields"`
+}
+
+// SortedSetEntryType acts as the template for element of sorted set, in row-based formats (NDJSON and CSV)
+type SortedSetEntryType struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// InfoEntryType acts as the template for field of Redis INFO, in row-based formats (NDJSON and CSV)
+type InfoEntryType struct {
+	Section string `json:"section"`
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+}
+
+// PagingType acts as the JSON template for the paging suggested in LimitErrorType
+type PagingType struct {
+	Cursor string `json:"cursor"`
+	Count  int64  `json:"count"`
+	Next   string `json:"next"` // path and query of the first page
+}
+
+// LimitErrorType acts as the JSON template for API response when a value exceeds a size limit (413)
+type LimitErrorType struct {
+	Error   string     `json:"error"`
+	Limit   string     `json:"limit"` // bytes, elements or string_length
+	Maximum int64      `json:"maximum"`
+	Size    int64      `json:"size"`
+	Paging  PagingType `json:"paging"`
+}
+
+// PageType acts as the JSON template for API response of a page of a value (given query parameter cursor or count).
+// cursor is "0" once the last page is given
+type PageType struct {
+	ValueType string      `json:"type"`
+	Value     interface{} `json:"value"`
+	Cursor    string      `json:"cursor"`
+}
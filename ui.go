@@ -0,0 +1,72 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/xd-deng/rediseen/types"
+)
+
+// uiAssets are the files of the web UI, served at /ui (index.html) and /ui/<file>
+//
+//go:embed ui
+var uiAssets embed.FS
+
+// uiContentSecurityPolicy relaxes the policy of securityHeaders for the UI, which only loads its own scripts and
+// styles, and only talks to the API
+const uiContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; " +
+	"img-src 'self' data:; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+// uiContentTypes are the types of the files of the UI, by extension
+var uiContentTypes = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+}
+
+// configureUI applies REDISEEN_UI_ENABLED
+func (c *service) configureUI() error {
+	switch os.Getenv("REDISEEN_UI_ENABLED") {
+	case "", "false":
+		c.uiEnabled = false
+	case "true":
+		c.uiEnabled = true
+		logger.Info("Web UI is served at /ui")
+	default:
+		return errors.New("REDISEEN_UI_ENABLED should be `true` or `false`")
+	}
+	return nil
+}
+
+// isUIPath tells if the request is for a file of the UI
+func isUIPath(req *http.Request) bool {
+	return req.URL.Path == "/ui" || strings.HasPrefix(req.URL.Path, "/ui/")
+}
+
+// serveUI handles requests to /ui and /ui/<file>. Files of the UI hold no data: the UI reads data from the API,
+// with the API key given by users
+func (c *service) serveUI(res *responseRecorder, req *http.Request, params []string) {
+	name := "index.html"
+	if len(params) > 0 {
+		name = params[0]
+	}
+	content, err := fs.ReadFile(uiAssets, path.Join("ui", name))
+	contentType, known := uiContentTypes[path.Ext(name)]
+	if !c.uiEnabled || err != nil || !known || strings.Contains(name, "/") {
+		res.WriteHeader(http.StatusNotFound)
+		js, _ := json.Marshal(types.ErrorType{Error: "Not found"})
+		res.Write(js)
+		return
+	}
+
+	res.Header().Set("Content-Type", contentType)
+	res.Header().Set("Content-Security-Policy", uiContentSecurityPolicy)
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Write(content)
+}
@@ -0,0 +1,285 @@
+"use strict";
+
+// Rediseen web UI. It only reads data from the API of Rediseen (so it is subject to the same authentication,
+// exposure and redaction), and renders it with textContent, never as HTML.
+
+const KEY_PAGE_SIZE = 50;
+const VALUE_PAGE_SIZE = 100;
+const STRING_PAGE_SIZE = 4096;
+
+const state = {
+  apiKey: sessionStorage.getItem("rediseen-api-key") || "",
+  db: null,
+  keyCursor: "0",
+  key: null,
+  keyType: null,
+  valueCursor: "0",
+  valueCount: VALUE_PAGE_SIZE,
+  rendered: 0,
+  lastError: "",
+};
+
+const $ = (id) => document.getElementById(id);
+
+function element(tag, text, attributes) {
+  const e = document.createElement(tag);
+  if (text !== undefined && text !== null) {
+    e.textContent = String(text);
+  }
+  Object.entries(attributes || {}).forEach(([name, value]) => e.setAttribute(name, value));
+  return e;
+}
+
+function showError(message) {
+  state.lastError = message;
+  const box = $("error");
+  box.textContent = message;
+  box.hidden = !message;
+}
+
+// request calls the API, and gives the response if it is successful. Otherwise the error is shown, and null is given
+async function request(path, params) {
+  const url = new URL(path, window.location.origin);
+  Object.entries(params || {}).forEach(([name, value]) => {
+    if (value !== "" && value !== null && value !== undefined) {
+      url.searchParams.set(name, value);
+    }
+  });
+  const headers = {};
+  if (state.apiKey) {
+    headers["X-API-KEY"] = state.apiKey;
+  }
+
+  let res;
+  try {
+    res = await fetch(url, { headers: headers, cache: "no-store" });
+  } catch (e) {
+    showError("Rediseen can not be reached: " + e.message);
+    return null;
+  }
+  if (res.ok) {
+    showError("");
+    return res;
+  }
+
+  let message = res.status + " " + res.statusText;
+  try {
+    message = (await res.json()).error || message;
+  } catch (e) {
+    // not JSON
+  }
+  if (res.status === 401) {
+    message = "Unauthorized: give the API key above";
+  }
+  showError(message);
+  return null;
+}
+
+function formatTTL(ttl) {
+  if (ttl === undefined) {
+    return "";
+  }
+  if (ttl < 0) {
+    return "no expiry";
+  }
+  const units = [["d", 86400], ["h", 3600], ["m", 60]];
+  for (const [unit, seconds] of units) {
+    if (ttl >= seconds) {
+      return Math.floor(ttl / seconds) + unit + " " + (ttl % seconds) + "s";
+    }
+  }
+  return ttl + "s";
+}
+
+function formatBytes(bytes) {
+  if (bytes === undefined) {
+    return "unknown";
+  }
+  const units = ["B", "KB", "MB", "GB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+}
+
+async function loadDBs() {
+  const res = await request("/dbs");
+  if (!res) {
+    return;
+  }
+  const nav = $("dbs");
+  nav.replaceChildren();
+  const body = await res.json();
+  body.dbs.forEach((db) => {
+    const button = element("button", "DB " + db.db, { type: "button" });
+    button.addEventListener("click", () => selectDB(db.db, button));
+    nav.appendChild(button);
+  });
+  if (body.dbs.length > 0 && state.db === null) {
+    selectDB(body.dbs[0].db, nav.firstChild);
+  }
+}
+
+function selectDB(db, button) {
+  state.db = db;
+  document.querySelectorAll("#dbs button").forEach((b) => b.classList.toggle("selected", b === button));
+  clearValue();
+  loadKeys(true);
+}
+
+async function loadKeys(reset) {
+  if (reset) {
+    state.keyCursor = "0";
+    $("key-rows").replaceChildren();
+  }
+  const res = await request("/v2/" + state.db, {
+    cursor: state.keyCursor,
+    count: KEY_PAGE_SIZE,
+    match: $("match").value,
+    type: $("type").value,
+    meta: "true",
+  });
+  if (!res) {
+    return;
+  }
+  const page = await res.json();
+  page.keys.forEach((k) => {
+    const row = element("tr");
+    row.appendChild(element("td", k.key));
+    row.appendChild(element("td", k.type));
+    row.appendChild(element("td", formatTTL(k.ttl)));
+    row.appendChild(element("td", formatBytes(k.bytes)));
+    row.addEventListener("click", () => selectKey(k, row));
+    $("key-rows").appendChild(row);
+  });
+  state.keyCursor = page.cursor;
+  $("more-keys").hidden = page.cursor === "0";
+}
+
+function clearValue() {
+  state.key = null;
+  $("value-key").textContent = "";
+  $("value-meta").textContent = "";
+  $("value-body").replaceChildren();
+  $("more-value").hidden = true;
+}
+
+function selectKey(k, row) {
+  document.querySelectorAll("#key-rows tr").forEach((r) => r.classList.toggle("selected", r === row));
+  clearValue();
+  state.key = k.key;
+  state.keyType = k.type;
+  state.valueCursor = "0";
+  state.valueCount = k.type === "string" ? STRING_PAGE_SIZE : VALUE_PAGE_SIZE;
+  state.rendered = 0;
+  $("value-key").textContent = k.key;
+  $("value-meta").textContent = k.type + " · TTL: " + formatTTL(k.ttl) + " · memory: " + formatBytes(k.bytes);
+  $("value-body").appendChild(container(k.type));
+  loadValue();
+}
+
+// container creates the element which the elements of a value of the type given are appended to
+function container(type) {
+  const header = {
+    hash: ["Field", "Value"],
+    zset: ["#", "Member", "Score"],
+    stream: ["ID", "Fields"],
+  }[type];
+  if (type === "string") {
+    return element("pre");
+  }
+  if (type === "list") {
+    return element("ol", null, { start: "0" });
+  }
+  if (!header) {
+    return element("ul");
+  }
+  const table = element("table");
+  const row = element("tr");
+  header.forEach((h) => row.appendChild(element("th", h)));
+  table.appendChild(element("thead")).appendChild(row);
+  table.appendChild(element("tbody"));
+  return table;
+}
+
+function render(type, item) {
+  const target = $("value-body").firstChild;
+  const index = state.rendered++;
+  const row = element("tr");
+  switch (type) {
+    case "string":
+      target.textContent += item;
+      return;
+    case "list":
+    case "set":
+      target.appendChild(element("li", item));
+      return;
+    case "hash":
+      row.appendChild(element("td", item.field));
+      row.appendChild(element("td", item.value));
+      break;
+    case "zset":
+      row.appendChild(element("td", index));
+      row.appendChild(element("td", item.member));
+      row.appendChild(element("td", item.score));
+      break;
+    case "stream": {
+      row.appendChild(element("td", item.id));
+      const fields = Object.entries(item.fields).map(([f, v]) => f + ": " + v).join("\n");
+      row.appendChild(element("td")).appendChild(element("pre", fields));
+      break;
+    }
+    default:
+      target.appendChild(element("li", JSON.stringify(item)));
+      return;
+  }
+  target.querySelector("tbody").appendChild(row);
+}
+
+async function loadValue() {
+  const key = state.key;
+  const res = await request("/v2/" + state.db, {
+    key: key,
+    cursor: state.valueCursor,
+    count: state.valueCount,
+    format: "ndjson",
+  });
+  const limit = /count no more than (\d+)/.exec(state.lastError);
+  if (!res && limit && Number(limit[1]) < state.valueCount) {
+    // pages must fit in the size limits of Rediseen
+    state.valueCount = Number(limit[1]);
+    return loadValue();
+  }
+  if (!res || key !== state.key) {
+    return;
+  }
+  const text = await res.text();
+  text.split("\n").filter((line) => line !== "").forEach((line) => render(state.keyType, JSON.parse(line)));
+  state.valueCursor = res.headers.get("X-Next-Cursor") || "0";
+  $("more-value").hidden = state.valueCursor === "0";
+}
+
+function init() {
+  $("api-key").value = state.apiKey;
+  $("auth").addEventListener("submit", (event) => {
+    event.preventDefault();
+    state.apiKey = $("api-key").value;
+    sessionStorage.setItem("rediseen-api-key", state.apiKey);
+    state.db = null;
+    loadDBs();
+  });
+  $("filters").addEventListener("submit", (event) => {
+    event.preventDefault();
+    if (state.db !== null) {
+      clearValue();
+      loadKeys(true);
+    }
+  });
+  $("more-keys").addEventListener("click", () => loadKeys(false));
+  $("more-value").addEventListener("click", loadValue);
+  loadDBs();
+}
+
+init();
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xd-deng/rediseen/cache"
+	"github.com/xd-deng/rediseen/conn"
+)
+
+// Clients replaced (after reconnecting to listen to changes) are closed after clientRetireDelay,
+// so that requests using them can finish
+const clientRetireDelay = time.Minute
+
+// configureValueCache applies REDISEEN_VALUE_CACHE_* environment variables. The value cache is disabled if
+// REDISEEN_VALUE_CACHE_SIZE_MB is not set
+func (c *service) configureValueCache() error {
+	if c.valueCache != nil {
+		c.valueCache.close()
+		c.valueCache = nil
+	}
+
+	sizeMB, err := intFromEnv("REDISEEN_VALUE_CACHE_SIZE_MB", 0)
+	if err != nil {
+		return err
+	}
+	mode := os.Getenv("REDISEEN_VALUE_CACHE_INVALIDATION")
+	switch mode {
+	case "":
+		mode = conn.InvalidationTracking
+	case conn.InvalidationTracking, conn.InvalidationKeyspace:
+	default:
+		return fmt.Errorf("REDISEEN_VALUE_CACHE_INVALIDATION should be `%s` or `%s`",
+			conn.InvalidationTracking, conn.InvalidationKeyspace)
+	}
+	if sizeMB == 0 {
+		return nil
+	}
+
+	c.valueCache = newValueCache(int64(sizeMB) << 20)
+	c.valueCache.listen(mode)
+	logger.Info(fmt.Sprintf("Values are cached (up to %d MB), and evicted when changed in Redis (via %s)", sizeMB, mode))
+	return nil
+}
+
+// valueCache keeps responses to reads of keys, evicted when keys are changed in Redis. It is bypassed (and empty)
+// while changes can not be listened to. In tracking mode, keys are read with its own clients, so that Redis tracks them
+type valueCache struct {
+	lru      *cache.LRU
+	listener *conn.InvalidationListener
+
+	mu         sync.Mutex
+	live       bool
+	requested  string // mode configured
+	mode       string // mode in use
+	redirectID int64
+	clients    map[int]*conn.ExtendedClient
+}
+
+func newValueCache(maxBytes int64) *valueCache {
+	return &valueCache{lru: cache.New(maxBytes), clients: map[int]*conn.ExtendedClient{}}
+}
+
+func (v *valueCache) listen(mode string) {
+	v.requested = mode
+	v.listener = conn.ListenInvalidations(mode, v)
+}
+
+func (v *valueCache) close() {
+	if v.listener != nil {
+		v.listener.Close()
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.live = false
+	v.retireClients()
+}
+
+// client gives the client to read keys of the DB given with, or false if the cache is bypassed
+func (v *valueCache) client(db int) (*conn.ExtendedClient, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !v.live {
+		return nil, false
+	}
+	client, ok := v.clients[db]
+	if !ok {
+		client = &conn.ExtendedClient{}
+		client.InitTracked(db, v.redirectID)
+		v.clients[db] = client
+	}
+	return client, true
+}
+
+// retireClients closes the clients once requests using them are finished. Callers must hold v.mu
+func (v *valueCache) retireClients() {
+	for _, client := range v.clients {
+		time.AfterFunc(clientRetireDelay, func(client *conn.ExtendedClient) func() {
+			return func() { client.RedisClient.Close() }
+		}(client))
+	}
+	v.clients = map[int]*conn.ExtendedClient{}
+}
+
+// Connected implements conn.Invalidations
+func (v *valueCache) Connected(mode string, redirectID int64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.requested == conn.InvalidationTracking && mode != v.requested && v.mode != mode {
+		logger.Warn("Redis does not support CLIENT TRACKING (or it is not allowed), so keyspace notifications " +
+			"are used to evict cached values. FLUSHDB and FLUSHALL are not notified")
+	}
+	// clients created before do not redirect tracking to the new connection
+	v.retireClients()
+	v.mode, v.redirectID, v.live = mode, redirectID, true
+	logger.Info("Value cache is enabled (changes in Redis are listened to)")
+}
+
+// Disconnected implements conn.Invalidations
+func (v *valueCache) Disconnected(err error) {
+	v.mu.Lock()
+	v.live = false
+	v.mu.Unlock()
+
+	v.InvalidateAll()
+	logger.Warn("Value cache is bypassed until changes in Redis can be listened to. Details: " + err.Error())
+}
+
+// Invalidate implements conn.Invalidations
+func (v *valueCache) Invalidate(db int, key string) {
+	valueCacheEvictionsTotal.Add(float64(v.lru.Invalidate(db, key)), evictionInvalidated)
+	v.observe()
+}
+
+// InvalidateAll implements conn.Invalidations
+func (v *valueCache) InvalidateAll() {
+	valueCacheEvictionsTotal.Add(float64(v.lru.Flush()), evictionInvalidated)
+	v.observe()
+}
+
+func (v *valueCache) observe() {
+	entries, bytes := v.lru.Len()
+	valueCacheEntries.Set(float64(entries))
+	valueCacheBytes.Set(float64(bytes))
+}
+
+// serveCachedValue serves the value of a key (or its element) from the value cache. If it is not cached,
+// it is read like in serveValue, and cached
+func (c *service) serveCachedValue(res *responseRecorder, req *http.Request, client *conn.ExtendedClient,
+	db int, key string, field string, f string) {
+	variant := field + "\x00" + f
+	if entry, ok := c.valueCache.lru.Get(cache.Key{DB: db, Key: key, Variant: variant}, time.Now()); ok {
+		valueCacheHitsTotal.Inc()
+		res.log.Debug("Serve from value cache", "db", db, "key", key, "field", field)
+		ttl := time.Duration(-1)
+		if !entry.Expires.IsZero() {
+			ttl = time.Until(entry.Expires)
+		}
+		res.Header().Set("Content-Type", entry.ContentType)
+		// cached responses are never larger than maxETagBufferBytes, so no digest is needed
+		writeWithETag(res, req, c.cacheControl(ttl), func() bool {
+			res.Write(entry.Body)
+			return true
+		})
+		return
+	}
+
+	// the read begins before the client is taken, so that it is not stored if the cache is reset meanwhile
+	fill := c.valueCache.lru.Begin(db, key)
+	defer fill.Done()
+	tracked, ok := c.valueCache.client(db)
+	if !ok {
+		c.serveValue(res, req, client, key, field, f)
+		return
+	}
+
+	valueCacheMissesTotal.Inc()
+	entry, ok := c.serveValue(res, req, tracked, key, field, f)
+	if !ok {
+		return
+	}
+	if _, evicted := fill.Store(variant, entry); evicted > 0 {
+		valueCacheEvictionsTotal.Add(float64(evicted), evictionCapacity)
+	}
+	c.valueCache.observe()
+}